@@ -286,6 +286,11 @@ class MQ extends EventEmitter {
                 }
                 break;
                 
+            case 'PING':
+                // heartbeat do broker: sem resposta a conexão é derrubada
+                this.send({ cmd: 'PONG', requestId: data.requestId, payload: 'PONG' });
+                break;
+
             case 'PONG':
                 if (this.chs[data.requestId]) {
                     this.chs[data.requestId].resolve(data.payload);
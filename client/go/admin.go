@@ -0,0 +1,118 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ConnStat é uma conexão listada por Connections.
+type ConnStat struct {
+	ID         string    `json:"id"`
+	RemoteAddr string    `json:"remoteAddr"`
+	User       string    `json:"user"`
+	Name       string    `json:"name"`
+	Connected  time.Time `json:"connected"`
+	BytesIn    uint64    `json:"bytesIn"`
+	BytesOut   uint64    `json:"bytesOut"`
+}
+
+// ConnInterest são as inscrições e serviços de uma conexão.
+type ConnInterest struct {
+	Subscriptions []string `json:"subscriptions"`
+	Services      []string `json:"services"`
+}
+
+// WhoAmI descreve esta conexão e as permissões do usuário.
+type WhoAmI struct {
+	ID         string    `json:"id"`
+	User       string    `json:"user"`
+	Name       string    `json:"name"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Connected  time.Time `json:"connected"`
+	IsAdmin    bool      `json:"isAdmin"`
+	Publish    []string  `json:"publish"`
+	Subscribe  []string  `json:"subscribe"`
+}
+
+// Connections lista as conexões do broker (só admin).
+func (mq *MQ) Connections() ([]ConnStat, error) {
+	conns := []ConnStat{}
+	str, err := mq.admin("A_CONNS", "")
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(str), &conns)
+	return conns, err
+}
+
+// Subscriptions devolve inscrições e serviços por id de conexão; com id
+// vazio traz todas (só admin).
+func (mq *MQ) Subscriptions(id string) (map[string]ConnInterest, error) {
+	interest := map[string]ConnInterest{}
+	str, err := mq.admin("A_SUBS", id)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(str), &interest)
+	return interest, err
+}
+
+// Kick derruba a conexão com esse id (só admin).
+func (mq *MQ) Kick(id string) error {
+	_, err := mq.admin("A_KICK", id)
+	return err
+}
+
+// ReloadReport é o resultado de Reload: o que foi aplicado e o que só
+// vale depois de reiniciar o broker.
+type ReloadReport struct {
+	Applied []string `json:"applied"`
+	Restart []string `json:"restart"`
+}
+
+// Reload faz o broker reler o arquivo de configuração, como o SIGHUP (só
+// admin).
+func (mq *MQ) Reload() (ReloadReport, error) {
+	report := ReloadReport{}
+	str, err := mq.admin("A_RELOAD", "")
+	if err != nil {
+		return report, err
+	}
+	err = json.Unmarshal([]byte(str), &report)
+	return report, err
+}
+
+// WhoAmI mostra o usuário e as permissões desta conexão.
+func (mq *MQ) WhoAmI() (WhoAmI, error) {
+	me := WhoAmI{}
+	str, err := mq.admin("WHOAMI", "")
+	if err != nil {
+		return me, err
+	}
+	err = json.Unmarshal([]byte(str), &me)
+	return me, err
+}
+
+func (mq *MQ) admin(cmd, topic string) (string, error) {
+	reqId := uuid.New().String()
+	ch := mq.expect(reqId)
+	mq.Send(MQData{
+		Cmd:       cmd,
+		Topic:     topic,
+		RequestId: reqId,
+	})
+
+	select {
+	case res := <-ch:
+		if res.Error != "" {
+			return "", serverError(res.Error, res.Code)
+		}
+		return res.Payload, nil
+	case <-time.After(2 * time.Second):
+		mq.forget(reqId)
+		return "", fmt.Errorf("%w %s", ErrTimeout, cmd)
+	}
+}
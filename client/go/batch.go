@@ -0,0 +1,89 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Batch acumula publicações, KV.Set e inserts de coleção para enviar num
+// único frame BATCH.
+type Batch struct {
+	mq    *MQ
+	items []MQData
+}
+
+func (mq *MQ) Batch() *Batch {
+	return &Batch{mq: mq}
+}
+
+func (b *Batch) Publish(topic, payload string) *Batch {
+	b.items = append(b.items, MQData{Cmd: "PUB", Topic: topic, Payload: payload})
+	return b
+}
+
+// Set equivale a mq.Kv(bucket).Set(key, value).
+func (b *Batch) Set(bucket, key, value string) *Batch {
+	topic := key
+	if bucket != "" {
+		topic = bucket + ":" + key
+	}
+	b.items = append(b.items, MQData{Cmd: "SET", Topic: topic, Payload: value})
+	return b
+}
+
+// Insert equivale a mq.DbCollection(collection).Insert(doc).
+func (b *Batch) Insert(collection string, doc Document) *Batch {
+	strInput, _ := json.Marshal(doc)
+	b.items = append(b.items, MQData{Cmd: "DB_CI", Topic: collection, Payload: string(strInput)})
+	return b
+}
+
+func (b *Batch) Len() int {
+	return len(b.items)
+}
+
+// Send envia o lote; cada item tem seu próprio resultado, na mesma ordem.
+func (b *Batch) Send(timeout time.Duration) ([]MQResponse, error) {
+	return b.send(false, timeout)
+}
+
+// SendTx envia o lote gravando os itens SET/DB_CI numa única transação:
+// ou todos são gravados ou todos os resultados trazem o erro.
+func (b *Batch) SendTx(timeout time.Duration) ([]MQResponse, error) {
+	return b.send(true, timeout)
+}
+
+func (b *Batch) send(tx bool, timeout time.Duration) ([]MQResponse, error) {
+	strInput, err := json.Marshal(map[string]interface{}{
+		"tx":    tx,
+		"items": b.items,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mq := b.mq
+	reqId := uuid.New().String()
+	ch := mq.expect(reqId)
+	mq.Send(MQData{
+		Cmd:       "BATCH",
+		RequestId: reqId,
+		Payload:   string(strInput),
+	})
+
+	select {
+	case res := <-ch:
+		if res.Error != "" {
+			return nil, serverError(res.Error, res.Code)
+		}
+		results := []MQResponse{}
+		err := json.Unmarshal([]byte(res.Payload), &results)
+		return results, err
+	case <-time.After(timeout):
+		mq.forget(reqId)
+		return nil, fmt.Errorf("%w de %v expirado no lote", ErrTimeout, timeout)
+	}
+}
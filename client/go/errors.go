@@ -0,0 +1,59 @@
+package client
+
+import "errors"
+
+// Erros pelo campo code das respostas do broker; servem para errors.Is sem
+// depender da mensagem, que pode mudar.
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrInvalidRequest   = errors.New("invalid request")
+	ErrTimeout          = errors.New("timeout")
+	ErrNoResponders     = errors.New("no responders")
+	ErrLimitExceeded    = errors.New("limit exceeded")
+	ErrConflict         = errors.New("conflict")
+	ErrInternal         = errors.New("internal error")
+)
+
+var codeErrors = map[string]error{
+	"not_found":         ErrNotFound,
+	"permission_denied": ErrPermissionDenied,
+	"invalid_request":   ErrInvalidRequest,
+	"timeout":           ErrTimeout,
+	"no_responders":     ErrNoResponders,
+	"limit_exceeded":    ErrLimitExceeded,
+	"conflict":          ErrConflict,
+	"internal":          ErrInternal,
+}
+
+// ServerError é um erro devolvido pelo broker: Code é estável, Message é
+// para humanos.
+type ServerError struct {
+	Code    string
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return "Error :" + e.Message
+}
+
+// Is casa com o erro do código (ErrNotFound...).
+func (e *ServerError) Is(target error) bool {
+	err, ok := codeErrors[e.Code]
+	return ok && err == target
+}
+
+// Unwrap devolve o erro do limite quando a mensagem é de um (ErrThrottled...).
+func (e *ServerError) Unwrap() error {
+	for _, err := range limitErrors {
+		if e.Message == err.Error() {
+			return err
+		}
+	}
+	return nil
+}
+
+// serverError monta o erro de uma resposta do broker.
+func serverError(msg, code string) error {
+	return &ServerError{Code: code, Message: msg}
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Erros dos limites do broker; servem para errors.Is nos erros devolvidos.
+var (
+	ErrPayloadTooLarge  = errors.New("payload too large")
+	ErrLineTooLong      = errors.New("frame too long")
+	ErrMaxConnections   = errors.New("maximum connections exceeded")
+	ErrMaxSubscriptions = errors.New("maximum subscriptions exceeded")
+	ErrMaxServices      = errors.New("maximum services exceeded")
+	ErrThrottled        = errors.New("rate limit exceeded")
+)
+
+var limitErrors = []error{
+	ErrPayloadTooLarge, ErrLineTooLong, ErrMaxConnections,
+	ErrMaxSubscriptions, ErrMaxServices, ErrThrottled,
+}
+
+// setLimits guarda os limites anunciados no CNN.
+func (mq *MQ) setLimits(data MQData) {
+	max, _ := strconv.Atoi(data.Headers["max-payload"])
+	mq.maxPayload.Store(int64(max))
+}
+
+// checkPayload recusa, antes de enviar, um payload que o broker recusaria.
+func (mq *MQ) checkPayload(payload string) error {
+	if max := mq.maxPayload.Load(); max > 0 && int64(len(payload)) > max {
+		return fmt.Errorf("%w: %d bytes, max %d", ErrPayloadTooLarge, len(payload), max)
+	}
+	return nil
+}
@@ -3,10 +3,10 @@ package client
 import (
 	"bufio"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,14 +14,17 @@ import (
 
 type Document map[string]interface{}
 type MQData struct {
-	Cmd       string `json:"cmd"`
-	Topic     string `json:"topic"`
-	Payload   string `json:"payload"`
-	RequestId string `json:"requestId"`
-	ReplayId  string `json:"replayId"`
-	Regtopic  string `json:"regtopic"`
-	Error     string `json:"error"`
-	FromId    string `json:"fromId"`
+	Cmd       string            `json:"cmd"`
+	Topic     string            `json:"topic"`
+	Payload   string            `json:"payload"`
+	RequestId string            `json:"requestId"`
+	ReplayId  string            `json:"replayId"`
+	Regtopic  string            `json:"regtopic"`
+	Error     string            `json:"error"`
+	Code      string            `json:"code,omitempty"`
+	FromId    string            `json:"fromId"`
+	ToId      string            `json:"toId,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
 }
 
 type JSData struct {
@@ -34,6 +37,7 @@ type JSData struct {
 type MQResponse struct {
 	Payload string `json:"payload"`
 	Error   string `json:"error"`
+	Code    string `json:"code,omitempty"`
 }
 type DbCollection struct {
 	name string
@@ -83,13 +87,82 @@ func structToJSON(mq MQData) (string, error) {
 type MQ struct {
 	ID        string
 	conn      net.Conn
+	connMu    sync.Mutex
+	auth      *MQAUTH
+	opts      options
+	session   string
+	token     string
+	stopped   bool
+	server    int // índice em servers() do broker atual
 	subs      map[string][]func(msg MQData)
-	chs       map[string]chan string     // cria um canal de string
-	chrequest map[string]chan MQResponse // cria um canal de string
+	chrequest map[string]chan MQResponse // respostas esperadas, por RequestId
+	reqMu     sync.Mutex                 // protege chrequest
 	services  map[string]func(msg MQData, replay func(err string, payload string))
+	acks      map[string]*PubAckFuture
+	acksMu    sync.Mutex
+	direct    []func(msg MQData)
+
+	maxPayload atomic.Int64 // anunciado pelo broker no CNN; 0 não sabe
+}
+
+type options struct {
+	headers       map[string]string
+	reconnect     bool
+	reconnectWait time.Duration
+	maxReconnects int
+	servers       []string // host:porta alternativos para reconectar
+	onGoAway      func(reason string)
+}
+
+// Option configura a conexão em Dial.
+type Option func(o *options)
+
+// WithName dá um nome à conexão, visível nos eventos $SYS.conn.*.
+func WithName(name string) Option {
+	return func(o *options) {
+		o.headers["name"] = name
+	}
+}
+
+// WithWill registra uma mensagem que o broker publica se a conexão cair sem
+// um Stop.
+func WithWill(topic, payload string) Option {
+	return func(o *options) {
+		o.headers["will-topic"] = topic
+		o.headers["will-payload"] = payload
+	}
 }
 
-func Dial(url string) (*MQ, error) {
+// WithReconnect reconecta automaticamente quando a conexão cai, esperando
+// wait entre as tentativas (maxAttempts <= 0 tenta para sempre). Se o
+// broker ainda guardar a sessão ela é retomada; senão as inscrições e
+// serviços são registrados de novo.
+func WithReconnect(wait time.Duration, maxAttempts int) Option {
+	return func(o *options) {
+		o.reconnect = true
+		o.reconnectWait = wait
+		o.maxReconnects = maxAttempts
+	}
+}
+
+// WithServers lista outros brokers (host:porta, mesmos usuários) para onde
+// a reconexão pode ir quando o atual cai ou manda GOAWAY. Só vale junto com
+// WithReconnect.
+func WithServers(addrs ...string) Option {
+	return func(o *options) {
+		o.servers = append(o.servers, addrs...)
+	}
+}
+
+// WithGoAway avisa quando o broker manda GOAWAY antes de encerrar; reason
+// é o motivo que ele mandou.
+func WithGoAway(cb func(reason string)) Option {
+	return func(o *options) {
+		o.onGoAway = cb
+	}
+}
+
+func Dial(url string, opts ...Option) (*MQ, error) {
 
 	info, err := ParseMQURL(url)
 	if err != nil {
@@ -101,14 +174,20 @@ func Dial(url string) (*MQ, error) {
 	}
 	mq := MQ{
 		conn:      conn,
-		chs:       make(map[string]chan string),
+		auth:      info,
+		opts:      options{headers: map[string]string{}},
 		chrequest: make(map[string]chan MQResponse),
 		services:  map[string]func(msg MQData, replay func(err string, payload string)){},
 		subs:      make(map[string][]func(msg MQData)),
+		acks:      make(map[string]*PubAckFuture),
+	}
+
+	for _, opt := range opts {
+		opt(&mq.opts)
 	}
 
 	go mq.on()
-	_, err = mq.connect(info.User, info.Pass, 1*time.Second)
+	_, err = mq.connect(info.User, info.Pass, mq.opts.headers, 1*time.Second)
 	if err != nil {
 		return nil, err
 	}
@@ -120,7 +199,7 @@ func (mq *MQ) Send(data MQData) error {
 	if err != nil {
 		return err
 	}
-	_, err = mq.conn.Write([]byte(str + "\n"))
+	_, err = mq.getConn().Write([]byte(str + "\n"))
 	return err
 }
 
@@ -142,87 +221,147 @@ func (mq *MQ) Subscribe(topic string, cb func(msg MQData)) {
 	})
 }
 
-func (mq *MQ) Publish(topic, Payload string) {
-	mq.Send(MQData{
+// Publish envia sem confirmação; só falha se o payload passar do limite do
+// broker ou a escrita falhar.
+func (mq *MQ) Publish(topic, Payload string) error {
+	if err := mq.checkPayload(Payload); err != nil {
+		return err
+	}
+	return mq.Send(MQData{
 		Cmd:     "PUB",
 		Topic:   topic,
 		Payload: Payload,
 	})
 }
-func (mq *MQ) connect(username, password string, timeout time.Duration) (string, error) {
+
+// OnMessage registra um callback para mensagens diretas (SendTo) recebidas
+// por esta conexão; msg.FromId é o id de quem enviou.
+func (mq *MQ) OnMessage(cb func(msg MQData)) {
+	mq.direct = append(mq.direct, cb)
+}
+
+// SendTo entrega uma mensagem apenas à conexão com esse id.
+func (mq *MQ) SendTo(id, topic, payload string) error {
+	if err := mq.checkPayload(payload); err != nil {
+		return err
+	}
+	reqId := uuid.New().String()
+	ch := mq.expect(reqId)
+	mq.Send(MQData{
+		Cmd:       "SEND",
+		Topic:     topic,
+		ToId:      id,
+		RequestId: reqId,
+		Payload:   payload,
+	})
+
+	select {
+	case res := <-ch:
+		if res.Error != "" {
+			return serverError(res.Error, res.Code)
+		}
+		return nil
+	case <-time.After(2 * time.Second):
+		mq.forget(reqId)
+		return fmt.Errorf("%w SendTo", ErrTimeout)
+	}
+}
+
+func (mq *MQ) connect(username, password string, headers map[string]string, timeout time.Duration) (string, error) {
 	reqId := uuid.New().String()
-	mq.chrequest[reqId] = make(chan MQResponse) // cria um canal de string
+	ch := mq.expect(reqId)
 	mq.Send(MQData{
 		Cmd:       "AUTH",
 		Topic:     username,
 		RequestId: reqId,
 		Payload:   password,
+		Headers:   headers,
 	})
-	ch, existe := mq.chrequest[reqId]
-	if !existe {
-		return "", fmt.Errorf("canal %s não existe", username)
-	}
 
 	select {
 	case res := <-ch:
-		close(ch)
 		if res.Error != "" {
-			return "", errors.New("Error :" + res.Error)
+			return "", serverError(res.Error, res.Code)
 		}
 		return res.Payload, nil
 	case <-time.After(timeout):
-		close(ch)
-		return "", fmt.Errorf("timeout de %v expirado no canal %s", timeout, username)
+		mq.forget(reqId)
+		return "", fmt.Errorf("%w de %v expirado no canal %s", ErrTimeout, timeout, username)
 	}
 }
 func (mq *MQ) Request(topic, Payload string, timeout time.Duration) (string, error) {
+	if err := mq.checkPayload(Payload); err != nil {
+		return "", err
+	}
 	reqId := uuid.New().String()
-	mq.chrequest[reqId] = make(chan MQResponse) // cria um canal de string
+	ch := mq.expect(reqId)
 	mq.Send(MQData{
 		Cmd:       "REQ",
 		Topic:     topic,
 		RequestId: reqId,
 		Payload:   Payload,
+		// o broker usa o mesmo prazo para contar o timeout nas métricas
+		Headers: map[string]string{"timeout": timeout.String()},
 	})
-	ch, existe := mq.chrequest[reqId]
-	if !existe {
-		return "", fmt.Errorf("canal %s não existe", topic)
-	}
 
 	select {
 	case res := <-ch:
-		close(ch)
 		if res.Error != "" {
-			return "", errors.New("Error :" + res.Error)
+			return "", serverError(res.Error, res.Code)
 		}
 		return res.Payload, nil
 	case <-time.After(timeout):
-		close(ch)
-		return "", fmt.Errorf("timeout de %v expirado no canal %s", timeout, topic)
+		mq.forget(reqId)
+		return "", fmt.Errorf("%w de %v expirado no canal %s", ErrTimeout, timeout, topic)
 	}
 }
 
+// expect registra a espera pela resposta de reqId. O canal tem buffer: o
+// read loop nunca bloqueia entregando a resposta.
+func (mq *MQ) expect(reqId string) chan MQResponse {
+	ch := make(chan MQResponse, 1)
+	mq.reqMu.Lock()
+	mq.chrequest[reqId] = ch
+	mq.reqMu.Unlock()
+	return ch
+}
+
+// forget desiste da resposta de reqId; se ela chegar depois, é descartada.
+func (mq *MQ) forget(reqId string) {
+	mq.reqMu.Lock()
+	delete(mq.chrequest, reqId)
+	mq.reqMu.Unlock()
+}
+
+// resolve entrega a resposta a quem espera reqId. Devolve false se ninguém
+// espera (timeout ou resposta que não é de um pedido).
+func (mq *MQ) resolve(reqId string, res MQResponse) bool {
+	mq.reqMu.Lock()
+	ch, ok := mq.chrequest[reqId]
+	delete(mq.chrequest, reqId)
+	mq.reqMu.Unlock()
+	if ok {
+		ch <- res
+	}
+	return ok
+}
+
 func (mq *MQ) Ping() (string, error) {
 	reqId := uuid.New().String()
-	mq.chs[reqId] = make(chan string) // cria um canal de string
+	ch := mq.expect(reqId)
 	mq.Send(MQData{
 		Cmd:       "PING",
 		Topic:     "",
 		RequestId: reqId,
 		Payload:   "",
 	})
-	ch, existe := mq.chs[reqId]
-	if !existe {
-		return "", fmt.Errorf("canal %s não existe", "")
-	}
 
 	select {
 	case res := <-ch:
-		close(ch)
-		return res, nil
+		return res.Payload, nil
 	case <-time.After(1 * time.Second):
-		close(ch)
-		return "", fmt.Errorf("timeout de %v expirado no canal", 1)
+		mq.forget(reqId)
+		return "", fmt.Errorf("%w de %v expirado no canal", ErrTimeout, 1)
 	}
 }
 
@@ -231,7 +370,7 @@ func (mq *MQ) Script(name string) *ScriptJS {
 	return &ScriptJS{
 		send: func(name, key, value, type_ string) (string, error) {
 			reqId := uuid.New().String()
-			mq.chrequest[reqId] = make(chan MQResponse) // cria um canal de string
+			ch := mq.expect(reqId)
 			tipic := ""
 			if name != "" {
 				tipic = name
@@ -248,21 +387,16 @@ func (mq *MQ) Script(name string) *ScriptJS {
 				RequestId: reqId,
 				Payload:   value,
 			})
-			ch, existe := mq.chrequest[reqId]
-			if !existe {
-				return "", fmt.Errorf("canal %s não existe", "")
-			}
 
 			select {
 			case res := <-ch:
-				close(ch)
 				if res.Error != "" {
-					return "", errors.New("Error :" + res.Error)
+					return "", serverError(res.Error, res.Code)
 				}
 				return res.Payload, nil
 			case <-time.After(2 * time.Second):
-				close(ch)
-				return "", fmt.Errorf("timeout DbCreateCollection")
+				mq.forget(reqId)
+				return "", fmt.Errorf("%w DbCreateCollection", ErrTimeout)
 			}
 		},
 		name: name,
@@ -330,7 +464,7 @@ func (mq *MQ) Kv(bucket string) *KV {
 	return &KV{
 		send: func(bucket, key, value, type_ string) (string, error) {
 			reqId := uuid.New().String()
-			mq.chrequest[reqId] = make(chan MQResponse) // cria um canal de string
+			ch := mq.expect(reqId)
 			tipic := ""
 			if bucket != "" {
 				tipic = bucket
@@ -347,21 +481,16 @@ func (mq *MQ) Kv(bucket string) *KV {
 				RequestId: reqId,
 				Payload:   value,
 			})
-			ch, existe := mq.chrequest[reqId]
-			if !existe {
-				return "", fmt.Errorf("canal %s não existe", "")
-			}
 
 			select {
 			case res := <-ch:
-				close(ch)
 				if res.Error != "" {
-					return "", errors.New("Error :" + res.Error)
+					return "", serverError(res.Error, res.Code)
 				}
 				return res.Payload, nil
 			case <-time.After(2 * time.Second):
-				close(ch)
-				return "", fmt.Errorf("timeout DbCreateCollection")
+				mq.forget(reqId)
+				return "", fmt.Errorf("%w DbCreateCollection", ErrTimeout)
 			}
 		},
 		bucket: bucket,
@@ -422,54 +551,44 @@ func (kv *KV) DeleteBucket() (string, error) {
 // ///////////////////////////////////////
 func (mq *MQ) DbCreateCollection(name, indexName string) error {
 	reqId := uuid.New().String()
-	mq.chrequest[reqId] = make(chan MQResponse) // cria um canal de string
+	ch := mq.expect(reqId)
 	mq.Send(MQData{
 		Cmd:       "DB_CC",
 		Topic:     name,
 		RequestId: reqId,
 		Payload:   indexName,
 	})
-	ch, existe := mq.chrequest[reqId]
-	if !existe {
-		return fmt.Errorf("canal %s não existe", "")
-	}
 
 	select {
 	case res := <-ch:
-		close(ch)
 		if res.Error != "" {
-			return errors.New("Error :" + res.Error)
+			return serverError(res.Error, res.Code)
 		}
 		return nil
 	case <-time.After(2 * time.Second):
-		close(ch)
-		return fmt.Errorf("timeout DbCreateCollection")
+		mq.forget(reqId)
+		return fmt.Errorf("%w DbCreateCollection", ErrTimeout)
 	}
 }
 func (mq *MQ) DbDeleteCollection(name string) error {
 	reqId := uuid.New().String()
-	mq.chrequest[reqId] = make(chan MQResponse) // cria um canal de string
+	ch := mq.expect(reqId)
 	mq.Send(MQData{
 		Cmd:       "DB_CC",
 		Topic:     name,
 		RequestId: reqId,
 		Payload:   "",
 	})
-	ch, existe := mq.chrequest[reqId]
-	if !existe {
-		return fmt.Errorf("canal %s não existe", "")
-	}
 
 	select {
 	case res := <-ch:
-		close(ch)
 		if res.Error != "" {
-			return errors.New("Error :" + res.Error)
+			return serverError(res.Error, res.Code)
 		}
 		return nil
 	case <-time.After(2 * time.Second):
-		close(ch)
-		return fmt.Errorf("timeout de %v expirado no canal", 1)
+		mq.forget(reqId)
+		return fmt.Errorf("%w de %v expirado no canal", ErrTimeout, 1)
 	}
 }
 
@@ -477,28 +596,23 @@ func (mq *MQ) DbCollection(name string) *DbCollection {
 	return &DbCollection{
 		send: func(collection, data, type_ string) (string, error) {
 			reqId := uuid.New().String()
-			mq.chrequest[reqId] = make(chan MQResponse) // cria um canal de string
+			ch := mq.expect(reqId)
 			mq.Send(MQData{
 				Cmd:       type_,
 				Topic:     collection,
 				RequestId: reqId,
 				Payload:   data,
 			})
-			ch, existe := mq.chrequest[reqId]
-			if !existe {
-				return "", fmt.Errorf("canal %s não existe", "")
-			}
 
 			select {
 			case res := <-ch:
-				close(ch)
 				if res.Error != "" {
-					return "", errors.New("Error :" + res.Error)
+					return "", serverError(res.Error, res.Code)
 				}
 				return res.Payload, nil
 			case <-time.After(2 * time.Second):
-				close(ch)
-				return "", fmt.Errorf("timeout DbCreateCollection")
+				mq.forget(reqId)
+				return "", fmt.Errorf("%w DbCreateCollection", ErrTimeout)
 			}
 		},
 		name: name,
@@ -562,7 +676,15 @@ func (db *DbCollection) Delete(id string) (string, error) {
 
 // //////////////////////
 func (mq *MQ) on() {
-	reader := bufio.NewReader(mq.conn)
+	reader := bufio.NewReader(mq.getConn())
+	for reader != nil {
+		mq.read(reader)
+		reader = mq.reconnect()
+	}
+}
+
+// read trata os frames até a conexão cair.
+func (mq *MQ) read(reader *bufio.Reader) {
 	for {
 		// Lê a mensagem do cliente até encontrar uma nova linha
 		str, err := reader.ReadString('\n')
@@ -574,64 +696,63 @@ func (mq *MQ) on() {
 		data, err := jsonToStruct(str)
 		if err != nil {
 			fmt.Printf("Erro ao ler: %s\n", err.Error())
-			return
+			continue
 		}
 		switch data.Cmd {
 		case "CNN":
 
 			mq.ID = data.Payload
-			ch, existe := mq.chrequest[data.RequestId]
-			if !existe {
-				return
-			}
-
-			ch <- MQResponse{
+			mq.setSession(*data)
+			mq.setLimits(*data)
+			mq.resolve(data.RequestId, MQResponse{
 				Payload: data.Payload,
 				Error:   data.Error,
-			}
+				Code:    data.Code,
+			})
 		case "OK":
 			//fmt.Println(data)
-		case "ER_AUH":
-			mq.ID = data.Payload
-			ch, existe := mq.chrequest[data.RequestId]
-			if !existe {
-				panic(data.Payload)
-				return
-			}
-
-			ch <- MQResponse{
-				Payload: data.Payload,
-				Error:   data.Error,
+		case "GOAWAY":
+			// o broker está encerrando: as respostas em andamento ainda
+			// chegam por esta conexão, mas a próxima vai para outro broker
+			mq.nextServer()
+			if cb := mq.opts.onGoAway; cb != nil {
+				go cb(data.Payload)
 			}
+		case "ER_AUH":
+			// o Dial devolve o erro; a conexão é encerrada sem derrubar o processo
+			mq.resolve(data.RequestId, MQResponse{Error: data.Payload, Code: data.Code})
 			mq.Stop()
-			panic(data.Payload)
-			//fmt.Println(data)
+			return
 		case "S_ADD", "S_DEL", "S_ENV", "S_JS", "S_RUN", "S_STOP", "S_APP":
-			ch, existe := mq.chrequest[data.RequestId]
-			if !existe {
-				return
-			}
-			ch <- MQResponse{
+			mq.resolve(data.RequestId, MQResponse{
 				Payload: data.Payload,
 				Error:   data.Error,
-			}
-		case "RES", "SET", "GET", "DEL", "BDEL", "BADD", "BFK", "BFV", "DB_CC", "DB_CD", "DB_CI", "DB_CG", "DB_CR", "DB_CF", "DB_CU", "DB_CL":
-			ch, existe := mq.chrequest[data.RequestId]
-			if !existe {
-				return
-			}
-
-			ch <- MQResponse{
+				Code:    data.Code,
+			})
+		case "RES", "BATCH", "SEND", "A_CONNS", "A_SUBS", "A_KICK", "A_RELOAD", "WHOAMI", "SET", "GET", "DEL", "BDEL", "BADD", "BFK", "BFV", "DB_CC", "DB_CD", "DB_CI", "DB_CG", "DB_CR", "DB_CF", "DB_CU", "DB_CL":
+			mq.resolve(data.RequestId, MQResponse{
 				Payload: data.Payload,
 				Error:   data.Error,
-			}
+				Code:    data.Code,
+			})
 
-		case "PONG":
-			ch, existe := mq.chs[data.RequestId]
-			if !existe {
-				return
+		case "THROTTLE", "ERR":
+			// frame recusado pelo rate limit ou pelos limites do broker:
+			// quem espera a resposta (request ou ack do APUB) recebe o erro
+			if !mq.resolve(data.RequestId, MQResponse{Error: data.Error, Code: data.Code}) {
+				mq.handleAck(*data)
 			}
-			ch <- data.Payload
+		case "APUB":
+			mq.handleAck(*data)
+		case "MSG":
+			for _, cb := range mq.direct {
+				go cb(*data)
+			}
+		case "PING":
+			// heartbeat do broker: sem resposta a conexão é derrubada
+			mq.Send(MQData{Cmd: "PONG", RequestId: data.RequestId, Payload: "PONG"})
+		case "PONG":
+			mq.resolve(data.RequestId, MQResponse{Payload: data.Payload})
 		case "REQ":
 			if mq.services[data.Topic] != nil {
 				mq.services[data.Topic](*data, func(err string, payload string) {
@@ -647,7 +768,7 @@ func (mq *MQ) on() {
 			}
 		case "PUB":
 			topic := data.Topic
-			if strings.Contains(data.Regtopic, ".*.") {
+			if data.Regtopic != "" {
 				topic = data.Regtopic
 			}
 			for _, sub := range mq.subs[topic] {
@@ -658,6 +779,11 @@ func (mq *MQ) on() {
 	}
 }
 
+// Stop encerra a conexão de forma limpa; o last-will não é publicado.
 func (mq *MQ) Stop() error {
-	return mq.conn.Close()
+	mq.connMu.Lock()
+	mq.stopped = true
+	mq.connMu.Unlock()
+	mq.Send(MQData{Cmd: "STOP"})
+	return mq.getConn().Close()
 }
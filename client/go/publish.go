@@ -0,0 +1,119 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RejectedError é a recusa do broker a uma publicação (tópico inválido,
+// sem permissão), diferente de um timeout ou de uma falha de conexão.
+type RejectedError struct {
+	Reason string
+	Code   string
+}
+
+func (e *RejectedError) Error() string {
+	return "Error :" + e.Reason
+}
+
+// Unwrap deixa errors.Is casar as recusas pelo código (ErrPermissionDenied...)
+// e por limite (ErrThrottled...).
+func (e *RejectedError) Unwrap() error {
+	return serverError(e.Reason, e.Code)
+}
+
+// PubAckFuture é o resultado de um PublishAsync, resolvido quando o broker
+// confirma (ou recusa) a publicação.
+type PubAckFuture struct {
+	RequestId string
+	Topic     string
+	mq        *MQ
+	done      chan struct{}
+	err       error
+}
+
+// Done fecha quando a confirmação chega.
+func (f *PubAckFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Err retorna o erro da confirmação; só é válido depois de Done.
+func (f *PubAckFuture) Err() error {
+	return f.err
+}
+
+// Wait espera a confirmação até o timeout. Depois do timeout uma
+// confirmação atrasada é descartada.
+func (f *PubAckFuture) Wait(timeout time.Duration) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-time.After(timeout):
+		if f.mq.takeAck(f.RequestId) != nil {
+			f.resolve(fmt.Errorf("%w de %v expirado no canal %s", ErrTimeout, timeout, f.Topic))
+		}
+		<-f.done
+		return f.err
+	}
+}
+
+func (f *PubAckFuture) resolve(err error) {
+	f.err = err
+	close(f.done)
+}
+
+// PublishAsync publica pedindo confirmação ao broker, sem bloquear.
+func (mq *MQ) PublishAsync(topic, payload string) *PubAckFuture {
+	future := &PubAckFuture{
+		RequestId: uuid.New().String(),
+		Topic:     topic,
+		mq:        mq,
+		done:      make(chan struct{}),
+	}
+	if err := mq.checkPayload(payload); err != nil {
+		future.resolve(err)
+		return future
+	}
+	mq.acksMu.Lock()
+	mq.acks[future.RequestId] = future
+	mq.acksMu.Unlock()
+
+	err := mq.Send(MQData{
+		Cmd:       "APUB",
+		Topic:     topic,
+		RequestId: future.RequestId,
+		Payload:   payload,
+	})
+	if err != nil {
+		mq.takeAck(future.RequestId)
+		future.resolve(err)
+	}
+	return future
+}
+
+// PublishSync publica e espera a confirmação do broker.
+func (mq *MQ) PublishSync(topic, payload string, timeout time.Duration) error {
+	return mq.PublishAsync(topic, payload).Wait(timeout)
+}
+
+func (mq *MQ) takeAck(reqId string) *PubAckFuture {
+	mq.acksMu.Lock()
+	defer mq.acksMu.Unlock()
+	future := mq.acks[reqId]
+	delete(mq.acks, reqId)
+	return future
+}
+
+func (mq *MQ) handleAck(data MQData) {
+	future := mq.takeAck(data.RequestId)
+	if future == nil {
+		return
+	}
+	if data.Error != "" {
+		future.resolve(&RejectedError{Reason: data.Error, Code: data.Code})
+		return
+	}
+	future.resolve(nil)
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"time"
+)
+
+func (mq *MQ) getConn() net.Conn {
+	mq.connMu.Lock()
+	defer mq.connMu.Unlock()
+	return mq.conn
+}
+
+func (mq *MQ) setSession(data MQData) {
+	mq.connMu.Lock()
+	defer mq.connMu.Unlock()
+	mq.session = data.Headers["session"]
+	mq.token = data.Headers["resume-token"]
+}
+
+// reconnect tenta voltar a conectar depois de uma queda; devolve o reader
+// da nova conexão ou nil se não deve (ou não conseguiu) reconectar.
+func (mq *MQ) reconnect() *bufio.Reader {
+	if !mq.opts.reconnect {
+		return nil
+	}
+	for attempt := 1; mq.opts.maxReconnects <= 0 || attempt <= mq.opts.maxReconnects; attempt++ {
+		time.Sleep(mq.opts.reconnectWait)
+		mq.connMu.Lock()
+		stopped := mq.stopped
+		mq.connMu.Unlock()
+		if stopped {
+			return nil
+		}
+		reader, err := mq.redial()
+		if err == nil {
+			return reader
+		}
+		fmt.Printf("Erro ao reconectar: %s\n", err.Error())
+		mq.nextServer()
+	}
+	return nil
+}
+
+// servers é o broker da URL seguido dos de WithServers.
+func (mq *MQ) servers() []string {
+	return append([]string{mq.auth.Host + ":" + mq.auth.Port}, mq.opts.servers...)
+}
+
+func (mq *MQ) nextServer() {
+	mq.connMu.Lock()
+	defer mq.connMu.Unlock()
+	mq.server = (mq.server + 1) % len(mq.servers())
+}
+
+func (mq *MQ) redial() (*bufio.Reader, error) {
+	mq.connMu.Lock()
+	addr := mq.servers()[mq.server]
+	mq.connMu.Unlock()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	headers := map[string]string{}
+	for k, v := range mq.opts.headers {
+		headers[k] = v
+	}
+	mq.connMu.Lock()
+	if mq.session != "" {
+		headers["session"] = mq.session
+		headers["resume-token"] = mq.token
+	}
+	mq.connMu.Unlock()
+
+	str, _ := structToJSON(MQData{
+		Cmd:     "AUTH",
+		Topic:   mq.auth.User,
+		Payload: mq.auth.Pass,
+		Headers: headers,
+	})
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	_, err = conn.Write([]byte(str + "\n"))
+	if err == nil {
+		str, err = reader.ReadString('\n')
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	data, err := jsonToStruct(str)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if data.Cmd != "CNN" {
+		conn.Close()
+		return nil, serverError(data.Payload, data.Code)
+	}
+
+	mq.connMu.Lock()
+	old := mq.conn
+	mq.conn = conn
+	mq.connMu.Unlock()
+	old.Close()
+	mq.ID = data.Payload
+	mq.setSession(*data)
+	if data.Headers["resumed"] != "true" {
+		mq.resubscribe()
+	}
+	return reader, nil
+}
+
+// resubscribe registra de novo inscrições e serviços numa sessão nova.
+func (mq *MQ) resubscribe() {
+	for topic := range mq.subs {
+		mq.Send(MQData{Cmd: "SUB", Topic: topic})
+	}
+	for topic := range mq.services {
+		mq.Send(MQData{Cmd: "SER", Topic: topic})
+	}
+}
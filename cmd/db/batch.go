@@ -0,0 +1,76 @@
+package db
+
+import (
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+const (
+	BatchSet    = "SET"
+	BatchInsert = "DB_CI"
+)
+
+// BatchOp é uma escrita de um lote: BatchSet usa Bucket/Key/Value e
+// BatchInsert usa Collection/Doc.
+type BatchOp struct {
+	Kind       string
+	Bucket     string
+	Key        string
+	Value      string
+	Collection string
+	Doc        Document
+}
+
+// BatchError indica qual operação do lote falhou.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// Batch aplica as operações em ordem numa única transação. Se uma falhar
+// nada é gravado e o erro é um *BatchError.
+func (mc *NoSQL) Batch(ops []BatchOp) ([]string, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	results := make([]string, len(ops))
+	err := mc.db.Update(func(tx *bbolt.Tx) error {
+		for i, op := range ops {
+			switch op.Kind {
+			case BatchSet:
+				if err := bset(tx, op.Bucket, op.Key, op.Value); err != nil {
+					return &BatchError{Index: i, Err: err}
+				}
+				results[i] = "ok"
+			case BatchInsert:
+				id, err := insert(tx, op.Collection, op.Doc)
+				if err != nil {
+					return &BatchError{Index: i, Err: err}
+				}
+				results[i] = id
+			default:
+				return &BatchError{Index: i, Err: fmt.Errorf("unsupported batch operation %q", op.Kind)}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		if im, exists := mc.indexes[op.Collection]; exists && op.Kind == BatchInsert {
+			im.UpdateIndexes(results[i], op.Doc)
+		}
+	}
+	return results, nil
+}
@@ -17,6 +17,9 @@ var (
 	ErrCollectionNotFound = errors.New("collection not found")
 	ErrDocumentNotFound   = errors.New("document not found")
 	ErrInvalidQuery       = errors.New("invalid query")
+	ErrInvalidDocument    = errors.New("document must be a JSON object")
+	ErrBucketNotFound     = errors.New("bucket not found")
+	ErrStoreBucket        = errors.New("can't delete store") // o bucket padrão não pode ser apagado
 )
 
 // Document representa um documento no banco de dados (similar ao MongoDB)
@@ -80,34 +83,48 @@ func (mc *NoSQL) Insert(collection string, doc Document) (string, error) {
 
 	var id string
 	err := mc.db.Update(func(tx *bbolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists([]byte(collection))
-		if err != nil {
-			return err
-		}
+		var err error
+		id, err = insert(tx, collection, doc)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
 
-		id = generateID()
-		doc["_id"] = id
-		doc["_collection"] = collection
+	// Atualizar índices
+	if im, exists := mc.indexes[collection]; exists {
+		im.UpdateIndexes(id, doc)
+	}
 
-		data, err := json.Marshal(doc)
-		if err != nil {
-			return err
-		}
+	return id, nil
+}
 
-		err = b.Put([]byte(id), data)
-		if err != nil {
-			return err
-		}
+// insert grava o documento dentro de uma transação já aberta; os índices
+// são atualizados pelo chamador depois do commit
+func insert(tx *bbolt.Tx, collection string, doc Document) (string, error) {
+	// "null" decodifica sem erro num Document nil
+	if doc == nil {
+		return "", ErrInvalidDocument
+	}
+	b, err := tx.CreateBucketIfNotExists([]byte(collection))
+	if err != nil {
+		return "", err
+	}
+	id := generateID()
+	doc["_id"] = id
+	doc["_collection"] = collection
 
-		// Atualizar índices
-		if im, exists := mc.indexes[collection]; exists {
-			im.UpdateIndexes(id, doc)
-		}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
 
-		return nil
-	})
+	err = b.Put([]byte(id), data)
+	if err != nil {
+		return "", err
+	}
 
-	return id, err
+	return id, nil
 }
 
 // FindOne busca um documento por ID
@@ -582,3 +599,56 @@ func main() {
 	}
 	fmt.Println("\nDeleted user with ID:", id1)
 }
+
+// Stats é um retrato do bbolt para métricas.
+type Stats struct {
+	TxN           int // transações de leitura iniciadas
+	OpenTxN       int // transações de leitura abertas agora
+	WriteN        int64
+	PageCount     int64
+	PageAlloc     int64 // bytes alocados em páginas
+	FreePageN     int
+	PendingPageN  int
+	FreeAlloc     int
+	FreelistInuse int
+	Size          int64 // tamanho do arquivo
+}
+
+// Stats retorna as estatísticas do banco.
+func (mc *NoSQL) Stats() Stats {
+	s := mc.db.Stats()
+	stats := Stats{
+		TxN:           s.TxN,
+		OpenTxN:       s.OpenTxN,
+		WriteN:        s.TxStats.GetWrite(),
+		PageCount:     s.TxStats.GetPageCount(),
+		PageAlloc:     s.TxStats.GetPageAlloc(),
+		FreePageN:     s.FreePageN,
+		PendingPageN:  s.PendingPageN,
+		FreeAlloc:     s.FreeAlloc,
+		FreelistInuse: s.FreelistInuse,
+	}
+	mc.db.View(func(tx *bbolt.Tx) error {
+		stats.Size = tx.Size()
+		return nil
+	})
+	return stats
+}
+
+// Counts retorna a quantidade de chaves de cada bucket KV e de documentos de
+// cada coleção.
+func (mc *NoSQL) Counts() (map[string]int, map[string]int, error) {
+	buckets := map[string]int{}
+	collections := map[string]int{}
+	err := mc.db.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			if bucket, ok := strings.CutPrefix(string(name), "kv_"); ok {
+				buckets[bucket] = b.Stats().KeyN
+			} else if string(name) != raftBucket {
+				collections[string(name)] = b.Stats().KeyN
+			}
+			return nil
+		})
+	})
+	return buckets, collections, err
+}
@@ -18,7 +18,7 @@ func (kv *NoSQL) BCreate(name string) error {
 func (kv *NoSQL) BDelete(name string) error {
 	return kv.db.Update(func(tx *bbolt.Tx) error {
 		if name == "store" {
-			return fmt.Errorf("can't delete store")
+			return ErrStoreBucket
 		}
 		err := tx.DeleteBucket([]byte("kv_" + name))
 		if err != nil {
@@ -43,19 +43,23 @@ func (kv *NoSQL) Get(key string) (string, error) {
 
 func (kv *NoSQL) BSet(bucket, key, value string) error {
 	return kv.db.Update(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket([]byte("kv_" + bucket))
-		if bucket == nil {
-			return fmt.Errorf("bucket não encontrado")
-		}
-		return bucket.Put([]byte(key), []byte(value))
+		return bset(tx, bucket, key, value)
 	})
 }
 
+func bset(tx *bbolt.Tx, bucket, key, value string) error {
+	b := tx.Bucket([]byte("kv_" + bucket))
+	if b == nil {
+		return ErrBucketNotFound
+	}
+	return b.Put([]byte(key), []byte(value))
+}
+
 func (kv *NoSQL) BDel(bucket, key string) error {
 	return kv.db.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket([]byte("kv_" + bucket))
 		if bucket == nil {
-			return fmt.Errorf("bucket não encontrado")
+			return ErrBucketNotFound
 		}
 		return bucket.Delete([]byte(key))
 	})
@@ -65,7 +69,7 @@ func (kv *NoSQL) BGet(bucket, key string) (string, error) {
 	err := kv.db.View(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket([]byte("kv_" + bucket))
 		if bucket == nil {
-			return fmt.Errorf("bucket não encontrado")
+			return ErrBucketNotFound
 		}
 		value = bucket.Get([]byte(key))
 		return nil
@@ -80,7 +84,7 @@ func (kv *NoSQL) BList(bucket string, filter func(k, v []byte) bool) (map[string
 	err := kv.db.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte("kv_" + bucket))
 		if b == nil {
-			return fmt.Errorf("bucket '%s' não encontrado", bucket)
+			return fmt.Errorf("%w: %s", ErrBucketNotFound, bucket)
 		}
 
 		return b.ForEach(func(k, v []byte) error {
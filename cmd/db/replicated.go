@@ -0,0 +1,175 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.etcd.io/bbolt"
+)
+
+// Escritas do KV replicadas pelo Raft.
+const (
+	KVSet    = "SET"
+	KVDel    = "DEL"
+	KVCreate = "BADD"
+	KVDelete = "BDEL"
+)
+
+// raftBucket guarda o índice e o termo da última entrada do log aplicada,
+// gravados na mesma transação das escritas: o arquivo é o snapshot.
+const raftBucket = "_raft"
+
+// KVOp é uma escrita do KV; uma entrada do log leva uma lista delas.
+type KVOp struct {
+	Kind   string `json:"kind"`
+	Bucket string `json:"bucket"`
+	Key    string `json:"key,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+func kvOp(tx *bbolt.Tx, op KVOp) error {
+	switch op.Kind {
+	case KVSet:
+		return bset(tx, op.Bucket, op.Key, op.Value)
+	case KVDel:
+		b := tx.Bucket([]byte("kv_" + op.Bucket))
+		if b == nil {
+			return ErrBucketNotFound
+		}
+		return b.Delete([]byte(op.Key))
+	case KVCreate:
+		_, err := tx.CreateBucketIfNotExists([]byte("kv_" + op.Bucket))
+		return err
+	case KVDelete:
+		if op.Bucket == "store" {
+			return ErrStoreBucket
+		}
+		return tx.DeleteBucket([]byte("kv_" + op.Bucket))
+	}
+	return fmt.Errorf("unsupported kv operation %q", op.Kind)
+}
+
+func putApplied(tx *bbolt.Tx, index, term uint64) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(raftBucket))
+	if err != nil {
+		return err
+	}
+	value := make([]byte, 16)
+	binary.BigEndian.PutUint64(value, index)
+	binary.BigEndian.PutUint64(value[8:], term)
+	return b.Put([]byte("applied"), value)
+}
+
+func getApplied(tx *bbolt.Tx) (uint64, uint64) {
+	b := tx.Bucket([]byte(raftBucket))
+	if b == nil {
+		return 0, 0
+	}
+	value := b.Get([]byte("applied"))
+	if len(value) != 16 {
+		return 0, 0
+	}
+	return binary.BigEndian.Uint64(value), binary.BigEndian.Uint64(value[8:])
+}
+
+// ApplyKV aplica a entrada index/term do log (um JSON de []KVOp; vazio é
+// só um marcador). As operações vão juntas; se uma falha nenhuma é gravada,
+// mas o índice avança do mesmo jeito em todos os nós.
+func (mc *NoSQL) ApplyKV(index, term uint64, data []byte) (string, error) {
+	ops := []KVOp{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &ops); err != nil {
+			return "", err
+		}
+	}
+	opErr := mc.db.Update(func(tx *bbolt.Tx) error {
+		for _, op := range ops {
+			if err := kvOp(tx, op); err != nil {
+				return err
+			}
+		}
+		return putApplied(tx, index, term)
+	})
+	if opErr == nil {
+		return "ok", nil
+	}
+	err := mc.db.Update(func(tx *bbolt.Tx) error {
+		return putApplied(tx, index, term)
+	})
+	if err != nil {
+		return "", err
+	}
+	return "", opErr
+}
+
+// Applied retorna o índice e o termo da última entrada aplicada.
+func (mc *NoSQL) Applied() (uint64, uint64) {
+	var index, term uint64
+	mc.db.View(func(tx *bbolt.Tx) error {
+		index, term = getApplied(tx)
+		return nil
+	})
+	return index, term
+}
+
+// SnapshotKV copia todos os buckets do KV, junto com o índice aplicado.
+func (mc *NoSQL) SnapshotKV() (uint64, uint64, []byte, error) {
+	var index, term uint64
+	buckets := map[string]map[string]string{}
+	err := mc.db.View(func(tx *bbolt.Tx) error {
+		index, term = getApplied(tx)
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			bucket, ok := strings.CutPrefix(string(name), "kv_")
+			if !ok {
+				return nil
+			}
+			items := map[string]string{}
+			buckets[bucket] = items
+			return b.ForEach(func(k, v []byte) error {
+				items[string(k)] = string(v)
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	data, err := json.Marshal(buckets)
+	return index, term, data, err
+}
+
+// RestoreKV troca todo o KV pelo snapshot de SnapshotKV.
+func (mc *NoSQL) RestoreKV(index, term uint64, data []byte) error {
+	buckets := map[string]map[string]string{}
+	if err := json.Unmarshal(data, &buckets); err != nil {
+		return err
+	}
+	return mc.db.Update(func(tx *bbolt.Tx) error {
+		names := [][]byte{}
+		tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			if strings.HasPrefix(string(name), "kv_") {
+				names = append(names, append([]byte{}, name...))
+			}
+			return nil
+		})
+		for _, name := range names {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		for bucket, items := range buckets {
+			b, err := tx.CreateBucket([]byte("kv_" + bucket))
+			if err != nil {
+				return err
+			}
+			for k, v := range items {
+				if err := b.Put([]byte(k), []byte(v)); err != nil {
+					return err
+				}
+			}
+		}
+		return putApplied(tx, index, term)
+	})
+}
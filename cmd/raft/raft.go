@@ -0,0 +1,810 @@
+// Package raft é uma implementação enxuta do Raft para replicar o KV do
+// broker: eleição de líder, replicação do log, encaminhamento de escritas
+// ao líder, leituras linearizáveis por ReadIndex e compactação do log, com
+// o próprio estado aplicado fazendo o papel de snapshot.
+package raft
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoLeader       = errors.New("raft: no leader")
+	ErrTimeout        = errors.New("raft: timeout")
+	ErrLeadershipLost = errors.New("raft: leadership lost, result unknown")
+	ErrStopped        = errors.New("raft: stopped")
+)
+
+type Config struct {
+	ID                string
+	Addr              string            // onde este nó ouve o RPC
+	Peers             map[string]string // id -> endereço dos outros nós
+	LogFile           string
+	ElectionTimeout   time.Duration // mínimo; o real é sorteado entre 1x e 2x
+	HeartbeatInterval time.Duration
+	SnapshotThreshold uint64 // entradas aplicadas mantidas antes de compactar
+	Secret            string // obrigatório e igual em todos os nós
+
+	// Errors são as sentinelas da máquina de estados; uma escrita
+	// encaminhada que falha no líder volta ao seguidor com a mesma
+	// sentinela, e não só com a mensagem.
+	Errors []error
+}
+
+// StateMachine é onde o log é aplicado. Apply precisa gravar index/term junto
+// com a escrita, e Snapshot devolve o estado com o índice correspondente.
+type StateMachine interface {
+	Apply(index, term uint64, data []byte) (string, error)
+	Applied() (uint64, uint64)
+	Snapshot() (uint64, uint64, []byte, error)
+	Restore(index, term uint64, data []byte) error
+}
+
+type Entry struct {
+	Index uint64
+	Term  uint64
+	Data  []byte // nil é o marcador que o líder grava ao assumir
+}
+
+type result struct {
+	payload string
+	err     error
+}
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+const maxBatch = 256
+
+type Raft struct {
+	mu      sync.Mutex
+	applyMu sync.Mutex // serializa Apply e Restore na máquina de estados
+	config  Config
+	sm      StateMachine
+	store   *storage
+
+	term     uint64
+	votedFor string
+	log      []Entry // log[0] é a última entrada compactada (só índice e termo)
+
+	commitIndex uint64
+	lastApplied uint64
+	role        role
+	leader      string
+
+	lastContact     time.Time
+	electionTimeout time.Duration
+	lastHeartbeat   time.Time
+
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	replicating map[string]bool
+	pending     map[string]bool
+	waiters     map[uint64]chan result
+
+	applyCh   chan struct{}
+	done      chan struct{}
+	listener  net.Listener
+	clientsMu sync.Mutex
+	clients   map[string]*rpc.Client
+}
+
+// New abre o log e retoma do que a máquina de estados já aplicou.
+func New(config Config, sm StateMachine) (*Raft, error) {
+	if config.Secret == "" {
+		return nil, errors.New("raft: secret is required")
+	}
+	if config.ElectionTimeout <= 0 {
+		config.ElectionTimeout = 500 * time.Millisecond
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = config.ElectionTimeout / 5
+	}
+	if config.SnapshotThreshold == 0 {
+		config.SnapshotThreshold = 1000
+	}
+	store, err := openStorage(config.LogFile)
+	if err != nil {
+		return nil, err
+	}
+	index, term := sm.Applied()
+	if err := store.compact(index); err != nil {
+		store.close()
+		return nil, err
+	}
+	entries, err := store.entries(index)
+	if err != nil {
+		store.close()
+		return nil, err
+	}
+	if len(entries) > 0 && entries[0].Index != index+1 {
+		// buraco entre o KV e o log: o líder manda de novo
+		store.truncate(0)
+		entries = nil
+	}
+
+	r := &Raft{
+		config:      config,
+		sm:          sm,
+		store:       store,
+		log:         append([]Entry{{Index: index, Term: term}}, entries...),
+		commitIndex: index,
+		lastApplied: index,
+		nextIndex:   map[string]uint64{},
+		matchIndex:  map[string]uint64{},
+		replicating: map[string]bool{},
+		pending:     map[string]bool{},
+		waiters:     map[uint64]chan result{},
+		applyCh:     make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		clients:     map[string]*rpc.Client{},
+	}
+	r.term, r.votedFor = store.state()
+	r.resetElectionTimer()
+	return r, nil
+}
+
+// Start passa a ouvir o RPC e a participar das eleições.
+func (r *Raft) Start() error {
+	listener, err := net.Listen("tcp", r.config.Addr)
+	if err != nil {
+		return err
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName("Raft", &rpcService{r: r}); err != nil {
+		listener.Close()
+		return err
+	}
+	r.listener = listener
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				if !r.authenticate(conn) {
+					conn.Close()
+					return
+				}
+				server.ServeConn(conn)
+			}()
+		}
+	}()
+	go r.run()
+	go r.applier()
+	return nil
+}
+
+// Stop para o nó e fecha o log.
+func (r *Raft) Stop() error {
+	r.mu.Lock()
+	select {
+	case <-r.done:
+		r.mu.Unlock()
+		return nil
+	default:
+	}
+	close(r.done)
+	r.failWaiters(ErrStopped)
+	r.mu.Unlock()
+	if r.listener != nil {
+		r.listener.Close()
+	}
+	r.clientsMu.Lock()
+	for _, client := range r.clients {
+		client.Close()
+	}
+	r.clientsMu.Unlock()
+	r.applyMu.Lock()
+	defer r.applyMu.Unlock()
+	return r.store.close()
+}
+
+// Leader devolve o id do líder conhecido ("" durante eleições).
+func (r *Raft) Leader() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.leader
+}
+
+// Apply grava data no log e devolve o resultado da máquina de estados
+// quando a entrada é aplicada. Num seguidor a escrita vai para o líder.
+func (r *Raft) Apply(data []byte, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		payload, isLeader, err := r.applyLocal(data, deadline)
+		if isLeader {
+			return payload, err
+		}
+		if id := r.Leader(); id != "" {
+			reply := ForwardReply{}
+			err := r.call(id, "Raft.Forward", ForwardArgs{Data: data, Timeout: time.Until(deadline)}, &reply, time.Until(deadline))
+			if err == nil && !reply.NotLeader {
+				if reply.Err != "" {
+					return reply.Payload, r.remoteErr(reply.Err, reply.Code)
+				}
+				return reply.Payload, nil
+			}
+		}
+		if err := r.wait(deadline); err != nil {
+			return "", err
+		}
+	}
+}
+
+func (r *Raft) applyLocal(data []byte, deadline time.Time) (string, bool, error) {
+	r.mu.Lock()
+	if r.role != leader {
+		r.mu.Unlock()
+		return "", false, nil
+	}
+	e := Entry{Index: r.lastIndex() + 1, Term: r.term, Data: data}
+	if err := r.store.append([]Entry{e}); err != nil {
+		r.mu.Unlock()
+		return "", true, err
+	}
+	r.log = append(r.log, e)
+	ch := make(chan result, 1)
+	r.waiters[e.Index] = ch
+	r.broadcast()
+	r.advanceCommit()
+	r.mu.Unlock()
+
+	select {
+	case res := <-ch:
+		return res.payload, true, res.err
+	case <-time.After(time.Until(deadline)):
+		r.mu.Lock()
+		delete(r.waiters, e.Index)
+		r.mu.Unlock()
+		return "", true, ErrTimeout
+	}
+}
+
+// ReadIndex espera até este nó ter aplicado tudo que estava confirmado
+// quando a leitura começou, com o líder confirmando que ainda é líder.
+// Depois disso uma leitura local é linearizável.
+func (r *Raft) ReadIndex(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		index, isLeader, err := r.readIndexLocal(deadline)
+		if isLeader {
+			if err != nil {
+				return err
+			}
+			return r.waitApplied(index, deadline)
+		}
+		if id := r.Leader(); id != "" {
+			reply := ReadIndexReply{}
+			err := r.call(id, "Raft.ReadIndex", ReadIndexArgs{Timeout: time.Until(deadline)}, &reply, time.Until(deadline))
+			if err == nil && !reply.NotLeader {
+				if reply.Err != "" {
+					return r.remoteErr(reply.Err, reply.Code)
+				}
+				return r.waitApplied(reply.Index, deadline)
+			}
+		}
+		if err := r.wait(deadline); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *Raft) readIndexLocal(deadline time.Time) (uint64, bool, error) {
+	for {
+		r.mu.Lock()
+		if r.role != leader {
+			r.mu.Unlock()
+			return 0, false, nil
+		}
+		// o líder só sabe o commit real depois de confirmar uma entrada do
+		// próprio termo (o marcador gravado ao assumir)
+		if r.termAt(r.commitIndex) == r.term {
+			index, term := r.commitIndex, r.term
+			r.mu.Unlock()
+			if !r.confirmLeadership(term) {
+				return 0, false, nil
+			}
+			return index, true, nil
+		}
+		r.mu.Unlock()
+		if err := r.wait(deadline); err != nil {
+			return 0, true, err
+		}
+	}
+}
+
+// confirmLeadership manda um heartbeat e espera a maioria reconhecer o termo.
+func (r *Raft) confirmLeadership(term uint64) bool {
+	r.mu.Lock()
+	peers := len(r.config.Peers)
+	acks := make(chan bool, peers)
+	for peer := range r.config.Peers {
+		prev := r.nextIndex[peer] - 1
+		if prev < r.log[0].Index {
+			prev = r.log[0].Index
+		}
+		args := AppendEntriesArgs{
+			Term:         term,
+			LeaderID:     r.config.ID,
+			PrevLogIndex: prev,
+			PrevLogTerm:  r.termAt(prev),
+			LeaderCommit: r.commitIndex,
+		}
+		go func(peer string) {
+			reply := AppendEntriesReply{}
+			err := r.call(peer, "Raft.AppendEntries", args, &reply, r.config.ElectionTimeout)
+			if err == nil && reply.Term > term {
+				r.mu.Lock()
+				r.becomeFollower(reply.Term)
+				r.mu.Unlock()
+			}
+			acks <- err == nil && reply.Term == term
+		}(peer)
+	}
+	r.mu.Unlock()
+
+	votes := 1
+	for i := 0; i < peers && votes*2 <= peers+1; i++ {
+		if <-acks {
+			votes++
+		}
+	}
+	return votes*2 > peers+1
+}
+
+func (r *Raft) waitApplied(index uint64, deadline time.Time) error {
+	for {
+		r.mu.Lock()
+		applied := r.lastApplied
+		r.mu.Unlock()
+		if applied >= index {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return ErrTimeout
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+// wait dá um intervalo antes de tentar de novo, respeitando o prazo.
+func (r *Raft) wait(deadline time.Time) error {
+	if time.Now().After(deadline) {
+		return ErrNoLeader
+	}
+	select {
+	case <-r.done:
+		return ErrStopped
+	case <-time.After(r.config.HeartbeatInterval):
+		return nil
+	}
+}
+
+func (r *Raft) lastIndex() uint64 {
+	return r.log[len(r.log)-1].Index
+}
+
+func (r *Raft) termAt(index uint64) uint64 {
+	return r.log[index-r.log[0].Index].Term
+}
+
+func (r *Raft) resetElectionTimer() {
+	r.lastContact = time.Now()
+	r.electionTimeout = r.config.ElectionTimeout + time.Duration(rand.Int63n(int64(r.config.ElectionTimeout)))
+}
+
+func (r *Raft) persist() {
+	r.store.setState(r.term, r.votedFor)
+}
+
+func (r *Raft) run() {
+	ticker := time.NewTicker(r.config.HeartbeatInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		if r.role == leader {
+			if time.Since(r.lastHeartbeat) >= r.config.HeartbeatInterval {
+				r.lastHeartbeat = time.Now()
+				r.broadcast()
+			}
+		} else if time.Since(r.lastContact) > r.electionTimeout {
+			r.startElection()
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *Raft) startElection() {
+	r.term++
+	r.role = candidate
+	r.votedFor = r.config.ID
+	r.leader = ""
+	r.persist()
+	r.resetElectionTimer()
+	if len(r.config.Peers) == 0 {
+		r.becomeLeader()
+		return
+	}
+
+	args := RequestVoteArgs{
+		Term:         r.term,
+		CandidateID:  r.config.ID,
+		LastLogIndex: r.lastIndex(),
+		LastLogTerm:  r.termAt(r.lastIndex()),
+	}
+	votes := 1
+	for peer := range r.config.Peers {
+		go func(peer string) {
+			reply := RequestVoteReply{}
+			if r.call(peer, "Raft.RequestVote", args, &reply, r.config.ElectionTimeout) != nil {
+				return
+			}
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if reply.Term > r.term {
+				r.becomeFollower(reply.Term)
+				return
+			}
+			if r.role != candidate || r.term != args.Term || !reply.Granted {
+				return
+			}
+			votes++
+			if votes*2 > len(r.config.Peers)+1 {
+				r.becomeLeader()
+			}
+		}(peer)
+	}
+}
+
+func (r *Raft) becomeFollower(term uint64) {
+	if term > r.term {
+		r.term = term
+		r.votedFor = ""
+		r.persist()
+	}
+	if r.role == leader {
+		r.failWaiters(ErrLeadershipLost)
+		r.resetElectionTimer()
+	}
+	r.role = follower
+}
+
+func (r *Raft) failWaiters(err error) {
+	for index, ch := range r.waiters {
+		ch <- result{err: err}
+		delete(r.waiters, index)
+	}
+}
+
+func (r *Raft) becomeLeader() {
+	r.role = leader
+	r.leader = r.config.ID
+	for peer := range r.config.Peers {
+		r.nextIndex[peer] = r.lastIndex() + 1
+		r.matchIndex[peer] = 0
+	}
+	e := Entry{Index: r.lastIndex() + 1, Term: r.term}
+	if r.store.append([]Entry{e}) == nil {
+		r.log = append(r.log, e)
+	}
+	r.lastHeartbeat = time.Now()
+	r.broadcast()
+	r.advanceCommit()
+}
+
+// broadcast dispara a replicação para todos. Chamado com o lock.
+func (r *Raft) broadcast() {
+	for peer := range r.config.Peers {
+		if r.replicating[peer] {
+			r.pending[peer] = true
+			continue
+		}
+		r.replicating[peer] = true
+		go r.replicate(peer, r.term)
+	}
+}
+
+// replicate envia ao peer o que falta (ou um heartbeat) até ele alcançar o
+// líder; se ficou para trás da compactação, manda o snapshot.
+func (r *Raft) replicate(peer string, term uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.role == leader && r.term == term {
+		r.pending[peer] = false
+		next := r.nextIndex[peer]
+		if next <= r.log[0].Index {
+			r.mu.Unlock()
+			ok := r.sendSnapshot(peer, term)
+			r.mu.Lock()
+			if !ok {
+				break
+			}
+			continue
+		}
+
+		prev := next - 1
+		last := min(r.lastIndex(), prev+maxBatch)
+		args := AppendEntriesArgs{
+			Term:         term,
+			LeaderID:     r.config.ID,
+			PrevLogIndex: prev,
+			PrevLogTerm:  r.termAt(prev),
+			Entries:      append([]Entry{}, r.log[next-r.log[0].Index:last-r.log[0].Index+1]...),
+			LeaderCommit: r.commitIndex,
+		}
+		r.mu.Unlock()
+		reply := AppendEntriesReply{}
+		err := r.call(peer, "Raft.AppendEntries", args, &reply, r.config.ElectionTimeout)
+		r.mu.Lock()
+		if err != nil || r.role != leader || r.term != term {
+			break
+		}
+		if reply.Term > r.term {
+			r.becomeFollower(reply.Term)
+			break
+		}
+		if !reply.Success {
+			r.nextIndex[peer] = max(1, min(reply.ConflictIndex, next-1))
+			continue
+		}
+		match := prev + uint64(len(args.Entries))
+		r.matchIndex[peer] = max(r.matchIndex[peer], match)
+		r.nextIndex[peer] = match + 1
+		r.advanceCommit()
+		if r.nextIndex[peer] > r.lastIndex() && !r.pending[peer] {
+			break
+		}
+	}
+	r.replicating[peer] = false
+}
+
+func (r *Raft) sendSnapshot(peer string, term uint64) bool {
+	index, snapTerm, data, err := r.sm.Snapshot()
+	if err != nil {
+		return false
+	}
+	args := InstallSnapshotArgs{
+		Term:      term,
+		LeaderID:  r.config.ID,
+		LastIndex: index,
+		LastTerm:  snapTerm,
+		Data:      data,
+	}
+	reply := InstallSnapshotReply{}
+	if r.call(peer, "Raft.InstallSnapshot", args, &reply, 4*r.config.ElectionTimeout) != nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if reply.Term > r.term {
+		r.becomeFollower(reply.Term)
+		return false
+	}
+	r.matchIndex[peer] = max(r.matchIndex[peer], index)
+	r.nextIndex[peer] = index + 1
+	return true
+}
+
+// advanceCommit confirma a maior entrada do termo atual que está na maioria.
+func (r *Raft) advanceCommit() {
+	for n := r.lastIndex(); n > r.commitIndex && n > r.log[0].Index; n-- {
+		if r.termAt(n) != r.term {
+			return
+		}
+		count := 1
+		for peer := range r.config.Peers {
+			if r.matchIndex[peer] >= n {
+				count++
+			}
+		}
+		if count*2 > len(r.config.Peers)+1 {
+			r.commitIndex = n
+			r.signalApply()
+			r.broadcast()
+			return
+		}
+	}
+}
+
+func (r *Raft) signalApply() {
+	select {
+	case r.applyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Raft) applier() {
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.applyCh:
+		}
+		r.applyCommitted()
+	}
+}
+
+func (r *Raft) applyCommitted() {
+	r.applyMu.Lock()
+	defer r.applyMu.Unlock()
+	for {
+		r.mu.Lock()
+		if r.lastApplied < r.log[0].Index {
+			r.lastApplied = r.log[0].Index
+		}
+		if r.lastApplied >= r.commitIndex {
+			r.mu.Unlock()
+			return
+		}
+		e := r.log[r.lastApplied+1-r.log[0].Index]
+		r.mu.Unlock()
+
+		payload, err := r.sm.Apply(e.Index, e.Term, e.Data)
+
+		r.mu.Lock()
+		r.lastApplied = e.Index
+		if ch := r.waiters[e.Index]; ch != nil {
+			ch <- result{payload: payload, err: err}
+			delete(r.waiters, e.Index)
+		}
+		r.compact()
+		r.mu.Unlock()
+	}
+}
+
+// compact descarta do log as entradas já aplicadas (estão no KV) quando
+// passam de SnapshotThreshold.
+func (r *Raft) compact() {
+	base := r.log[0].Index
+	if r.lastApplied-base <= r.config.SnapshotThreshold {
+		return
+	}
+	upTo := r.lastApplied
+	if r.store.compact(upTo) != nil {
+		return
+	}
+	kept := r.log[upTo-base:]
+	r.log = append([]Entry{{Index: upTo, Term: kept[0].Term}}, kept[1:]...)
+}
+
+func (r *Raft) requestVote(args RequestVoteArgs, reply *RequestVoteReply) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if args.Term > r.term {
+		r.becomeFollower(args.Term)
+	}
+	reply.Term = r.term
+	if args.Term < r.term {
+		return nil
+	}
+	lastTerm := r.termAt(r.lastIndex())
+	upToDate := args.LastLogTerm > lastTerm || (args.LastLogTerm == lastTerm && args.LastLogIndex >= r.lastIndex())
+	if (r.votedFor == "" || r.votedFor == args.CandidateID) && upToDate {
+		r.votedFor = args.CandidateID
+		r.persist()
+		r.resetElectionTimer()
+		reply.Granted = true
+	}
+	return nil
+}
+
+func (r *Raft) appendEntries(args AppendEntriesArgs, reply *AppendEntriesReply) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reply.Term = r.term
+	if args.Term < r.term {
+		return nil
+	}
+	if args.Term > r.term || r.role != follower {
+		r.becomeFollower(args.Term)
+	}
+	reply.Term = r.term
+	r.leader = args.LeaderID
+	r.resetElectionTimer()
+
+	base := r.log[0].Index
+	if args.PrevLogIndex > r.lastIndex() {
+		reply.ConflictIndex = r.lastIndex() + 1
+		return nil
+	}
+	if args.PrevLogIndex >= base && r.termAt(args.PrevLogIndex) != args.PrevLogTerm {
+		term := r.termAt(args.PrevLogIndex)
+		i := args.PrevLogIndex
+		for i > base+1 && r.termAt(i-1) == term {
+			i--
+		}
+		reply.ConflictIndex = i
+		return nil
+	}
+
+	newEntries := []Entry{}
+	for _, e := range args.Entries {
+		if e.Index <= base {
+			continue
+		}
+		if len(newEntries) == 0 && e.Index <= r.lastIndex() {
+			if r.termAt(e.Index) == e.Term {
+				continue
+			}
+			if err := r.store.truncate(e.Index); err != nil {
+				return err
+			}
+			r.log = r.log[:e.Index-base]
+		}
+		newEntries = append(newEntries, e)
+	}
+	if len(newEntries) > 0 {
+		if err := r.store.append(newEntries); err != nil {
+			return err
+		}
+		r.log = append(r.log, newEntries...)
+	}
+
+	lastNew := args.PrevLogIndex + uint64(len(args.Entries))
+	if commit := min(args.LeaderCommit, lastNew); commit > r.commitIndex {
+		r.commitIndex = commit
+		r.signalApply()
+	}
+	reply.Success = true
+	return nil
+}
+
+func (r *Raft) installSnapshot(args InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	r.mu.Lock()
+	reply.Term = r.term
+	if args.Term < r.term {
+		r.mu.Unlock()
+		return nil
+	}
+	if args.Term > r.term || r.role != follower {
+		r.becomeFollower(args.Term)
+	}
+	reply.Term = r.term
+	r.leader = args.LeaderID
+	r.resetElectionTimer()
+	r.mu.Unlock()
+
+	r.applyMu.Lock()
+	defer r.applyMu.Unlock()
+	r.mu.Lock()
+	applied := r.lastApplied
+	r.mu.Unlock()
+	if args.LastIndex <= applied {
+		return nil
+	}
+	if err := r.sm.Restore(args.LastIndex, args.LastTerm, args.Data); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	base := r.log[0].Index
+	if args.LastIndex <= r.lastIndex() && r.termAt(args.LastIndex) == args.LastTerm {
+		// o resto do log continua válido
+		r.log = append([]Entry{{Index: args.LastIndex, Term: args.LastTerm}}, r.log[args.LastIndex-base+1:]...)
+		r.store.compact(args.LastIndex)
+	} else {
+		r.log = []Entry{{Index: args.LastIndex, Term: args.LastTerm}}
+		r.store.truncate(0)
+	}
+	r.lastApplied = args.LastIndex
+	r.commitIndex = max(r.commitIndex, args.LastIndex)
+	return nil
+}
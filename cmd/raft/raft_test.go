@@ -0,0 +1,354 @@
+package raft
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errRejected = errors.New("rejected by the state machine")
+
+// memMachine guarda "k=v" num mapa; "!" é recusado com errRejected.
+type memMachine struct {
+	mu       sync.Mutex
+	index    uint64
+	term     uint64
+	kv       map[string]string
+	restored bool
+}
+
+func newMemMachine() *memMachine {
+	return &memMachine{kv: map[string]string{}}
+}
+
+func (m *memMachine) Apply(index, term uint64, data []byte) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.index, m.term = index, term
+	if data == nil {
+		return "", nil
+	}
+	if string(data) == "!" {
+		return "", errRejected
+	}
+	k, v, _ := strings.Cut(string(data), "=")
+	m.kv[k] = v
+	return "ok:" + k, nil
+}
+
+func (m *memMachine) Applied() (uint64, uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.index, m.term
+}
+
+func (m *memMachine) Snapshot() (uint64, uint64, []byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, err := json.Marshal(m.kv)
+	return m.index, m.term, data, err
+}
+
+func (m *memMachine) Restore(index, term uint64, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kv := map[string]string{}
+	if err := json.Unmarshal(data, &kv); err != nil {
+		return err
+	}
+	m.index, m.term, m.kv, m.restored = index, term, kv, true
+	return nil
+}
+
+func (m *memMachine) get(k string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.kv[k]
+}
+
+type testNode struct {
+	raft *Raft
+	sm   *memMachine
+}
+
+type testCluster struct {
+	t     *testing.T
+	addrs map[string]string
+	nodes map[string]*testNode
+	dir   string
+	// threshold vai para o SnapshotThreshold dos nós
+	threshold uint64
+}
+
+func newTestCluster(t *testing.T, ids ...string) *testCluster {
+	c := &testCluster{t: t, addrs: map[string]string{}, nodes: map[string]*testNode{}, dir: t.TempDir()}
+	for _, id := range ids {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.addrs[id] = l.Addr().String()
+		l.Close()
+	}
+	t.Cleanup(func() {
+		for _, n := range c.nodes {
+			n.raft.Stop()
+		}
+	})
+	return c
+}
+
+func (c *testCluster) config(id, logFile string) Config {
+	peers := map[string]string{}
+	for peer, addr := range c.addrs {
+		if peer != id {
+			peers[peer] = addr
+		}
+	}
+	return Config{
+		ID:                id,
+		Addr:              c.addrs[id],
+		Peers:             peers,
+		LogFile:           filepath.Join(c.dir, logFile),
+		ElectionTimeout:   150 * time.Millisecond,
+		HeartbeatInterval: 30 * time.Millisecond,
+		SnapshotThreshold: c.threshold,
+		Secret:            "s",
+		Errors:            []error{errRejected},
+	}
+}
+
+// start sobe o nó id com uma máquina de estados vazia e o log em logFile.
+func (c *testCluster) start(id, logFile string) *testNode {
+	c.t.Helper()
+	sm := newMemMachine()
+	r, err := New(c.config(id, logFile), sm)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := r.Start(); err != nil {
+		c.t.Fatal(err)
+	}
+	n := &testNode{raft: r, sm: sm}
+	c.nodes[id] = n
+	return n
+}
+
+func (c *testCluster) startAll() {
+	for id := range c.addrs {
+		c.start(id, id+".log")
+	}
+}
+
+func (c *testCluster) stop(id string) {
+	c.nodes[id].raft.Stop()
+	delete(c.nodes, id)
+}
+
+// leader espera todos os nós de pé concordarem num líder.
+func (c *testCluster) leader() string {
+	c.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		leader := ""
+		agreed := true
+		for _, n := range c.nodes {
+			id := n.raft.Leader()
+			if id == "" || (leader != "" && id != leader) {
+				agreed = false
+				break
+			}
+			leader = id
+		}
+		if agreed && leader != "" && c.nodes[leader] != nil {
+			return leader
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.t.Fatal("no leader elected")
+	return ""
+}
+
+func (c *testCluster) follower(leader string) string {
+	for id := range c.nodes {
+		if id != leader {
+			return id
+		}
+	}
+	c.t.Fatal("no follower")
+	return ""
+}
+
+// replicated espera k=v em todos os nós de pé.
+func (c *testCluster) replicated(k, v string) {
+	c.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, n := range c.nodes {
+		for n.sm.get(k) != v {
+			if time.Now().After(deadline) {
+				c.t.Fatalf("%s=%q not replicated to %s", k, v, n.raft.config.ID)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
+
+func TestElection(t *testing.T) {
+	c := newTestCluster(t, "n1", "n2", "n3")
+	c.startAll()
+	first := c.leader()
+
+	// sem o líder os outros dois ainda são maioria
+	c.stop(first)
+	second := c.leader()
+	if second == first {
+		t.Fatalf("leader %s did not change", first)
+	}
+	if _, err := c.nodes[second].raft.Apply([]byte("a=1"), 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	c := newTestCluster(t, "n1", "n2", "n3")
+	c.startAll()
+	leader := c.nodes[c.leader()].raft
+	for i, kv := range []string{"a=1", "b=2", "a=3"} {
+		payload, err := leader.Apply([]byte(kv), 2*time.Second)
+		if err != nil {
+			t.Fatalf("apply %d: %v", i, err)
+		}
+		if want := "ok:" + kv[:1]; payload != want {
+			t.Fatalf("payload = %q, want %q", payload, want)
+		}
+	}
+	c.replicated("a", "3")
+	c.replicated("b", "2")
+}
+
+func TestFollowerForward(t *testing.T) {
+	c := newTestCluster(t, "n1", "n2", "n3")
+	c.startAll()
+	follower := c.nodes[c.follower(c.leader())].raft
+
+	payload, err := follower.Apply([]byte("k=v"), 2*time.Second)
+	if err != nil || payload != "ok:k" {
+		t.Fatalf("forwarded apply = %q, %v", payload, err)
+	}
+	c.replicated("k", "v")
+
+	// o erro da máquina de estados no líder volta como a mesma sentinela
+	if _, err := follower.Apply([]byte("!"), 2*time.Second); !errors.Is(err, errRejected) {
+		t.Fatalf("err = %v, want errRejected", err)
+	}
+	if err := follower.ReadIndex(2 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	c := newTestCluster(t, "n1", "n2", "n3")
+	c.threshold = 5
+	c.startAll()
+	leaderId := c.leader()
+	lagging := c.follower(leaderId)
+	c.stop(lagging)
+
+	leader := c.nodes[leaderId].raft
+	for i := 0; i < 30; i++ {
+		if _, err := leader.Apply([]byte("k="+string(rune('a'+i%26))), 2*time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	leader.mu.Lock()
+	base := leader.log[0].Index
+	leader.mu.Unlock()
+	if base == 0 {
+		t.Fatal("leader log was not compacted")
+	}
+
+	// um nó novo, sem log, só alcança o líder pelo snapshot
+	n := c.start(lagging, lagging+"-fresh.log")
+	c.replicated("k", "d")
+	n.sm.mu.Lock()
+	restored := n.sm.restored
+	n.sm.mu.Unlock()
+	if !restored {
+		t.Fatal("follower caught up without a snapshot")
+	}
+}
+
+func TestRPCRequiresSecret(t *testing.T) {
+	c := newTestCluster(t, "n1", "n2", "n3")
+	c.startAll()
+	c.leader()
+
+	dial := func(secret string) *Raft {
+		config := c.config("x", "x.log")
+		config.Peers, config.Secret = c.addrs, secret
+		r := &Raft{config: config, clients: map[string]*rpc.Client{}, done: make(chan struct{})}
+		t.Cleanup(func() { close(r.done) })
+		return r
+	}
+	reply := RequestVoteReply{}
+	if err := dial("s").call("n1", "Raft.RequestVote", RequestVoteArgs{CandidateID: "x"}, &reply, time.Second); err != nil {
+		t.Fatalf("with the secret: %v", err)
+	}
+	term := reply.Term
+
+	// um termo alto derrubaria o líder; sem o segredo nem chega ao nó
+	args := RequestVoteArgs{Term: term + 100, CandidateID: "x"}
+	if err := dial("wrong").call("n1", "Raft.RequestVote", args, &reply, time.Second); err == nil {
+		t.Fatal("RPC accepted without the secret")
+	}
+	n1 := c.nodes["n1"].raft
+	n1.mu.Lock()
+	defer n1.mu.Unlock()
+	if n1.term >= term+100 {
+		t.Fatalf("term = %d, the vote request got through", n1.term)
+	}
+}
+
+func TestRPCProofNotReplayable(t *testing.T) {
+	c := newTestCluster(t, "n1")
+	n1 := c.start("n1", "n1.log").raft
+
+	// handshake devolve o nonce mandado pelo nó e o que acontece depois de
+	// responder com proof (nil usa a prova certa para esse nonce)
+	handshake := func(proof []byte) ([]byte, error) {
+		conn, err := net.Dial("tcp", c.addrs["n1"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		nonce := make([]byte, nonceSize)
+		conn.SetDeadline(time.Now().Add(time.Second))
+		if _, err := io.ReadFull(conn, nonce); err != nil {
+			t.Fatal(err)
+		}
+		if proof == nil {
+			proof = n1.proof(nonce)
+		}
+		conn.Write(proof)
+		// aceito, o nó espera o RPC; recusado, fecha a conexão
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, err = conn.Read(make([]byte, 1))
+		return nonce, err
+	}
+
+	nonce, err := handshake(nil)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("valid proof: %v", err)
+	}
+	if _, err := handshake(n1.proof(nonce)); !errors.Is(err, io.EOF) {
+		t.Fatalf("replayed proof: %v", err)
+	}
+}
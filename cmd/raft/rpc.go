@@ -0,0 +1,253 @@
+package raft
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"slices"
+	"time"
+)
+
+type RequestVoteArgs struct {
+	Term         uint64
+	CandidateID  string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+type RequestVoteReply struct {
+	Term    uint64
+	Granted bool
+}
+
+type AppendEntriesArgs struct {
+	Term         uint64
+	LeaderID     string
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []Entry
+	LeaderCommit uint64
+}
+
+type AppendEntriesReply struct {
+	Term          uint64
+	Success       bool
+	ConflictIndex uint64 // por onde o líder deve recomeçar
+}
+
+type InstallSnapshotArgs struct {
+	Term      uint64
+	LeaderID  string
+	LastIndex uint64
+	LastTerm  uint64
+	Data      []byte
+}
+
+type InstallSnapshotReply struct {
+	Term uint64
+}
+
+// ForwardArgs leva ao líder uma escrita recebida por um seguidor.
+type ForwardArgs struct {
+	Data    []byte
+	Timeout time.Duration
+}
+
+type ForwardReply struct {
+	Payload   string
+	Err       string
+	Code      string // sentinela que Err casava no líder, para o seguidor refazer
+	NotLeader bool
+}
+
+type ReadIndexArgs struct {
+	Timeout time.Duration
+}
+
+type ReadIndexReply struct {
+	Index     uint64
+	Err       string
+	Code      string
+	NotLeader bool
+}
+
+// rpcService é o que o net/rpc expõe como "Raft".
+type rpcService struct {
+	r *Raft
+}
+
+func (s *rpcService) RequestVote(args RequestVoteArgs, reply *RequestVoteReply) error {
+	return s.r.requestVote(args, reply)
+}
+
+func (s *rpcService) AppendEntries(args AppendEntriesArgs, reply *AppendEntriesReply) error {
+	return s.r.appendEntries(args, reply)
+}
+
+func (s *rpcService) InstallSnapshot(args InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	return s.r.installSnapshot(args, reply)
+}
+
+func (s *rpcService) Forward(args ForwardArgs, reply *ForwardReply) error {
+	payload, isLeader, err := s.r.applyLocal(args.Data, time.Now().Add(args.Timeout))
+	reply.Payload = payload
+	reply.NotLeader = !isLeader
+	if err != nil {
+		reply.Err, reply.Code = err.Error(), s.r.errorCode(err)
+	}
+	return nil
+}
+
+func (s *rpcService) ReadIndex(args ReadIndexArgs, reply *ReadIndexReply) error {
+	index, isLeader, err := s.r.readIndexLocal(time.Now().Add(args.Timeout))
+	reply.Index = index
+	reply.NotLeader = !isLeader
+	if err != nil {
+		reply.Err, reply.Code = err.Error(), s.r.errorCode(err)
+	}
+	return nil
+}
+
+// remoteError é o erro que o líder devolveu a uma chamada encaminhada, com
+// a sentinela refeita para errors.Is valer no seguidor.
+type remoteError struct {
+	msg string
+	err error
+}
+
+func (e *remoteError) Error() string {
+	return e.msg
+}
+
+func (e *remoteError) Unwrap() error {
+	return e.err
+}
+
+// sentinels são os erros que atravessam o RPC: os do raft e os da máquina
+// de estados (Config.Errors). O código é a mensagem da sentinela.
+func (r *Raft) sentinels() []error {
+	return slices.Concat([]error{ErrNoLeader, ErrTimeout, ErrLeadershipLost, ErrStopped}, r.config.Errors)
+}
+
+func (r *Raft) errorCode(err error) string {
+	for _, sentinel := range r.sentinels() {
+		if errors.Is(err, sentinel) {
+			return sentinel.Error()
+		}
+	}
+	return ""
+}
+
+// remoteErr refaz no seguidor o erro de ForwardReply ou ReadIndexReply.
+func (r *Raft) remoteErr(msg, code string) error {
+	for _, sentinel := range r.sentinels() {
+		if code != "" && sentinel.Error() == code {
+			if msg == code {
+				return sentinel
+			}
+			return &remoteError{msg: msg, err: sentinel}
+		}
+	}
+	return errors.New(msg)
+}
+
+var errRPCTimeout = errors.New("raft rpc timeout")
+
+// call chama o método no nó peer; a conexão é refeita na próxima chamada
+// se der erro.
+func (r *Raft) call(peer, method string, args, reply interface{}, timeout time.Duration) error {
+	client, err := r.client(peer)
+	if err != nil {
+		return err
+	}
+	call := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			r.dropClient(peer, client)
+		}
+		return call.Error
+	case <-time.After(timeout):
+		r.dropClient(peer, client)
+		return errRPCTimeout
+	case <-r.done:
+		return ErrStopped
+	}
+}
+
+// O nó que aceita a conexão manda um nonce novo e quem discou responde com
+// o HMAC-SHA256 do nonce com o segredo. O segredo não passa pela rede e uma
+// resposta capturada não serve para outra conexão. Só a abertura é
+// autenticada: o RPC depois dela não é cifrado, então a rede entre os nós
+// precisa ser privada.
+const nonceSize = 32
+
+func (r *Raft) proof(nonce []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(r.config.Secret))
+	mac.Write([]byte("raft:"))
+	mac.Write(nonce)
+	return mac.Sum(nil)
+}
+
+// authenticate desafia quem discou antes de servir o RPC na conexão.
+func (r *Raft) authenticate(conn net.Conn) bool {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return false
+	}
+	conn.SetDeadline(time.Now().Add(r.config.ElectionTimeout))
+	if _, err := conn.Write(nonce); err != nil {
+		return false
+	}
+	proof := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(conn, proof); err != nil {
+		return false
+	}
+	conn.SetDeadline(time.Time{})
+	return hmac.Equal(proof, r.proof(nonce))
+}
+
+// answer responde ao desafio do nó discado.
+func (r *Raft) answer(conn net.Conn) error {
+	nonce := make([]byte, nonceSize)
+	conn.SetDeadline(time.Now().Add(r.config.ElectionTimeout))
+	if _, err := io.ReadFull(conn, nonce); err != nil {
+		return err
+	}
+	if _, err := conn.Write(r.proof(nonce)); err != nil {
+		return err
+	}
+	return conn.SetDeadline(time.Time{})
+}
+
+func (r *Raft) client(peer string) (*rpc.Client, error) {
+	r.clientsMu.Lock()
+	defer r.clientsMu.Unlock()
+	if client := r.clients[peer]; client != nil {
+		return client, nil
+	}
+	conn, err := net.DialTimeout("tcp", r.config.Peers[peer], r.config.ElectionTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.answer(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	client := rpc.NewClient(conn)
+	r.clients[peer] = client
+	return client, nil
+}
+
+func (r *Raft) dropClient(peer string, client *rpc.Client) {
+	r.clientsMu.Lock()
+	defer r.clientsMu.Unlock()
+	if r.clients[peer] == client {
+		delete(r.clients, peer)
+	}
+	client.Close()
+}
@@ -0,0 +1,136 @@
+package raft
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	logBucket  = []byte("log")
+	metaBucket = []byte("meta")
+)
+
+// storage guarda o log e o estado persistente (termo e voto) num bbolt
+// próprio, separado do arquivo do KV.
+type storage struct {
+	db *bbolt.DB
+}
+
+type meta struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"votedFor"`
+}
+
+func openStorage(path string) (*storage, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open raft log: %v", err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(logBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(metaBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &storage{db: db}, nil
+}
+
+func (s *storage) close() error {
+	return s.db.Close()
+}
+
+func key(index uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, index)
+	return k
+}
+
+func (s *storage) state() (uint64, string) {
+	m := meta{}
+	s.db.View(func(tx *bbolt.Tx) error {
+		value := tx.Bucket(metaBucket).Get([]byte("state"))
+		if value != nil {
+			json.Unmarshal(value, &m)
+		}
+		return nil
+	})
+	return m.Term, m.VotedFor
+}
+
+func (s *storage) setState(term uint64, votedFor string) error {
+	value, _ := json.Marshal(meta{Term: term, VotedFor: votedFor})
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(metaBucket).Put([]byte("state"), value)
+	})
+}
+
+// entries devolve as entradas com índice maior que after, em ordem.
+func (s *storage) entries(after uint64) ([]Entry, error) {
+	entries := []Entry{}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(logBucket).Cursor()
+		for k, v := c.Seek(key(after + 1)); k != nil; k, v = c.Next() {
+			e := Entry{}
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (s *storage) append(entries []Entry) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(logBucket)
+		for _, e := range entries {
+			value, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(key(e.Index), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// truncate apaga as entradas a partir de from (inclusive).
+func (s *storage) truncate(from uint64) error {
+	return s.deleteWhere(func(index uint64) bool { return index >= from })
+}
+
+// compact apaga as entradas até upTo (inclusive), já aplicadas no KV.
+func (s *storage) compact(upTo uint64) error {
+	return s.deleteWhere(func(index uint64) bool { return index <= upTo })
+}
+
+func (s *storage) deleteWhere(match func(index uint64) bool) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(logBucket)
+		keys := [][]byte{}
+		b.ForEach(func(k, v []byte) error {
+			if match(binary.BigEndian.Uint64(k)) {
+				keys = append(keys, append([]byte{}, k...))
+			}
+			return nil
+		})
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -15,38 +15,64 @@ func (mq *MQ) Publish(topic, payload string) {
 	})
 }
 
+// SendTo entrega uma mensagem direta (cmd MSG) à conexão com esse id.
+func (mq *MQ) SendTo(id, topic, payload string) error {
+	return mq.sendTo("self", id, topic, payload)
+}
+
 func (mq *MQ) Subscribe(topic string, cb func(data MQData)) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
 	mq.subs[topic] = append(mq.subs[topic], "self")
 	mq.subself[topic] = append(mq.subself[topic], cb)
+	mq.interestChanged()
 }
 func (mq *MQ) Service(topic string, fn func(data MQData, replay func(err string, payload string))) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
 	mq.services[topic] = "self"
 	mq.serviceself[topic] = fn
+	mq.interestChanged()
 }
 
 func (mq *MQ) Request(topic, Payload string, timeout time.Duration) (string, error) {
+	res, err := mq.request(topic, Payload, timeout)
+	if err != nil {
+		return "", err
+	}
+	if res.Error != "" {
+		return "", errors.New("Error :" + res.Error)
+	}
+	return res.Payload, nil
+}
+
+// request envia o REQ como o próprio broker e espera a resposta; só devolve
+// erro no timeout.
+func (mq *MQ) request(topic, Payload string, timeout time.Duration) (MQResponse, error) {
 	reqId := uuid.New().String()
-	mq.chrequest[reqId] = make(chan MQResponse) // cria um canal de string
+	ch := make(chan MQResponse, 1)
+	mq.mu.Lock()
+	mq.chrequest[reqId] = ch
+	mq.mu.Unlock()
+	defer func() {
+		mq.mu.Lock()
+		delete(mq.chrequest, reqId)
+		mq.mu.Unlock()
+	}()
+
 	mq.handleReq("self", MQData{
 		Cmd:       "REQ",
 		FromId:    "self",
 		Topic:     topic,
 		RequestId: reqId,
 		Payload:   Payload,
+		Headers:   map[string]string{"timeout": timeout.String()},
 	})
-	ch, existe := mq.chrequest[reqId]
-	if !existe {
-		return "", fmt.Errorf("canal %s não existe", topic)
-	}
 	select {
 	case res := <-ch:
-		close(ch)
-		if res.Error != "" {
-			return "", errors.New("Error :" + res.Error)
-		}
-		return res.Payload, nil
+		return res, nil
 	case <-time.After(timeout):
-		close(ch)
-		return "", fmt.Errorf("timeout de %v expirado no canal %s", timeout, topic)
+		mq.metrics.requestTimeout("self", reqId)
+		return MQResponse{}, withCode(CodeTimeout, fmt.Errorf("timeout de %v expirado no canal %s", timeout, topic))
 	}
 }
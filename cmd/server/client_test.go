@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"errors"
+	client "mq/client/go"
+	"mq/utils"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Uma resposta que chega depois do timeout é descartada pelo client, sem
+// derrubar o read loop; requests concorrentes não disputam o mapa.
+func TestClientLateReply(t *testing.T) {
+	mq := startBroker(t, utils.MQConfig{Username: "root", Password: "pw"})
+	c := dialNode(t, mq)
+	svc := dialNode(t, mq)
+	replied := make(chan struct{})
+	svc.Service("slow", func(m client.MQData, reply func(string, string)) {
+		time.Sleep(200 * time.Millisecond)
+		reply("", "late")
+		close(replied)
+	})
+	svc.Service("echo", func(m client.MQData, reply func(string, string)) { reply("", m.Payload) })
+	eventually(t, "services", func() bool {
+		mq.mu.RLock()
+		defer mq.mu.RUnlock()
+		return mq.services["slow"] != "" && mq.services["echo"] != ""
+	})
+
+	if _, err := c.Request("slow", "x", 50*time.Millisecond); !errors.Is(err, client.ErrTimeout) {
+		t.Fatalf("err = %v", err)
+	}
+	<-replied
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if res, err := c.Request("echo", "hi", 2*time.Second); err != nil || res != "hi" {
+				errs <- errors.Join(err, errors.New("reply "+res))
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestClientGoAway(t *testing.T) {
+	mq := startBroker(t, utils.MQConfig{Username: "root", Password: "pw"})
+	reasons := make(chan string, 1)
+	c, err := client.Dial("mq://root:pw@127.0.0.1:"+strconv.Itoa(mq.config.Port), client.WithGoAway(func(reason string) {
+		reasons <- reason
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go mq.Shutdown(ctx)
+	select {
+	case reason := <-reasons:
+		if reason == "" {
+			t.Fatal("GOAWAY without a reason")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no GOAWAY callback")
+	}
+}
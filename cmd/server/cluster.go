@@ -0,0 +1,433 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mq/utils"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// As rotas ficam em mq.clients e mq.subs com o id "route:<nó>", então a
+// publicação e o request chegam nelas pelo caminho normal do Send.
+const routePrefix = "route:"
+
+const routeRetry = 2 * time.Second
+
+type route struct {
+	id       string
+	name     string
+	addr     string          // endereço de rotas anunciado pelo outro nó
+	conn     net.Conn        // R_SUB, R_SER e R_INFO vão direto
+	out      *outConn        // publicações, requests e respostas, em mq.clients
+	services map[string]bool // serviços do outro nó
+	sentSubs map[string]bool // interesse já anunciado para ele
+	sentSer  map[string]bool
+	dirty    chan struct{}
+}
+
+// RouteEvent é o payload de $SYS.cluster.join e $SYS.cluster.leave. Server
+// é o nó que viu a rota entrar ou sair, já que os eventos também correm o
+// cluster.
+type RouteEvent struct {
+	Server string `json:"server"`
+	Node   string `json:"node"`
+	Addr   string `json:"addr"`
+}
+
+func isRoute(id string) bool {
+	return strings.HasPrefix(id, routePrefix)
+}
+
+// StartCluster ouve conexões de rota e disca as rotas da configuração.
+// Cada nó repassa só o que recebeu de clientes locais, o que evita laços
+// na malha completa.
+func (mq *MQ) StartCluster(config utils.ClusterConfig) error {
+	if config.Secret == "" {
+		return errors.New("cluster secret is required")
+	}
+	mq.record(func(running *utils.ServerConfig) { running.Cluster = config })
+	if config.Name == "" {
+		config.Name = uuid.New().String()
+	}
+	addr := config.Host + ":" + strconv.Itoa(config.Port)
+	if config.Advertise == "" {
+		config.Advertise = addr
+	}
+	listener, err := mq.listen(addr)
+	if err != nil {
+		return err
+	}
+
+	mq.mu.Lock()
+	mq.cluster = config
+	mq.known[config.Advertise] = true
+	mq.mu.Unlock()
+	slog.Info("Cluster iniciado", "node", config.Name, "addr", addr)
+
+	for _, addr := range config.Routes {
+		mq.solicit(addr)
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if mq.closing() {
+				return nil
+			}
+			slog.Error("Erro ao aceitar rota", "err", err)
+			continue
+		}
+		go mq.handleRoute(conn, false)
+	}
+}
+
+// solicit disca o endereço se ele ainda não é conhecido.
+func (mq *MQ) solicit(addr string) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	if mq.known[addr] {
+		return
+	}
+	mq.known[addr] = true
+	go mq.solicitRoute(addr)
+}
+
+// solicitRoute mantém a rota discada: se ela cai, disca de novo. Enquanto
+// existir outra conexão com o mesmo nó (os dois discaram) só espera.
+func (mq *MQ) solicitRoute(addr string) {
+	for !mq.closing() {
+		name := ""
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		if err == nil {
+			name = mq.handleRoute(conn, true)
+			mq.mu.RLock()
+			self := name == mq.cluster.Name
+			mq.mu.RUnlock()
+			if self {
+				return
+			}
+		}
+		time.Sleep(routeRetry)
+		for name != "" && mq.conn(routePrefix+name) != nil {
+			time.Sleep(routeRetry)
+		}
+	}
+}
+
+// handleRoute faz o handshake (R_CONNECT nos dois sentidos, com o segredo
+// do cluster) e atende a rota até ela cair. Devolve o nome do outro nó.
+func (mq *MQ) handleRoute(conn net.Conn, solicited bool) string {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	mq.mu.RLock()
+	config := mq.cluster
+	mq.mu.RUnlock()
+	hello := MQData{
+		Cmd:     "R_CONNECT",
+		Topic:   config.Advertise,
+		Payload: config.Name,
+		Headers: map[string]string{"secret": config.Secret},
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if solicited {
+		mq.send(conn, hello)
+	}
+	str, err := reader.ReadString('\n')
+	if err != nil {
+		return ""
+	}
+	data, err := jsonToStruct(str)
+	if err != nil || data.Cmd != "R_CONNECT" {
+		return ""
+	}
+	if data.Error != "" {
+		slog.Warn("Rota recusada", "remote", conn.RemoteAddr().String(), "err", data.Error)
+		return ""
+	}
+	if config.Secret == "" || data.Headers["secret"] != config.Secret {
+		mq.send(conn, MQData{Cmd: "R_CONNECT", Error: "invalid secret", Code: CodePermissionDenied})
+		return ""
+	}
+	if !solicited {
+		mq.send(conn, hello)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	name := data.Payload
+	if name == "" || name == config.Name || strings.Contains(name, "/") {
+		return name
+	}
+	out := mq.outbound(conn)
+	defer out.Close()
+	r := &route{
+		id:       routePrefix + name,
+		name:     name,
+		addr:     data.Topic,
+		conn:     conn,
+		out:      out,
+		services: map[string]bool{},
+		sentSubs: map[string]bool{},
+		sentSer:  map[string]bool{},
+		dirty:    make(chan struct{}, 1),
+	}
+	initiator := name
+	if solicited {
+		initiator = config.Name
+	}
+	if !mq.addRoute(r, initiator) {
+		return name
+	}
+	defer mq.removeRoute(r)
+
+	addrs, _ := json.Marshal(mq.routeAddrs())
+	mq.send(conn, MQData{Cmd: "R_INFO", Payload: string(addrs)})
+	go mq.syncRoute(r)
+	mq.publishRouteEvent("$SYS.cluster.join", r)
+
+	mq.mu.RLock()
+	info := mq.info[r.id]
+	mq.mu.RUnlock()
+	mq.watch(out, r.id, info)
+	for {
+		str, err := reader.ReadString('\n')
+		if err != nil {
+			return name
+		}
+		data, err := jsonToStruct(str)
+		if err != nil {
+			continue
+		}
+		mq.metrics.in(data.Cmd, len(str))
+		info.bytesIn.Add(uint64(len(str)))
+		mq.handleRouteData(r, *data)
+	}
+}
+
+func (mq *MQ) handleRouteData(r *route, data MQData) {
+	defer mq.recoverRoute(r.id, data)
+	switch data.Cmd {
+	case "R_SUB":
+		mq.mu.Lock()
+		if !slices.Contains(mq.subs[data.Topic], r.id) {
+			mq.subs[data.Topic] = append(mq.subs[data.Topic], r.id)
+		}
+		mq.mu.Unlock()
+	case "R_UNSUB":
+		mq.removeSub(r.id, data.Topic)
+	case "R_SER":
+		mq.mu.Lock()
+		r.services[data.Topic] = true
+		if mq.services[data.Topic] == "" {
+			mq.services[data.Topic] = r.id
+		}
+		mq.mu.Unlock()
+	case "R_UNSER":
+		mq.mu.Lock()
+		delete(r.services, data.Topic)
+		if mq.services[data.Topic] == r.id {
+			delete(mq.services, data.Topic)
+			if other := mq.remoteService(data.Topic); other != "" {
+				mq.services[data.Topic] = other
+			}
+		}
+		mq.mu.Unlock()
+	case "R_INFO":
+		addrs := []string{}
+		json.Unmarshal([]byte(data.Payload), &addrs)
+		for _, addr := range addrs {
+			mq.solicit(addr)
+		}
+	case "PUB":
+		go mq.publish(data, originRoute)
+	case "REQ":
+		mq.routeReq(r, data)
+	case "RES":
+		mq.handleRes(r.id, data)
+	}
+}
+
+// addRoute registra a rota. Se os dois nós discaram ao mesmo tempo fica a
+// conexão aberta pelo nó de menor nome, decisão que os dois lados tomam
+// igual.
+func (mq *MQ) addRoute(r *route, initiator string) bool {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	if old := mq.routes[r.id]; old != nil {
+		if initiator != min(mq.cluster.Name, r.name) {
+			return false
+		}
+		delete(mq.routes, r.id)
+		close(old.dirty)
+		mq.removeInterest(r.id)
+		old.out.Close()
+	}
+	mq.routes[r.id] = r
+	mq.known[r.addr] = true
+	mq.clients[r.id] = r.out
+	mq.ips[r.id] = r.conn.RemoteAddr().String()
+	mq.info[r.id] = &connInfo{Name: r.id, Kind: "route", Connected: time.Now()}
+	r.dirty <- struct{}{}
+	return true
+}
+
+func (mq *MQ) removeRoute(r *route) {
+	mq.mu.Lock()
+	if mq.routes[r.id] != r {
+		mq.mu.Unlock()
+		return
+	}
+	delete(mq.routes, r.id)
+	close(r.dirty)
+	delete(mq.clients, r.id)
+	delete(mq.ips, r.id)
+	delete(mq.info, r.id)
+	mq.removeInterest(r.id)
+	mq.mu.Unlock()
+	mq.publishRouteEvent("$SYS.cluster.leave", r)
+}
+
+// routeAddrs são os endereços de rota deste nó e dos nós ligados a ele,
+// mandados em R_INFO para quem chega completar a malha.
+func (mq *MQ) routeAddrs() []string {
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+	addrs := []string{mq.cluster.Advertise}
+	for _, r := range mq.routes {
+		if r.addr != "" {
+			addrs = append(addrs, r.addr)
+		}
+	}
+	return addrs
+}
+
+// interestChanged avisa as rotas que as inscrições ou serviços locais
+// mudaram. Chamado com o lock.
+func (mq *MQ) interestChanged() {
+	for _, r := range mq.routes {
+		select {
+		case r.dirty <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// syncRoute anuncia para a rota a diferença entre o interesse local e o que
+// já foi anunciado. Termina quando a rota é removida.
+func (mq *MQ) syncRoute(r *route) {
+	for range r.dirty {
+		subs := map[string]bool{}
+		services := map[string]bool{}
+		mq.mu.RLock()
+		for topic, ids := range mq.subs {
+			if slices.ContainsFunc(ids, func(id string) bool { return !isRoute(id) }) {
+				subs[topic] = true
+			}
+		}
+		for topic, id := range mq.services {
+			if !isRoute(id) {
+				services[topic] = true
+			}
+		}
+		mq.mu.RUnlock()
+		mq.announce(r, "R_SUB", "R_UNSUB", r.sentSubs, subs)
+		mq.announce(r, "R_SER", "R_UNSER", r.sentSer, services)
+	}
+}
+
+func (mq *MQ) announce(r *route, add, remove string, sent, current map[string]bool) {
+	for topic := range current {
+		if !sent[topic] {
+			sent[topic] = true
+			mq.send(r.conn, MQData{Cmd: add, Topic: topic})
+		}
+	}
+	for topic := range sent {
+		if !current[topic] {
+			delete(sent, topic)
+			mq.send(r.conn, MQData{Cmd: remove, Topic: topic})
+		}
+	}
+}
+
+// remoteService devolve uma rota que oferece o serviço. Chamado com o lock.
+func (mq *MQ) remoteService(topic string) string {
+	for _, r := range mq.routes {
+		if r.services[topic] {
+			return r.id
+		}
+	}
+	return ""
+}
+
+// routeReq entrega a um serviço local um REQ vindo de outro nó. O ReplayId
+// vira "route:<nó>/<id original>" para a resposta voltar pela rota.
+func (mq *MQ) routeReq(r *route, data MQData) {
+	mq.mu.RLock()
+	req := mq.services[data.Topic]
+	fn := mq.serviceself[data.Topic]
+	mq.mu.RUnlock()
+
+	if req == "" || isRoute(req) {
+		mq.metrics.noResponder()
+		return
+	}
+	replay := r.id + "/" + data.ReplayId
+	mq.metrics.requestStarted(replay, data.RequestId, req, data.Topic, requestTimeoutOf(data))
+	if req == "self" {
+		res := MQData{
+			Cmd:       "RES",
+			Topic:     data.Topic,
+			RequestId: data.RequestId,
+			ReplayId:  replay,
+		}
+		go func() {
+			defer mq.recoverHandler(r.id, data, "self", res)
+			fn(data, func(err string, payload string) {
+				res := res
+				res.Error, res.Payload = err, payload
+				mq.handleRes("self", res)
+			})
+		}()
+		return
+	}
+	mq.Send(req, MQData{
+		Cmd:       "REQ",
+		ReplayId:  replay,
+		RequestId: data.RequestId,
+		Topic:     data.Topic,
+		Payload:   data.Payload,
+		Headers:   data.Headers,
+	})
+}
+
+// splitReplay separa o ReplayId montado por routeReq.
+func splitReplay(replay string) (string, string, bool) {
+	if !isRoute(replay) {
+		return "", "", false
+	}
+	i := strings.LastIndex(replay, "/")
+	if i < 0 {
+		return "", "", false
+	}
+	return replay[:i], replay[i+1:], true
+}
+
+func (mq *MQ) publishRouteEvent(topic string, r *route) {
+	mq.mu.RLock()
+	server := mq.cluster.Name
+	mq.mu.RUnlock()
+	str, _ := json.Marshal(RouteEvent{Server: server, Node: r.name, Addr: r.addr})
+	mq.handlePub(MQData{
+		Cmd:     "PUB",
+		Topic:   topic,
+		Payload: string(str),
+	})
+}
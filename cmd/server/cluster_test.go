@@ -0,0 +1,91 @@
+package server
+
+import (
+	client "mq/client/go"
+	"mq/utils"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// startNode sobe um broker com o cluster em outra porta de localhost e
+// devolve o endereço de rotas dele.
+func startNode(t *testing.T, name, secret string, routes ...string) (*MQ, string) {
+	t.Helper()
+	mq := startBroker(t, utils.MQConfig{Username: "root", Password: "pw"})
+	port := freePort(t)
+	addr := "127.0.0.1:" + strconv.Itoa(port)
+	go mq.StartCluster(utils.ClusterConfig{Name: name, Host: "127.0.0.1", Port: port, Routes: routes, Secret: secret})
+	waitListen(t, addr)
+	return mq, addr
+}
+
+func routeCount(mq *MQ) int {
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+	return len(mq.routes)
+}
+
+func dialNode(t *testing.T, mq *MQ) *client.MQ {
+	t.Helper()
+	c, err := client.Dial("mq://root:pw@127.0.0.1:" + strconv.Itoa(mq.config.Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Stop() })
+	return c
+}
+
+func TestClusterMesh(t *testing.T) {
+	a, addrA := startNode(t, "a", "s")
+	b, _ := startNode(t, "b", "s", addrA)
+	c, _ := startNode(t, "c", "s", addrA)
+	// b e c só conhecem a; o R_INFO completa a malha
+	eventually(t, "full mesh", func() bool {
+		return routeCount(a) == 2 && routeCount(b) == 2 && routeCount(c) == 2
+	})
+
+	ca, cb, cc := dialNode(t, a), dialNode(t, b), dialNode(t, c)
+	got := make(chan string, 1)
+	cc.Subscribe("x.*", func(m client.MQData) { got <- m.Payload })
+	cb.Service("svc", func(m client.MQData, reply func(string, string)) { reply("", "b:"+m.Payload) })
+	eventually(t, "interest on a", func() bool {
+		a.mu.RLock()
+		defer a.mu.RUnlock()
+		return slices.Contains(a.subs["x.*"], "route:c") && a.services["svc"] == "route:b"
+	})
+
+	ca.Publish("x.1", "hello")
+	select {
+	case payload := <-got:
+		if payload != "hello" {
+			t.Fatalf("got %q", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish did not cross the route")
+	}
+
+	res, err := ca.Request("svc", "ping", 5*time.Second)
+	if err != nil || res != "b:ping" {
+		t.Fatalf("request from a = %q, %v", res, err)
+	}
+	res, err = c.Request("svc", "self", 5*time.Second)
+	if err != nil || res != "b:self" {
+		t.Fatalf("request from c = %q, %v", res, err)
+	}
+}
+
+func TestClusterSecret(t *testing.T) {
+	a, addrA := startNode(t, "a", "s")
+	d, _ := startNode(t, "d", "other", addrA)
+	time.Sleep(300 * time.Millisecond)
+	if routeCount(a) != 0 || routeCount(d) != 0 {
+		t.Fatalf("route with the wrong secret: a=%d d=%d", routeCount(a), routeCount(d))
+	}
+
+	e := startBroker(t, utils.MQConfig{})
+	if err := e.StartCluster(utils.ClusterConfig{Name: "e", Host: "127.0.0.1", Port: freePort(t)}); err == nil {
+		t.Fatal("cluster started without a secret")
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"errors"
+	"mq/cmd/db"
+	"mq/cmd/raft"
+)
+
+// Códigos estáveis do campo code das respostas; error continua com a
+// mensagem para humanos, que pode mudar.
+const (
+	CodeNotFound         = "not_found"
+	CodePermissionDenied = "permission_denied"
+	CodeInvalidRequest   = "invalid_request"
+	CodeTimeout          = "timeout"
+	CodeNoResponders     = "no_responders"
+	CodeLimitExceeded    = "limit_exceeded"
+	CodeConflict         = "conflict"
+	CodeInternal         = "internal"
+)
+
+var (
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrNoResponders     = errors.New("no responders")
+	ErrThrottled        = errors.New("rate limit exceeded")
+)
+
+// codeError dá um código a um erro que não tem sentinela própria.
+type codeError struct {
+	code string
+	err  error
+}
+
+func (e *codeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codeError) Unwrap() error {
+	return e.err
+}
+
+func withCode(code string, err error) error {
+	return &codeError{code: code, err: err}
+}
+
+// errorCode classifica err para o campo code; o que não é conhecido é
+// internal.
+func errorCode(err error) string {
+	var coded *codeError
+	switch {
+	case errors.As(err, &coded):
+		return coded.code
+	case errors.Is(err, ErrPermissionDenied):
+		return CodePermissionDenied
+	case errors.Is(err, db.ErrBucketNotFound), errors.Is(err, db.ErrCollectionNotFound),
+		errors.Is(err, db.ErrDocumentNotFound):
+		return CodeNotFound
+	case errors.Is(err, db.ErrInvalidQuery), errors.Is(err, db.ErrInvalidDocument),
+		errors.Is(err, db.ErrStoreBucket):
+		return CodeInvalidRequest
+	case errors.Is(err, ErrPayloadTooLarge), errors.Is(err, ErrLineTooLong),
+		errors.Is(err, ErrMaxConnections), errors.Is(err, ErrMaxSubscriptions),
+		errors.Is(err, ErrMaxServices), errors.Is(err, ErrMaxBatchItems), errors.Is(err, ErrThrottled):
+		return CodeLimitExceeded
+	case errors.Is(err, ErrNoResponders):
+		return CodeNoResponders
+	case errors.Is(err, ErrAuthTimeout), errors.Is(err, ErrIdleTimeout),
+		errors.Is(err, raft.ErrTimeout), errors.Is(err, raft.ErrNoLeader):
+		return CodeTimeout
+	case errors.Is(err, raft.ErrLeadershipLost):
+		return CodeConflict
+	}
+	return CodeInternal
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+// ConnStat é uma linha de A_CONNS.
+type ConnStat struct {
+	ID         string    `json:"id"`
+	RemoteAddr string    `json:"remoteAddr"`
+	User       string    `json:"user"`
+	Name       string    `json:"name"`
+	Connected  time.Time `json:"connected"`
+	BytesIn    uint64    `json:"bytesIn"`
+	BytesOut   uint64    `json:"bytesOut"`
+}
+
+// ConnInterest são as inscrições e serviços de uma conexão (A_SUBS).
+type ConnInterest struct {
+	Subscriptions []string `json:"subscriptions"`
+	Services      []string `json:"services"`
+}
+
+// WhoAmI descreve a conexão e as permissões de quem perguntou.
+type WhoAmI struct {
+	ID         string    `json:"id"`
+	User       string    `json:"user"`
+	Name       string    `json:"name"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Connected  time.Time `json:"connected"`
+	IsAdmin    bool      `json:"isAdmin"`
+	Publish    []string  `json:"publish"`
+	Subscribe  []string  `json:"subscribe"`
+}
+
+func (mq *MQ) isAdmin(id string) bool {
+	user, ok := mq.userOf(id)
+	return ok && user.IsAdmin
+}
+
+// handleAdmin atende A_CONNS, A_SUBS, A_KICK e A_RELOAD, só para
+// administradores. A resposta usa o mesmo cmd, com o JSON no payload.
+func (mq *MQ) handleAdmin(id string, data MQData) {
+	res := MQData{
+		Cmd:       data.Cmd,
+		ReplayId:  id,
+		RequestId: data.RequestId,
+		Topic:     data.Topic,
+	}
+	if !mq.isAdmin(id) {
+		res.Error = ErrPermissionDenied.Error()
+		res.Code = CodePermissionDenied
+		mq.Send(id, res)
+		return
+	}
+
+	var v interface{}
+	switch data.Cmd {
+	case "A_CONNS":
+		v = mq.connections()
+	case "A_SUBS":
+		v = mq.interest(data.Topic)
+	case "A_KICK":
+		if err := mq.kick(data.Topic); err != nil {
+			res.Error = err.Error()
+			res.Code = errorCode(err)
+		} else {
+			res.Payload = "ok"
+		}
+		mq.Send(id, res)
+		return
+	case "A_RELOAD":
+		report, err := mq.ReloadConfig()
+		if err != nil {
+			res.Error = err.Error()
+			res.Code = CodeInvalidRequest
+			mq.Send(id, res)
+			return
+		}
+		v = report
+	}
+	str, err := json.Marshal(v)
+	if err != nil {
+		res.Error = err.Error()
+		res.Code = CodeInternal
+	} else {
+		res.Payload = string(str)
+	}
+	mq.Send(id, res)
+}
+
+func (mq *MQ) connections() []ConnStat {
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+	conns := make([]ConnStat, 0, len(mq.clients))
+	for id := range mq.clients {
+		stat := ConnStat{ID: id, RemoteAddr: mq.ips[id]}
+		if info := mq.info[id]; info != nil {
+			stat.User = info.User
+			stat.Name = info.Name
+			stat.Connected = info.Connected
+			stat.BytesIn = info.bytesIn.Load()
+			stat.BytesOut = info.bytesOut.Load()
+		}
+		conns = append(conns, stat)
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].Connected.Before(conns[j].Connected) })
+	return conns
+}
+
+// interest agrupa inscrições e serviços por id de conexão; com conn != ""
+// devolve só essa conexão.
+func (mq *MQ) interest(conn string) map[string]*ConnInterest {
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+	result := map[string]*ConnInterest{}
+	get := func(id string) *ConnInterest {
+		if result[id] == nil {
+			result[id] = &ConnInterest{Subscriptions: []string{}, Services: []string{}}
+		}
+		return result[id]
+	}
+	for topic, ids := range mq.subs {
+		for _, id := range ids {
+			if conn == "" || id == conn {
+				get(id).Subscriptions = append(get(id).Subscriptions, topic)
+			}
+		}
+	}
+	for topic, id := range mq.services {
+		if conn == "" || id == conn {
+			get(id).Services = append(get(id).Services, topic)
+		}
+	}
+	for _, in := range result {
+		sort.Strings(in.Subscriptions)
+		sort.Strings(in.Services)
+	}
+	return result
+}
+
+// kick derruba a conexão sem guardar a sessão nem publicar o last-will.
+func (mq *MQ) kick(id string) error {
+	mq.mu.Lock()
+	conn := mq.clients[id]
+	if info := mq.info[id]; info != nil {
+		info.Clean = true
+	}
+	mq.mu.Unlock()
+	if conn == nil {
+		return withCode(CodeNotFound, errors.New("connection not found"))
+	}
+	return conn.Close()
+}
+
+func (mq *MQ) handleWhoAmI(id string, data MQData) {
+	mq.mu.RLock()
+	me := WhoAmI{ID: id, RemoteAddr: mq.ips[id]}
+	if info := mq.info[id]; info != nil {
+		me.User = info.User
+		me.Name = info.Name
+		me.Connected = info.Connected
+	}
+	user := mq.users[me.User]
+	mq.mu.RUnlock()
+	me.IsAdmin = user.IsAdmin
+	me.Publish = user.Publish
+	me.Subscribe = user.Subscribe
+
+	str, _ := json.Marshal(me)
+	mq.Send(id, MQData{
+		Cmd:       "WHOAMI",
+		ReplayId:  id,
+		RequestId: data.RequestId,
+		Payload:   string(str),
+	})
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"mq/utils"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAdminCommands(t *testing.T) {
+	mq := startBroker(t, utils.MQConfig{Username: "root", Password: "pw", Users: []utils.User{
+		{Username: "alice", Password: "a", Publish: []string{"x.*"}},
+	}})
+	admin, cnn := login(t, mq, "root", "pw", map[string]string{"name": "ops"})
+	rootID := cnn.Payload
+	alice, cnn := login(t, mq, "alice", "a", nil)
+	aliceID := cnn.Payload
+	alice.subscribe(t, "x.y")
+
+	// quem não é admin só tem o WHOAMI
+	for _, cmd := range []string{"A_CONNS", "A_SUBS", "A_KICK", "A_RELOAD"} {
+		alice.send(t, MQData{Cmd: cmd, Topic: rootID, RequestId: cmd})
+		if res := alice.expect(t, cmd, rootID); res.Code != CodePermissionDenied {
+			t.Fatalf("%s by non-admin = %+v", cmd, res)
+		}
+	}
+	alice.send(t, MQData{Cmd: "WHOAMI", RequestId: "me"})
+	me := WhoAmI{}
+	if err := json.Unmarshal([]byte(alice.expect(t, "WHOAMI", "").Payload), &me); err != nil {
+		t.Fatal(err)
+	}
+	if me.ID != aliceID || me.User != "alice" || me.IsAdmin || !slices.Equal(me.Publish, []string{"x.*"}) {
+		t.Fatalf("whoami = %+v", me)
+	}
+
+	admin.send(t, MQData{Cmd: "A_CONNS", RequestId: "conns"})
+	conns := []ConnStat{}
+	if err := json.Unmarshal([]byte(admin.expect(t, "A_CONNS", "").Payload), &conns); err != nil {
+		t.Fatal(err)
+	}
+	if len(conns) != 2 || conns[0].ID != rootID || conns[0].Name != "ops" || conns[1].User != "alice" || conns[1].BytesIn == 0 {
+		t.Fatalf("connections = %+v", conns)
+	}
+
+	admin.send(t, MQData{Cmd: "A_SUBS", Topic: aliceID, RequestId: "subs"})
+	interest := map[string]*ConnInterest{}
+	if err := json.Unmarshal([]byte(admin.expect(t, "A_SUBS", aliceID).Payload), &interest); err != nil {
+		t.Fatal(err)
+	}
+	if len(interest) != 1 || !slices.Equal(interest[aliceID].Subscriptions, []string{"x.y"}) {
+		t.Fatalf("interest = %v", interest)
+	}
+
+	admin.send(t, MQData{Cmd: "A_KICK", Topic: "nobody", RequestId: "kick"})
+	if res := admin.expect(t, "A_KICK", "nobody"); res.Code != CodeNotFound {
+		t.Fatalf("kick unknown = %+v", res)
+	}
+}
+
+func TestAdminKickSkipsSessionAndWill(t *testing.T) {
+	mq := startBroker(t, utils.MQConfig{Username: "root", Password: "pw", SessionGrace: 5 * time.Second})
+	admin, _ := login(t, mq, "root", "pw", nil)
+	watcher, _ := login(t, mq, "root", "pw", nil)
+	watcher.subscribe(t, "will.c1")
+	watcher.subscribe(t, "$SYS.conn.disconnect")
+	c1, cnn := login(t, mq, "root", "pw", map[string]string{"will-topic": "will.c1", "will-payload": "gone"})
+	id := cnn.Payload
+	c1.subscribe(t, "a.b")
+
+	admin.send(t, MQData{Cmd: "A_KICK", Topic: id, RequestId: "kick"})
+	if res := admin.expect(t, "A_KICK", id); res.Payload != "ok" {
+		t.Fatalf("kick = %+v", res)
+	}
+	c1.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(c1.r); err != nil {
+		t.Fatalf("kicked connection: %v", err)
+	}
+
+	// a sessão não fica para retomar e o last-will não sai
+	ev := watcher.expect(t, "PUB", "$SYS.conn.disconnect")
+	event := ConnEvent{}
+	if err := json.Unmarshal([]byte(ev.Payload), &event); err != nil || event.ID != id || !event.Clean {
+		t.Fatalf("disconnect event = %s (%v)", ev.Payload, err)
+	}
+	watcher.silent(t, 200*time.Millisecond, "PUB", "will.c1")
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+	if mq.sessions[id] != nil || mq.clients[id] != nil || len(mq.subs["a.b"]) != 0 {
+		t.Fatalf("kicked connection left state: session %v, subs %v", mq.sessions[id], mq.subs)
+	}
+}
@@ -3,37 +3,43 @@ package server
 import (
 	"bufio"
 	"errors"
-	"fmt"
-	"net"
 )
 
-func (mq *MQ) handleAuth(conn net.Conn) (string, error) {
-	reqId := ""
-	reader := bufio.NewReader(conn)
+// handleAuth espera o AUTH e devolve o frame recebido: Topic é o usuário e
+// Headers traz as opções da conexão (name, will-topic, will-payload).
+func (mq *MQ) handleAuth(reader *bufio.Reader) (MQData, error) {
+	auth := MQData{}
 	for {
 		// Lê a mensagem do cliente até encontrar uma nova linha
-		str, err := reader.ReadString('\n')
+		str, err := readLine(reader, mq.limits().MaxLine)
+		if errors.Is(err, ErrLineTooLong) {
+			return auth, err
+		}
 		if err != nil {
-			fmt.Printf("Erro ao ler: %s\n", err.Error())
 			break
 		}
 
 		data, err := jsonToStruct(str)
 		if err != nil {
-			fmt.Printf(": %s\n", err.Error())
-			return reqId, err
+			return auth, withCode(CodeInvalidRequest, err)
 		}
-		reqId = data.RequestId
+		auth.RequestId = data.RequestId
 		switch data.Cmd {
 		case "AUTH":
-			user := mq.auth[data.Topic]
-			if user != data.Payload {
-				return reqId, errors.New("Invalid auth")
+			auth.Topic = data.Topic
+			user, ok := mq.checkPassword(data.Topic, data.Payload)
+			if !ok {
+				return auth, withCode(CodePermissionDenied, errors.New("Invalid auth"))
+			}
+			if will := data.Headers["will-topic"]; will != "" {
+				if !validPublishTopic(will) || !userCanPublish(user, will) {
+					return auth, withCode(CodePermissionDenied, errors.New("will topic not allowed"))
+				}
 			}
-			return reqId, nil
+			return *data, nil
 		}
 
 	}
 
-	return reqId, nil
+	return auth, errors.New("connection closed before auth")
 }
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mq/cmd/db"
+)
+
+// BatchRequest é o payload de um BATCH: itens PUB, SET ou DB_CI processados
+// em ordem. Com Tx os itens SET/DB_CI vão numa única transação e os PUB só
+// são publicados depois do commit.
+type BatchRequest struct {
+	Tx    bool     `json:"tx"`
+	Items []MQData `json:"items"`
+}
+
+// handleBatch devolve true quando o rate limit derrubou a conexão.
+func (mq *MQ) handleBatch(id string, info *connInfo, data MQData) bool {
+	req := BatchRequest{}
+	err := json.Unmarshal([]byte(data.Payload), &req)
+	if err != nil {
+		mq.Send(id, MQData{
+			Cmd:       "BATCH",
+			ReplayId:  id,
+			RequestId: data.RequestId,
+			Topic:     data.Topic,
+			Error:     "invalid batch: " + err.Error(),
+			Code:      CodeInvalidRequest,
+		})
+		return false
+	}
+	if max := mq.limits().MaxBatchItems; len(req.Items) > max {
+		mq.reject(id, data, ErrMaxBatchItems)
+		return false
+	}
+	// um item acima do orçamento recusa o lote inteiro, sem executar nada
+	if mq.batchThrottled(info, req.Items) {
+		return mq.throttle(id, info, data)
+	}
+
+	var results []MQResponse
+	if req.Tx {
+		results = mq.batchTx(id, req.Items)
+	} else {
+		results = make([]MQResponse, len(req.Items))
+		for i, item := range req.Items {
+			results[i] = mq.batchItem(id, item)
+		}
+	}
+
+	str, _ := json.Marshal(results)
+	mq.Send(id, MQData{
+		Cmd:       "BATCH",
+		ReplayId:  id,
+		RequestId: data.RequestId,
+		Topic:     data.Topic,
+		Payload:   string(str),
+	})
+	return false
+}
+
+func (mq *MQ) batchItem(id string, item MQData) MQResponse {
+	switch item.Cmd {
+	case "PUB":
+		if !validPublishTopic(item.Topic) {
+			return MQResponse{Error: "invalid publish topic", Code: CodeInvalidRequest}
+		}
+		if !mq.canPublish(id, item.Topic) {
+			return MQResponse{Error: "permission denied", Code: CodePermissionDenied}
+		}
+		mq.handlePub(item)
+		return MQResponse{Payload: "ok"}
+	case "SET":
+		bucket, key := splitKey(item.Topic)
+		if err := mq.kvSet(bucket, key, item.Payload); err != nil {
+			return MQResponse{Error: err.Error(), Code: errorCode(err)}
+		}
+		return MQResponse{Payload: "ok"}
+	case "DB_CI":
+		doc := db.Document{}
+		if err := json.Unmarshal([]byte(item.Payload), &doc); err != nil {
+			return MQResponse{Error: err.Error(), Code: CodeInvalidRequest}
+		}
+		id_, err := mq.DB.Insert(item.Topic, doc)
+		if err != nil {
+			return MQResponse{Error: err.Error(), Code: errorCode(err)}
+		}
+		return MQResponse{Payload: id_}
+	}
+	return MQResponse{Error: "unsupported batch command " + item.Cmd, Code: CodeInvalidRequest}
+}
+
+func (mq *MQ) batchTx(id string, items []MQData) []MQResponse {
+	results := make([]MQResponse, len(items))
+	fail := func(code, err string) []MQResponse {
+		for i := range results {
+			results[i] = MQResponse{Error: err, Code: code}
+		}
+		return results
+	}
+
+	ops := []db.BatchOp{}
+	opIndex := []int{}
+	for i, item := range items {
+		switch item.Cmd {
+		case "PUB":
+			if !validPublishTopic(item.Topic) {
+				return fail(CodeInvalidRequest, "invalid publish topic")
+			}
+			if !mq.canPublish(id, item.Topic) {
+				return fail(CodePermissionDenied, "permission denied")
+			}
+		case "SET":
+			bucket, key := splitKey(item.Topic)
+			ops = append(ops, db.BatchOp{Kind: db.BatchSet, Bucket: bucket, Key: key, Value: item.Payload})
+			opIndex = append(opIndex, i)
+		case "DB_CI":
+			doc := db.Document{}
+			if err := json.Unmarshal([]byte(item.Payload), &doc); err != nil {
+				return fail(CodeInvalidRequest, err.Error())
+			}
+			ops = append(ops, db.BatchOp{Kind: db.BatchInsert, Collection: item.Topic, Doc: doc})
+			opIndex = append(opIndex, i)
+		default:
+			return fail(CodeInvalidRequest, "unsupported batch command "+item.Cmd)
+		}
+	}
+
+	var res []string
+	var err error
+	if mq.raft != nil {
+		res, err = mq.batchReplicated(ops)
+	} else {
+		res, err = mq.DB.Batch(ops)
+	}
+	if err != nil {
+		var batchErr *db.BatchError
+		if errors.As(err, &batchErr) {
+			return fail(errorCode(batchErr.Err), fmt.Sprintf("item %d: %v", opIndex[batchErr.Index], batchErr.Err))
+		}
+		return fail(errorCode(err), err.Error())
+	}
+	for i, r := range res {
+		results[opIndex[i]] = MQResponse{Payload: r}
+	}
+	for i, item := range items {
+		if item.Cmd == "PUB" {
+			mq.handlePub(item)
+			results[i] = MQResponse{Payload: "ok"}
+		}
+	}
+	return results
+}
+
+// batchReplicated grava os SET da transação numa única entrada do log. As
+// coleções não são replicadas: um lote só de DB_CI vai direto para o banco
+// local, mas não dá para misturar os dois na mesma transação.
+func (mq *MQ) batchReplicated(ops []db.BatchOp) ([]string, error) {
+	kvOps := []db.KVOp{}
+	res := []string{}
+	for _, op := range ops {
+		if op.Kind == db.BatchSet {
+			kvOps = append(kvOps, db.KVOp{Kind: db.KVSet, Bucket: op.Bucket, Key: op.Key, Value: op.Value})
+			res = append(res, "ok")
+		}
+	}
+	if len(kvOps) == 0 {
+		return mq.DB.Batch(ops)
+	}
+	if len(kvOps) != len(ops) {
+		return nil, withCode(CodeInvalidRequest, errors.New("transactional batch can't mix SET and DB_CI with replicated KV"))
+	}
+	return res, mq.replicate(kvOps...)
+}
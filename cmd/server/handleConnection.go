@@ -1,29 +1,77 @@
 package server
 
 import (
+	"bufio"
 	"net"
+	"time"
 
 	"github.com/google/uuid"
 )
 
-func (mq *MQ) handleConnection(conn net.Conn, reqId string) {
-	id := uuid.New().String()
-	defer func() {
-		mq.clients[id].Close()
-		delete(mq.ips, id)
-		delete(mq.clients, id)
-	}()
-	func() {
+func (mq *MQ) handleConnection(conn net.Conn, reader *bufio.Reader, auth MQData) {
+	info := &connInfo{
+		User:        auth.Topic,
+		Name:        auth.Headers["name"],
+		Kind:        "client",
+		WillTopic:   auth.Headers["will-topic"],
+		WillPayload: auth.Headers["will-payload"],
+		Connected:   time.Now(),
+	}
+	out := mq.outbound(conn)
+	conn = out
+	id, resumed := mq.resume(out, auth, info)
+	if !resumed {
+		id = uuid.New().String()
+		info.Token = uuid.New().String()
+		mq.mu.Lock()
 		mq.clients[id] = conn
 		mq.ips[id] = conn.RemoteAddr().String()
-		mq.Send(id, MQData{
-			Cmd:       "CNN",
-			Topic:     "",
-			RequestId: reqId,
-			Payload:   id,
-		})
+		mq.info[id] = info
+		mq.mu.Unlock()
+		mq.Send(id, mq.cnn(id, auth.RequestId, info, false))
+	}
+	mq.watch(out, id, info)
+	info.touch()
+	done := make(chan struct{})
+	go mq.heartbeat(id, out, info, done)
+	log := mq.connLog(id)
+	log.Info("Cliente conectado", "name", info.Name, "remote", conn.RemoteAddr().String(), "resumed", resumed)
+	defer func() {
+		close(done)
+		log.Info("Cliente desconectado")
+		out.closeAfterFlush(time.Second)
+		if mq.detach(id, conn, info) {
+			mq.handleDisconnect(id, conn, info)
+		}
 	}()
+	// quem retoma a sessão não chegou a contar como desconectado
+	if !resumed {
+		mq.publishConnEvent("$SYS.conn.connect", id, conn, info)
+	}
 
-	mq.handleProcess(id, conn)
+	mq.handleProcess(id, conn, reader)
+
+}
 
+// cnn monta a resposta ao AUTH. Com sessões ligadas ela traz o id de sessão
+// e o token para retomar a conexão.
+func (mq *MQ) cnn(id, reqId string, info *connInfo, resumed bool) MQData {
+	data := MQData{
+		Cmd:       "CNN",
+		Topic:     "",
+		RequestId: reqId,
+		Payload:   id,
+		Headers:   map[string]string{},
+	}
+	limitHeaders(mq.limits(), data.Headers)
+	if mq.Config().SessionGrace > 0 {
+		resumedStr := "false"
+		if resumed {
+			resumedStr = "true"
+		}
+		data.Headers["session"] = id
+		data.Headers["resume-token"] = info.Token
+		data.Headers["resumed"] = resumedStr
+	}
+	return data
 }
@@ -2,9 +2,22 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"mq/cmd/db"
 )
 
+// dbInvalid responde ao comando de coleção com payload que não dá para usar.
+func (mq *MQ) dbInvalid(id string, data MQData, err error) {
+	mq.Send(id, MQData{
+		Cmd:       data.Cmd,
+		ReplayId:  id,
+		RequestId: data.RequestId,
+		Topic:     data.Topic,
+		Error:     err.Error(),
+		Code:      CodeInvalidRequest,
+	})
+}
+
 func (mq *MQ) handledbCreateCollection(id string, data MQData) {
 	err := mq.DB.CreateIndex(data.Topic, data.Payload)
 	if err != nil {
@@ -14,6 +27,7 @@ func (mq *MQ) handledbCreateCollection(id string, data MQData) {
 			RequestId: data.RequestId,
 			Topic:     data.Topic,
 			Error:     err.Error(),
+			Code:      errorCode(err),
 			Payload:   "",
 		})
 		return
@@ -40,6 +54,7 @@ func (mq *MQ) handledbDeleteCollection(id string, data MQData) {
 			RequestId: data.RequestId,
 			Topic:     data.Topic,
 			Error:     err.Error(),
+			Code:      errorCode(err),
 			Payload:   "",
 		})
 		return
@@ -56,7 +71,10 @@ func (mq *MQ) handledbDeleteCollection(id string, data MQData) {
 }
 func (mq *MQ) handledbInsertCollection(id string, data MQData) {
 	doc := db.Document{}
-	json.Unmarshal([]byte(data.Payload), &doc)
+	if err := json.Unmarshal([]byte(data.Payload), &doc); err != nil {
+		mq.dbInvalid(id, data, err)
+		return
+	}
 	id_, err := mq.DB.Insert(data.Topic, doc)
 	if err != nil {
 		mq.Send(id, MQData{
@@ -65,6 +83,7 @@ func (mq *MQ) handledbInsertCollection(id string, data MQData) {
 			RequestId: data.RequestId,
 			Topic:     data.Topic,
 			Error:     err.Error(),
+			Code:      errorCode(err),
 			Payload:   "",
 		})
 		return
@@ -88,6 +107,7 @@ func (mq *MQ) handledbRemoveCollection(id string, data MQData) {
 			RequestId: data.RequestId,
 			Topic:     data.Topic,
 			Error:     err.Error(),
+			Code:      errorCode(err),
 			Payload:   "",
 		})
 		return
@@ -103,8 +123,15 @@ func (mq *MQ) handledbRemoveCollection(id string, data MQData) {
 }
 func (mq *MQ) handledbUpdateCollection(id string, data MQData) {
 	doc := db.Document{}
-	json.Unmarshal([]byte(data.Payload), &doc)
-	id_ := doc["_id"].(string)
+	if err := json.Unmarshal([]byte(data.Payload), &doc); err != nil {
+		mq.dbInvalid(id, data, err)
+		return
+	}
+	id_, ok := doc["_id"].(string)
+	if !ok || id_ == "" {
+		mq.dbInvalid(id, data, errors.New("document without _id"))
+		return
+	}
 	err := mq.DB.Update(data.Topic, id_, doc)
 	if err != nil {
 		mq.Send(id, MQData{
@@ -113,6 +140,7 @@ func (mq *MQ) handledbUpdateCollection(id string, data MQData) {
 			RequestId: data.RequestId,
 			Topic:     data.Topic,
 			Error:     err.Error(),
+			Code:      errorCode(err),
 			Payload:   "",
 		})
 		return
@@ -129,7 +157,10 @@ func (mq *MQ) handledbUpdateCollection(id string, data MQData) {
 }
 func (mq *MQ) handledbFilterCollection(id string, data MQData) {
 	query := map[string]interface{}{}
-	json.Unmarshal([]byte(data.Payload), &query)
+	if err := json.Unmarshal([]byte(data.Payload), &query); err != nil {
+		mq.dbInvalid(id, data, err)
+		return
+	}
 	results, err := mq.DB.FindWithQuery(data.Topic, query)
 	if err != nil {
 		mq.Send(id, MQData{
@@ -138,6 +169,7 @@ func (mq *MQ) handledbFilterCollection(id string, data MQData) {
 			RequestId: data.RequestId,
 			Topic:     data.Topic,
 			Error:     err.Error(),
+			Code:      errorCode(err),
 			Payload:   "",
 		})
 		return
@@ -154,8 +186,6 @@ func (mq *MQ) handledbFilterCollection(id string, data MQData) {
 
 }
 func (mq *MQ) handledbListCollection(id string, data MQData) {
-	query := map[string]interface{}{}
-	json.Unmarshal([]byte(data.Payload), &query)
 	results, err := mq.DB.FindAll(data.Topic)
 	if err != nil {
 		mq.Send(id, MQData{
@@ -164,6 +194,7 @@ func (mq *MQ) handledbListCollection(id string, data MQData) {
 			RequestId: data.RequestId,
 			Topic:     data.Topic,
 			Error:     err.Error(),
+			Code:      errorCode(err),
 			Payload:   "",
 		})
 		return
@@ -189,6 +220,7 @@ func (mq *MQ) handledbFindOneCollection(id string, data MQData) {
 			RequestId: data.RequestId,
 			Topic:     data.Topic,
 			Error:     err.Error(),
+			Code:      errorCode(err),
 			Payload:   "",
 		})
 		return
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"mq/utils"
+	"testing"
+)
+
+func TestDBRejectsInvalidPayload(t *testing.T) {
+	mq := startBroker(t, utils.MQConfig{Username: "root", Password: "pw"})
+	c, _ := login(t, mq, "root", "pw", nil)
+	cases := []struct {
+		cmd, payload string
+	}{
+		{"DB_CI", "{"},
+		{"DB_CI", "null"},
+		{"DB_CU", "not json"},
+		{"DB_CU", `{"name":"x"}`},
+		{"DB_CU", `{"_id":42}`},
+		{"DB_CF", "[1,2]"},
+	}
+	for _, tc := range cases {
+		c.send(t, MQData{Cmd: tc.cmd, Topic: "users", Payload: tc.payload, RequestId: "r1"})
+		res := c.next(t)
+		// um panic viraria ERR internal pelo recoverFrame
+		if res.Cmd != tc.cmd || res.RequestId != "r1" || res.Code != CodeInvalidRequest {
+			t.Fatalf("%s %s: reply = %+v", tc.cmd, tc.payload, res)
+		}
+	}
+
+	// a conexão segue de pé e um documento válido passa
+	c.send(t, MQData{Cmd: "DB_CI", Topic: "users", Payload: `{"name":"x"}`, RequestId: "r2"})
+	if res := c.next(t); res.Cmd != "DB_CI" || res.Error != "" || res.Payload == "" {
+		t.Fatalf("insert = %+v", res)
+	}
+}
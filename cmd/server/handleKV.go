@@ -5,17 +5,21 @@ import (
 	"strings"
 )
 
-func (mq *MQ) handleGet(id string, data MQData) {
-	key := data.Topic
-	bucket := "store"
-	if strings.Contains(data.Topic, ":") {
-		bucket = strings.Split(data.Topic, ":")[0]
-		key = strings.Split(data.Topic, ":")[1]
-		if bucket == "" {
-			bucket = "store"
-		}
+// splitKey separa "bucket:key"; sem bucket usa o bucket "store".
+func splitKey(topic string) (string, string) {
+	if !strings.Contains(topic, ":") {
+		return "store", topic
+	}
+	parts := strings.Split(topic, ":")
+	if parts[0] == "" {
+		return "store", parts[1]
 	}
-	str, err := mq.DB.BGet(bucket, key)
+	return parts[0], parts[1]
+}
+
+func (mq *MQ) handleGet(id string, data MQData) {
+	bucket, key := splitKey(data.Topic)
+	str, err := mq.kvGet(bucket, key)
 	if err != nil {
 		mq.Send(id, MQData{
 			Cmd:       "GET",
@@ -23,6 +27,7 @@ func (mq *MQ) handleGet(id string, data MQData) {
 			RequestId: data.RequestId,
 			Topic:     data.Topic,
 			Error:     err.Error(),
+			Code:      errorCode(err),
 		})
 		return
 	}
@@ -37,16 +42,8 @@ func (mq *MQ) handleGet(id string, data MQData) {
 }
 
 func (mq *MQ) handleSet(id string, data MQData) {
-	key := data.Topic
-	bucket := "store"
-	if strings.Contains(data.Topic, ":") {
-		bucket = strings.Split(data.Topic, ":")[0]
-		key = strings.Split(data.Topic, ":")[1]
-		if bucket == "" {
-			bucket = "store"
-		}
-	}
-	err := mq.DB.BSet(bucket, key, data.Payload)
+	bucket, key := splitKey(data.Topic)
+	err := mq.kvSet(bucket, key, data.Payload)
 	if err != nil {
 		mq.Send(id, MQData{
 			Cmd:       "SET",
@@ -54,6 +51,7 @@ func (mq *MQ) handleSet(id string, data MQData) {
 			RequestId: data.RequestId,
 			Topic:     data.Topic,
 			Error:     err.Error(),
+			Code:      errorCode(err),
 		})
 		return
 	}
@@ -69,16 +67,8 @@ func (mq *MQ) handleSet(id string, data MQData) {
 }
 
 func (mq *MQ) handleDel(id string, data MQData) {
-	key := data.Topic
-	bucket := "store"
-	if strings.Contains(data.Topic, ":") {
-		bucket = strings.Split(data.Topic, ":")[0]
-		key = strings.Split(data.Topic, ":")[1]
-		if bucket == "" {
-			bucket = "store"
-		}
-	}
-	err := mq.DB.BDel(bucket, key)
+	bucket, key := splitKey(data.Topic)
+	err := mq.kvDel(bucket, key)
 	if err != nil {
 		mq.Send(id, MQData{
 			Cmd:       "DEL",
@@ -86,6 +76,7 @@ func (mq *MQ) handleDel(id string, data MQData) {
 			RequestId: data.RequestId,
 			Topic:     data.Topic,
 			Error:     err.Error(),
+			Code:      errorCode(err),
 		})
 		return
 	}
@@ -100,7 +91,7 @@ func (mq *MQ) handleDel(id string, data MQData) {
 
 func (mq *MQ) handleBDel(id string, data MQData) {
 
-	err := mq.DB.BDelete(data.Topic)
+	err := mq.kvDelete(data.Topic)
 	if err != nil {
 		mq.Send(id, MQData{
 			Cmd:       "BDEL",
@@ -108,6 +99,7 @@ func (mq *MQ) handleBDel(id string, data MQData) {
 			RequestId: data.RequestId,
 			Topic:     data.Topic,
 			Error:     err.Error(),
+			Code:      errorCode(err),
 		})
 		return
 	}
@@ -122,7 +114,7 @@ func (mq *MQ) handleBDel(id string, data MQData) {
 
 func (mq *MQ) handleBAdd(id string, data MQData) {
 
-	err := mq.DB.BCreate(data.Topic)
+	err := mq.kvCreate(data.Topic)
 	if err != nil {
 		mq.Send(id, MQData{
 			Cmd:       "BADD",
@@ -130,6 +122,7 @@ func (mq *MQ) handleBAdd(id string, data MQData) {
 			RequestId: data.RequestId,
 			Topic:     data.Topic,
 			Error:     err.Error(),
+			Code:      errorCode(err),
 		})
 		return
 	}
@@ -155,9 +148,13 @@ func (mq *MQ) handleBFilterKey(id string, data MQData) {
 			bucket = "store"
 		}
 	}
-	res, err := mq.DB.BList(bucket, func(k, v []byte) bool {
-		return strings.HasPrefix(string(k), data.Payload)
-	})
+	err := mq.kvBarrier()
+	var res map[string]string
+	if err == nil {
+		res, err = mq.DB.BList(bucket, func(k, v []byte) bool {
+			return strings.HasPrefix(string(k), data.Payload)
+		})
+	}
 
 	if err != nil {
 		mq.Send(id, MQData{
@@ -166,6 +163,7 @@ func (mq *MQ) handleBFilterKey(id string, data MQData) {
 			RequestId: data.RequestId,
 			Topic:     data.Topic,
 			Error:     err.Error(),
+			Code:      errorCode(err),
 		})
 		return
 	}
@@ -192,9 +190,13 @@ func (mq *MQ) handleBFilterVal(id string, data MQData) {
 			bucket = "store"
 		}
 	}
-	res, err := mq.DB.BList(bucket, func(k, v []byte) bool {
-		return strings.HasPrefix(string(v), data.Payload)
-	})
+	err := mq.kvBarrier()
+	var res map[string]string
+	if err == nil {
+		res, err = mq.DB.BList(bucket, func(k, v []byte) bool {
+			return strings.HasPrefix(string(v), data.Payload)
+		})
+	}
 
 	if err != nil {
 		mq.Send(id, MQData{
@@ -203,6 +205,7 @@ func (mq *MQ) handleBFilterVal(id string, data MQData) {
 			RequestId: data.RequestId,
 			Topic:     data.Topic,
 			Error:     err.Error(),
+			Code:      errorCode(err),
 			Payload:   "",
 		})
 		return
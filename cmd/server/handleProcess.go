@@ -2,93 +2,174 @@ package server
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 )
 
-func (mq *MQ) handleProcess(id string, conn net.Conn) {
-	reader := bufio.NewReader(conn)
+func (mq *MQ) handleProcess(id string, conn net.Conn, reader *bufio.Reader) {
+	mq.mu.RLock()
+	info := mq.info[id]
+	mq.mu.RUnlock()
+	log := mq.connLog(id)
 	for {
 		// Lê a mensagem do cliente até encontrar uma nova linha
-		str, err := reader.ReadString('\n')
+		limits := mq.limits()
+		str, err := readLine(reader, limits.MaxLine)
+		if errors.Is(err, ErrLineTooLong) {
+			// não dá para achar o próximo frame sem ler o resto da linha
+			log.Warn("Frame acima de max_line", "max", limits.MaxLine)
+			mq.reject(id, MQData{}, err)
+			return
+		}
 		if err != nil {
-			fmt.Printf("Erro ao ler: %s\n", err.Error())
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				log.Debug("Conexão fechada")
+			} else {
+				log.Info("Erro ao ler", "err", err)
+			}
 			break
 		}
 
 		data, err := jsonToStruct(str)
 		if err != nil {
-			fmt.Printf(": %s\n", err.Error())
+			// o frame se perdeu, mas a conexão continua: quem mandou fica sabendo
+			log.Warn("Frame inválido", "err", err)
+			mq.reject(id, MQData{}, withCode(CodeInvalidRequest, err))
+			continue
+		}
+		logFrame(log, *data)
+		mq.metrics.in(data.Cmd, len(str))
+		if info != nil {
+			info.bytesIn.Add(uint64(len(str)))
+			// qualquer frame prova que o cliente está vivo
+			info.pingsOut.Store(0)
+			if data.Cmd != "PING" && data.Cmd != "PONG" {
+				info.touch()
+			}
+		}
+		if mq.throttled(info, data.Cmd, len(str)) {
+			frameLog(log, *data).Warn("Rate limit excedido", "topic", data.Topic)
+			if mq.throttle(id, info, *data) {
+				return
+			}
+			continue
+		}
+		if len(data.Payload) > limits.MaxPayload {
+			frameLog(log, *data).Warn("Payload acima de max_payload", "size", len(data.Payload), "max", limits.MaxPayload)
+			mq.reject(id, *data, ErrPayloadTooLarge)
+			continue
+		}
+
+		if mq.dispatch(id, info, log, *data) {
 			return
 		}
+	}
+}
 
-		switch data.Cmd {
-		case "SUB":
-			mq.handleSub(id, *data)
-		case "SER":
-			mq.handleService(id, *data)
-		case "RES":
-			mq.handleRes(id, *data)
-		case "PUB":
-			go mq.handlePub(*data)
-		case "REQ":
-			mq.handleReq(id, *data)
-		case "PING":
-			mq.Send(id, MQData{
-				Cmd:       "PONG",
-				ReplayId:  id,
-				RequestId: data.RequestId,
-				Topic:     data.Topic,
-				Payload:   "PONG",
-			})
-			//KV
-		case "SET":
-			mq.handleSet(id, *data)
+// dispatch roda o handler do frame; devolve true quando a conexão deve
+// fechar. Um panic no handler vira erro internal só para este frame.
+func (mq *MQ) dispatch(id string, info *connInfo, log connLogger, data MQData) bool {
+	defer mq.recoverFrame(id, data)
+	switch data.Cmd {
+	case "SUB":
+		mq.handleSub(id, data)
+	case "SER":
+		mq.handleService(id, data)
+	case "RES":
+		mq.handleRes(id, data)
+	case "PUB":
+		if mq.canPublish(id, data.Topic) {
+			go func() {
+				defer mq.recoverFrame(id, data)
+				mq.handlePub(data)
+			}()
+		} else {
+			frameLog(log, data).Warn("permission denied", "topic", data.Topic)
+		}
+	case "APUB":
+		go func() {
+			defer mq.recoverFrame(id, data)
+			mq.handleAPub(id, data)
+		}()
+	case "BATCH":
+		return mq.handleBatch(id, info, data)
+	case "SEND":
+		mq.handleSend(id, data)
+	case "REQ":
+		mq.handleReq(id, data)
+	case "STOP":
+		mq.mu.Lock()
+		if info != nil {
+			info.Clean = true
+		}
+		mq.mu.Unlock()
+		return true
+	case "A_CONNS", "A_SUBS", "A_KICK", "A_RELOAD":
+		mq.handleAdmin(id, data)
+	case "WHOAMI":
+		mq.handleWhoAmI(id, data)
+	case "PING":
+		mq.Send(id, MQData{
+			Cmd:       "PONG",
+			ReplayId:  id,
+			RequestId: data.RequestId,
+			Topic:     data.Topic,
+			Payload:   "PONG",
+		})
+	case "PONG":
+		// resposta ao PING do broker; já zerou pingsOut acima
+		//KV
+	case "SET":
+		mq.handleSet(id, data)
 
-		case "GET":
-			mq.handleGet(id, *data)
+	case "GET":
+		mq.handleGet(id, data)
 
-		case "DEL":
-			mq.handleDel(id, *data)
-		case "BDEL":
-			mq.handleBDel(id, *data)
-		case "BADD":
-			mq.handleBAdd(id, *data)
-			//NoSQL
-		case "BFV":
-			mq.handleBFilterVal(id, *data)
-		case "BFK":
-			mq.handleBFilterKey(id, *data)
-		case "DB_CC":
-			mq.handledbCreateCollection(id, *data)
-		case "DB_CD":
-			mq.handledbDeleteCollection(id, *data)
-		case "DB_CI":
-			mq.handledbInsertCollection(id, *data)
-		case "DB_CG":
-			mq.handledbFindOneCollection(id, *data)
-		case "DB_CR":
-			mq.handledbRemoveCollection(id, *data)
-		case "DB_CF":
-			mq.handledbFilterCollection(id, *data)
-		case "DB_CU":
-			mq.handledbUpdateCollection(id, *data)
-		case "DB_CL":
-			mq.handledbListCollection(id, *data)
+	case "DEL":
+		mq.handleDel(id, data)
+	case "BDEL":
+		mq.handleBDel(id, data)
+	case "BADD":
+		mq.handleBAdd(id, data)
+		//NoSQL
+	case "BFV":
+		mq.handleBFilterVal(id, data)
+	case "BFK":
+		mq.handleBFilterKey(id, data)
+	case "DB_CC":
+		mq.handledbCreateCollection(id, data)
+	case "DB_CD":
+		mq.handledbDeleteCollection(id, data)
+	case "DB_CI":
+		mq.handledbInsertCollection(id, data)
+	case "DB_CG":
+		mq.handledbFindOneCollection(id, data)
+	case "DB_CR":
+		mq.handledbRemoveCollection(id, data)
+	case "DB_CF":
+		mq.handledbFilterCollection(id, data)
+	case "DB_CU":
+		mq.handledbUpdateCollection(id, data)
+	case "DB_CL":
+		mq.handledbListCollection(id, data)
 
-			//////////Script
-		case "S_ADD":
-			mq.handleScriptJsAdd(id, *data)
-		case "S_DEL":
-			mq.handleScriptJsDel(id, *data)
-		case "S_ENV":
-			mq.handleScriptJsAdd(id, *data)
-		case "S_JS":
-			mq.handleScriptJsAdd(id, *data)
-		case "S_RUN":
-			mq.handleScriptJsAdd(id, *data)
-		case "S_STOP":
-			mq.handleScriptJsAdd(id, *data)
-		}
+		//////////Script
+	case "S_ADD":
+		mq.handleScriptJsAdd(id, data)
+	case "S_DEL":
+		mq.handleScriptJsDel(id, data)
+	case "S_ENV":
+		mq.handleScriptJsAdd(id, data)
+	case "S_JS":
+		mq.handleScriptJsAdd(id, data)
+	case "S_RUN":
+		mq.handleScriptJsAdd(id, data)
+	case "S_STOP":
+		mq.handleScriptJsAdd(id, data)
+	default:
+		mq.reject(id, data, withCode(CodeInvalidRequest, fmt.Errorf("unknown command %q", data.Cmd)))
 	}
+	return false
 }
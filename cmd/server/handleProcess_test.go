@@ -0,0 +1,32 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDispatchUnknownCommand(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	mq := &MQ{
+		clients: map[string]net.Conn{"c1": server},
+		info:    map[string]*connInfo{},
+		metrics: newMetrics(),
+	}
+	go mq.dispatch("c1", nil, connLogger{}, MQData{Cmd: "NOPE", RequestId: "r1"})
+	line, err := bufio.NewReader(client).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := jsonToStruct(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Cmd != "ERR" || data.RequestId != "r1" || data.Code != CodeInvalidRequest {
+		t.Fatalf("reply = %+v", data)
+	}
+}
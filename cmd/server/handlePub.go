@@ -2,10 +2,16 @@ package server
 
 import (
 	"regexp"
+	"strings"
 )
 
 func replaceWildcards(s string) string {
-	return regexp.MustCompile(`\\\.\\\*`).ReplaceAllString(s, ".*")
+	s = regexp.MustCompile(`\\\.\\\*`).ReplaceAllString(s, ".*")
+	// o curinga também pode abrir o padrão: "*.status" ou só "*"
+	if strings.HasPrefix(s, `^\*`) {
+		s = "^.*" + s[len(`^\*`):]
+	}
+	return s
 }
 
 func RegexpString(text string) (*regexp.Regexp, error) {
@@ -14,15 +20,54 @@ func RegexpString(text string) (*regexp.Regexp, error) {
 	return regexp.Compile(regexPattern)
 
 }
+
+// validPublishTopic recusa tópicos vazios ou com curinga.
+func validPublishTopic(topic string) bool {
+	return topic != "" && !strings.Contains(topic, "*")
+}
+
+// De onde veio uma publicação.
+const (
+	originLocal = ""
+	originRoute = "route" // outro nó do cluster
+	originLeaf  = "leaf"  // broker central, pelo leaf
+)
+
 func (mq *MQ) handlePub(data MQData) {
+	mq.publish(data, originLocal)
+}
 
-	for topic, _ := range mq.subs {
+// publish entrega aos inscritos. Cada rota recebe no máximo uma cópia, o
+// que veio de uma rota só vai para os inscritos locais e só o que nasceu
+// aqui é exportado pelo leaf.
+func (mq *MQ) publish(data MQData, origin string) {
+	mq.mu.RLock()
+	leaf := mq.leaf
+	subs := make(map[string][]string, len(mq.subs))
+	for topic, ids := range mq.subs {
+		subs[topic] = ids
+	}
+	mq.mu.RUnlock()
+	if origin == originLocal && leaf != nil {
+		mq.leafExport(leaf, data)
+	}
+
+	deliveries := 0
+	routes := map[string]bool{}
+	for topic, ids := range subs {
 		re, err := RegexpString(topic)
 		if err != nil {
 			continue
 		}
 		if re.MatchString(data.Topic) {
-			for _, sub := range mq.subs[topic] {
+			for _, sub := range ids {
+				if isRoute(sub) {
+					if origin == originRoute || routes[sub] {
+						continue
+					}
+					routes[sub] = true
+				}
+				deliveries++
 				mq.Send(sub, MQData{
 					Cmd:      "PUB",
 					Topic:    data.Topic,
@@ -35,5 +80,40 @@ func (mq *MQ) handlePub(data MQData) {
 		}
 
 	}
+	mq.metrics.published(deliveries)
+}
 
+// handleAPub publica como handlePub, mas confirma ao remetente (cmd APUB)
+// com "ok" ou com o erro que impediu a publicação.
+func (mq *MQ) handleAPub(id string, data MQData) {
+	if !validPublishTopic(data.Topic) {
+		mq.Send(id, MQData{
+			Cmd:       "APUB",
+			ReplayId:  id,
+			RequestId: data.RequestId,
+			Topic:     data.Topic,
+			Error:     "invalid publish topic",
+			Code:      CodeInvalidRequest,
+		})
+		return
+	}
+	if !mq.canPublish(id, data.Topic) {
+		mq.Send(id, MQData{
+			Cmd:       "APUB",
+			ReplayId:  id,
+			RequestId: data.RequestId,
+			Topic:     data.Topic,
+			Error:     "permission denied",
+			Code:      CodePermissionDenied,
+		})
+		return
+	}
+	mq.handlePub(data)
+	mq.Send(id, MQData{
+		Cmd:       "APUB",
+		ReplayId:  id,
+		RequestId: data.RequestId,
+		Topic:     data.Topic,
+		Payload:   "ok",
+	})
 }
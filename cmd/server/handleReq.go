@@ -1,31 +1,60 @@
 package server
 
 func (mq *MQ) handleReq(id string, data MQData) {
+	if !mq.canPublish(id, data.Topic) {
+		mq.Send(id, MQData{
+			Cmd:       "RES",
+			RequestId: data.RequestId,
+			Topic:     data.Topic,
+			Error:     "permission denied",
+			Code:      CodePermissionDenied,
+		})
+		return
+	}
 
-	if mq.services[data.Topic] != "" {
-		req := mq.services[data.Topic]
-		if req == "self" {
-			go mq.serviceself[data.Topic](data, func(err string, payload string) {
-				mq.handleRes(id, MQData{
-					Cmd:       "RES",
-					Topic:     data.Topic,
-					Payload:   payload,
-					FromId:    id,
-					Error:     err,
-					RequestId: data.RequestId,
-					ReplayId:  id,
-				})
-			})
+	mq.mu.RLock()
+	req := mq.services[data.Topic]
+	fn := mq.serviceself[data.Topic]
+	mq.mu.RUnlock()
 
-		} else {
-			mq.Send(mq.services[data.Topic], MQData{
-				Cmd:       "REQ",
-				ReplayId:  id,
-				RequestId: data.RequestId,
-				Topic:     data.Topic,
-				Payload:   data.Payload,
-			})
+	if req == "" {
+		mq.metrics.noResponder()
+		mq.Send(id, MQData{
+			Cmd:       "RES",
+			ReplayId:  id,
+			RequestId: data.RequestId,
+			Topic:     data.Topic,
+			Error:     ErrNoResponders.Error(),
+			Code:      CodeNoResponders,
+		})
+		return
+	}
+	mq.metrics.requestStarted(id, data.RequestId, req, data.Topic, requestTimeoutOf(data))
+	if req == "self" {
+		res := MQData{
+			Cmd:       "RES",
+			Topic:     data.Topic,
+			FromId:    id,
+			RequestId: data.RequestId,
+			ReplayId:  id,
 		}
+		go func() {
+			defer mq.recoverHandler(id, data, "self", res)
+			fn(data, func(err string, payload string) {
+				res := res
+				res.Error, res.Payload = err, payload
+				mq.handleRes("self", res)
+			})
+		}()
 
+	} else {
+		mq.Send(req, MQData{
+			Cmd:       "REQ",
+			ReplayId:  id,
+			RequestId: data.RequestId,
+			Topic:     data.Topic,
+			Payload:   data.Payload,
+			Headers:   data.Headers,
+		})
 	}
 }
@@ -1,19 +1,43 @@
 package server
 
+// handleRes entrega a resposta de id a quem fez o request. O ReplayId vem
+// de quem responde, então só vale se o REQ foi mesmo entregue a id.
 func (mq *MQ) handleRes(id string, data MQData) {
+	if !mq.metrics.requestDone(data.ReplayId, data.RequestId, id) {
+		frameLog(mq.connLog(id), data).Warn("RES sem request pendente", "replayId", data.ReplayId)
+		return
+	}
+	if route, replay, ok := splitReplay(data.ReplayId); ok {
+		mq.Send(route, MQData{
+			Cmd:       "RES",
+			ReplayId:  replay,
+			Error:     data.Error,
+			Code:      data.Code,
+			RequestId: data.RequestId,
+			Topic:     data.Topic,
+			Payload:   data.Payload,
+		})
+		return
+	}
 
 	if data.ReplayId == "self" {
-		mq.chrequest[data.RequestId] <- MQResponse{
-			Payload: data.Payload,
-			Error:   data.Error,
+		mq.mu.RLock()
+		ch := mq.chrequest[data.RequestId]
+		mq.mu.RUnlock()
+		if ch != nil {
+			select {
+			case ch <- MQResponse{Payload: data.Payload, Error: data.Error, Code: data.Code}:
+			default:
+			}
 		}
 		return
 	}
-	if mq.clients[data.ReplayId] != nil {
+	if mq.conn(data.ReplayId) != nil {
 		mq.Send(data.ReplayId, MQData{
 			Cmd:       "RES",
 			ReplayId:  id,
 			Error:     data.Error,
+			Code:      data.Code,
 			RequestId: data.RequestId,
 			Topic:     data.Topic,
 			Payload:   data.Payload,
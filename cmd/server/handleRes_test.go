@@ -0,0 +1,34 @@
+package server
+
+import (
+	"mq/utils"
+	"testing"
+	"time"
+)
+
+func TestResOnlyFromResponder(t *testing.T) {
+	mq := startBroker(t, utils.MQConfig{Username: "root", Password: "pw"})
+	requester, cnn := login(t, mq, "root", "pw", nil)
+	svc, _ := login(t, mq, "root", "pw", nil)
+	intruder, _ := login(t, mq, "root", "pw", nil)
+	svc.send(t, MQData{Cmd: "SER", Topic: "svc"})
+	svc.expect(t, "OK", "svc")
+
+	requester.send(t, MQData{Cmd: "REQ", Topic: "svc", RequestId: "r1", Payload: "hi"})
+	req := svc.expect(t, "REQ", "svc")
+	if req.ReplayId != cnn.Payload {
+		t.Fatalf("REQ replay = %q, want requester %q", req.ReplayId, cnn.Payload)
+	}
+
+	// quem não recebeu o REQ não consegue responder no lugar do serviço
+	intruder.send(t, MQData{Cmd: "RES", Topic: "svc", ReplayId: req.ReplayId, RequestId: "r1", Payload: "forged"})
+	requester.silent(t, 200*time.Millisecond, "RES", "svc")
+
+	svc.send(t, MQData{Cmd: "RES", Topic: "svc", ReplayId: req.ReplayId, RequestId: "r1", Payload: "ok"})
+	if res := requester.expect(t, "RES", "svc"); res.Payload != "ok" || res.RequestId != "r1" {
+		t.Fatalf("RES = %+v", res)
+	}
+	// a resposta já foi entregue: uma segunda não passa
+	svc.send(t, MQData{Cmd: "RES", Topic: "svc", ReplayId: req.ReplayId, RequestId: "r1", Payload: "again"})
+	requester.silent(t, 200*time.Millisecond, "RES", "svc")
+}
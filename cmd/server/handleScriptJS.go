@@ -2,7 +2,6 @@ package server
 
 import (
 	"encoding/json"
-	"fmt"
 	"mq/cmd/runtime"
 )
 
@@ -23,7 +22,6 @@ func (mq *MQ) handleStrictjsStatus(id string, data MQData) {
 }
 
 func (mq *MQ) handleScriptJsAdd(id string, data MQData) {
-	fmt.Println(data)
 	jsData := runtime.RuntimeData{}
 	json.Unmarshal([]byte(data.Payload), &jsData)
 
@@ -39,5 +37,4 @@ func (mq *MQ) handleScriptJsAdd(id string, data MQData) {
 }
 
 func (mq *MQ) handleScriptJsDel(id string, data MQData) {
-	fmt.Println(data)
 }
@@ -0,0 +1,50 @@
+package server
+
+import "fmt"
+
+// handleSend entrega uma mensagem (cmd MSG) a uma única conexão, indicada em
+// ToId. A permissão é a mesma de publicar no tópico.
+func (mq *MQ) handleSend(id string, data MQData) {
+	if !mq.canPublish(id, data.Topic) {
+		mq.Send(id, MQData{
+			Cmd:       "SEND",
+			ReplayId:  id,
+			RequestId: data.RequestId,
+			Topic:     data.Topic,
+			Error:     "permission denied",
+			Code:      CodePermissionDenied,
+		})
+		return
+	}
+	err := mq.sendTo(id, data.ToId, data.Topic, data.Payload)
+	if err != nil {
+		mq.Send(id, MQData{
+			Cmd:       "SEND",
+			ReplayId:  id,
+			RequestId: data.RequestId,
+			Topic:     data.Topic,
+			Error:     err.Error(),
+			Code:      errorCode(err),
+		})
+		return
+	}
+	mq.Send(id, MQData{
+		Cmd:       "SEND",
+		ReplayId:  id,
+		RequestId: data.RequestId,
+		Topic:     data.Topic,
+		Payload:   "ok",
+	})
+}
+
+func (mq *MQ) sendTo(fromId, toId, topic, payload string) error {
+	if mq.conn(toId) == nil {
+		return withCode(CodeNotFound, fmt.Errorf("connection %s not found", toId))
+	}
+	return mq.Send(toId, MQData{
+		Cmd:     "MSG",
+		Topic:   topic,
+		Payload: payload,
+		FromId:  fromId,
+	})
+}
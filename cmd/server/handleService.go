@@ -1,7 +1,30 @@
 package server
 
 func (mq *MQ) handleService(id string, data MQData) {
+	if !mq.canSubscribe(id, data.Topic) {
+		mq.Send(id, MQData{
+			Cmd:     "OK",
+			Topic:   data.Topic,
+			Error:   "permission denied",
+			Code:    CodePermissionDenied,
+			Payload: "",
+		})
+		return
+	}
+	mq.mu.Lock()
+	if max := mq.config.Limits.MaxServices; max > 0 && mq.services[data.Topic] != id && mq.servicesOf(id) >= max {
+		mq.mu.Unlock()
+		mq.Send(id, MQData{
+			Cmd:   "OK",
+			Topic: data.Topic,
+			Error: ErrMaxServices.Error(),
+			Code:  CodeLimitExceeded,
+		})
+		return
+	}
 	mq.services[data.Topic] = id
+	mq.interestChanged()
+	mq.mu.Unlock()
 	mq.Send(id, MQData{
 		Cmd:     "OK",
 		Topic:   data.Topic,
@@ -1,10 +1,51 @@
 package server
 
 func (mq *MQ) handleSub(id string, data MQData) {
+	if !mq.canSubscribe(id, data.Topic) {
+		mq.Send(id, MQData{
+			Cmd:     "OK",
+			Topic:   data.Topic,
+			Error:   "permission denied",
+			Code:    CodePermissionDenied,
+			Payload: "",
+		})
+		return
+	}
+	mq.mu.Lock()
+	if max := mq.config.Limits.MaxSubscriptions; max > 0 && mq.subscriptions(id) >= max {
+		mq.mu.Unlock()
+		mq.Send(id, MQData{
+			Cmd:   "OK",
+			Topic: data.Topic,
+			Error: ErrMaxSubscriptions.Error(),
+			Code:  CodeLimitExceeded,
+		})
+		return
+	}
 	mq.subs[data.Topic] = append(mq.subs[data.Topic], id)
+	mq.interestChanged()
+	mq.mu.Unlock()
 	mq.Send(id, MQData{
 		Cmd:     "OK",
 		Topic:   data.Topic,
 		Payload: "",
 	})
 }
+
+// removeSub tira uma inscrição do id.
+func (mq *MQ) removeSub(id, topic string) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	kept := []string{}
+	for _, sub := range mq.subs[topic] {
+		if sub != id {
+			kept = append(kept, sub)
+		}
+	}
+	if len(kept) == 0 {
+		delete(mq.subs, topic)
+	} else {
+		mq.subs[topic] = kept
+	}
+	mq.interestChanged()
+}
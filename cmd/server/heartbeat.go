@@ -0,0 +1,85 @@
+package server
+
+import (
+	"errors"
+	"mq/utils"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultAuthTimeout = 5 * time.Second
+	defaultMaxPingsOut = 2
+)
+
+var (
+	ErrAuthTimeout = errors.New("auth timeout")
+	ErrIdleTimeout = errors.New("idle timeout")
+)
+
+// touch marca atividade do cliente (qualquer frame menos PING/PONG).
+func (info *connInfo) touch() {
+	info.lastActive.Store(time.Now().UnixNano())
+}
+
+func (info *connInfo) idle() time.Duration {
+	return time.Since(time.Unix(0, info.lastActive.Load()))
+}
+
+// heartbeatPeriod é de quanto em quanto o heartbeat acorda; 0 quando ping
+// e idle estão desligados.
+func heartbeatPeriod(config utils.MQConfig) time.Duration {
+	if config.PingInterval > 0 {
+		return config.PingInterval
+	}
+	if config.IdleTimeout > 0 {
+		return max(config.IdleTimeout/4, 100*time.Millisecond)
+	}
+	return 0
+}
+
+// heartbeat manda PING ao cliente a cada mq.ping_interval e derruba a
+// conexão que deixou mq.max_pings_out sem resposta (meia-aberta) ou que
+// passou de mq.idle_timeout sem mandar nada. O intervalo é lido de novo a
+// cada volta por causa do reload. Termina quando done fecha.
+func (mq *MQ) heartbeat(id string, out *outConn, info *connInfo, done <-chan struct{}) {
+	log := mq.connLog(id)
+	for {
+		period := heartbeatPeriod(mq.Config())
+		wait := period
+		if wait <= 0 {
+			// desligado: o reload pode ligar
+			wait = time.Second
+		}
+		select {
+		case <-done:
+			return
+		case <-time.After(wait):
+		}
+		if period <= 0 {
+			continue
+		}
+		config := mq.Config()
+		if config.IdleTimeout > 0 && info.idle() > config.IdleTimeout {
+			log.Info("Conexão ociosa", "timeout", config.IdleTimeout)
+			mq.reject(id, MQData{}, ErrIdleTimeout)
+			out.closeAfterFlush(time.Second)
+			return
+		}
+		if config.PingInterval <= 0 {
+			continue
+		}
+		maxOut := config.MaxPingsOut
+		if maxOut <= 0 {
+			maxOut = defaultMaxPingsOut
+		}
+		if int(info.pingsOut.Load()) >= maxOut {
+			log.Warn("Sem resposta aos pings", "pings", maxOut)
+			out.Close()
+			return
+		}
+		info.pingsOut.Add(1)
+		mq.Send(id, MQData{Cmd: "PING", RequestId: uuid.New().String()})
+	}
+}
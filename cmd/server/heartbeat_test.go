@@ -0,0 +1,83 @@
+package server
+
+import (
+	"io"
+	"mq/utils"
+	"testing"
+	"time"
+)
+
+// closed espera o broker fechar a conexão e devolve quanto demorou.
+func (c *rawClient) closed(t *testing.T) time.Duration {
+	t.Helper()
+	start := time.Now()
+	c.SetReadDeadline(start.Add(5 * time.Second))
+	if _, err := io.ReadAll(c.r); err != nil {
+		t.Fatalf("connection still open: %v", err)
+	}
+	return time.Since(start)
+}
+
+func TestAuthTimeout(t *testing.T) {
+	mq := startBroker(t, utils.MQConfig{Username: "root", Password: "pw", AuthTimeout: 200 * time.Millisecond})
+	c := dialRaw(t, mq)
+	if res := c.next(t); res.Cmd != "ER_AUH" || res.Code != CodeTimeout {
+		t.Fatalf("reply = %+v", res)
+	}
+	c.closed(t)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	mq := startBroker(t, utils.MQConfig{Username: "root", Password: "pw", IdleTimeout: 400 * time.Millisecond})
+	idle, _ := login(t, mq, "root", "pw", nil)
+	start := time.Now()
+	busy, _ := login(t, mq, "root", "pw", nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 8; i++ {
+			busy.Write([]byte(`{"cmd":"PUB","topic":"a.b"}` + "\n"))
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+
+	// PING do cliente não conta como atividade
+	for i := 0; i < 3; i++ {
+		idle.send(t, MQData{Cmd: "PING"})
+		time.Sleep(100 * time.Millisecond)
+	}
+	res := idle.expect(t, "ERR", "")
+	if res.Code != CodeTimeout || res.Error != ErrIdleTimeout.Error() {
+		t.Fatalf("idle reply = %+v", res)
+	}
+	if elapsed := time.Since(start); elapsed >= 700*time.Millisecond {
+		t.Fatalf("idle connection closed after %v: client PINGs kept it alive", elapsed)
+	}
+	idle.closed(t)
+	<-done
+	busy.send(t, MQData{Cmd: "PING", RequestId: "still-there"})
+	if res := busy.expect(t, "PONG", ""); res.RequestId != "still-there" {
+		t.Fatalf("busy connection = %+v", res)
+	}
+}
+
+func TestUnansweredPings(t *testing.T) {
+	mq := startBroker(t, utils.MQConfig{Username: "root", Password: "pw",
+		PingInterval: 100 * time.Millisecond, MaxPingsOut: 2})
+	deaf, _ := login(t, mq, "root", "pw", nil)
+	alive, _ := login(t, mq, "root", "pw", nil)
+
+	// quem responde os PINGs segue conectado; quem não responde cai
+	for i := 0; i < 5; i++ {
+		ping := alive.expect(t, "PING", "")
+		alive.send(t, MQData{Cmd: "PONG", RequestId: ping.RequestId})
+	}
+	if d := deaf.closed(t); d > 2*time.Second {
+		t.Fatalf("closed after %v", d)
+	}
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+	if len(mq.clients) != 1 {
+		t.Fatalf("clients = %d, want only the one answering pings", len(mq.clients))
+	}
+}
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mq/cmd/db"
+	"mq/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StartHTTP sobe o gateway HTTP: publicação, request, KV, coleções e
+// eventos (SSE), com os mesmos usuários e permissões do protocolo TCP, e
+// opcionalmente as métricas do Prometheus.
+func (mq *MQ) StartHTTP(config utils.HTTPConfig) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /pub/{topic}", mq.httpAuth(mq.httpLimit("PUB", mq.httpPub)))
+	mux.HandleFunc("POST /req/{topic}", mq.httpAuth(mq.httpLimit("REQ", mq.httpReq)))
+	mux.HandleFunc("GET /events", mq.httpAuth(mq.httpLimit("SUB", mq.httpEvents)))
+
+	mux.HandleFunc("GET /kv/{bucket}/{key}", mq.httpAuth(mq.httpLimit("GET", mq.httpKVGet)))
+	mux.HandleFunc("PUT /kv/{bucket}/{key}", mq.httpAuth(mq.httpLimit("SET", mq.httpKVSet)))
+	mux.HandleFunc("DELETE /kv/{bucket}/{key}", mq.httpAuth(mq.httpLimit("DEL", mq.httpKVDel)))
+
+	mux.HandleFunc("GET /db/{collection}", mq.httpAuth(mq.httpLimit("DB_CL", mq.httpDBList)))
+	mux.HandleFunc("POST /db/{collection}", mq.httpAuth(mq.httpLimit("DB_CI", mq.httpDBInsert)))
+	mux.HandleFunc("POST /db/{collection}/query", mq.httpAuth(mq.httpLimit("DB_CF", mq.httpDBQuery)))
+	mux.HandleFunc("GET /db/{collection}/{id}", mq.httpAuth(mq.httpLimit("DB_CG", mq.httpDBGet)))
+	mux.HandleFunc("PUT /db/{collection}/{id}", mq.httpAuth(mq.httpLimit("DB_CU", mq.httpDBUpdate)))
+	mux.HandleFunc("DELETE /db/{collection}/{id}", mq.httpAuth(mq.httpLimit("DB_CR", mq.httpDBDelete)))
+
+	if config.Metrics.Enabled {
+		path := config.Metrics.Path
+		if path == "" {
+			path = "/metrics"
+		}
+		handler := http.HandlerFunc(mq.httpMetrics)
+		if config.Metrics.Auth {
+			handler = mq.httpAuth(func(w http.ResponseWriter, r *http.Request, user utils.User) {
+				mq.httpMetrics(w, r)
+			})
+		}
+		mux.Handle("GET "+path, handler)
+	}
+
+	mq.record(func(running *utils.ServerConfig) { running.HTTP = config })
+	addr := config.Host + ":" + strconv.Itoa(config.Port)
+	server := &http.Server{Addr: addr, Handler: mux}
+	slog.Info("Servidor HTTP iniciado", "addr", addr)
+	mq.track(server)
+	var err error
+	if config.UseHTTPS {
+		// o certificado vem do certLoader, que o reload pode trocar
+		server.TLSConfig, err = mq.tlsConfig("http", config.CertFile, config.KeyFile)
+		if err != nil {
+			return err
+		}
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+type httpHandler func(w http.ResponseWriter, r *http.Request, user utils.User)
+
+// httpAuth aceita basic auth com os mesmos usuários do AUTH, ou o token
+// do usuário em "Authorization: Bearer" ou ?token= (o EventSource do
+// navegador não manda cabeçalhos).
+func (mq *MQ) httpAuth(next httpHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if ok {
+			if user, valid := mq.checkPassword(username, password); valid {
+				next(w, r, user)
+				return
+			}
+		}
+		token := r.URL.Query().Get("token")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			token = bearer
+		}
+		if user, ok := mq.userByToken(token); ok {
+			next(w, r, user)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="mq"`)
+		writeHTTP(w, http.StatusUnauthorized, MQResponse{Error: "Invalid auth", Code: CodePermissionDenied})
+	}
+}
+
+// httpLimit aplica ao request o rate limit do usuário, como se fosse um
+// frame cmd com o corpo de payload. O corpo é lido aqui para contar os bytes.
+func (mq *MQ) httpLimit(cmd string, next httpHandler) httpHandler {
+	return func(w http.ResponseWriter, r *http.Request, user utils.User) {
+		body, ok := mq.readBody(w, r)
+		if !ok {
+			return
+		}
+		if mq.userThrottled(user.Username, nil, cmd, len(body)) {
+			mq.metrics.throttle(cmd)
+			writeHTTP(w, http.StatusTooManyRequests, MQResponse{Error: ErrThrottled.Error(), Code: CodeLimitExceeded})
+			return
+		}
+		r.Body = io.NopCloser(strings.NewReader(body))
+		next(w, r, user)
+	}
+}
+
+func writeHTTP(w http.ResponseWriter, status int, res MQResponse) {
+	writeJSON(w, status, res)
+}
+
+// writeJSON é usado nas rotas de coleção, que devolvem os documentos direto.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeHTTPError usa o mesmo code do protocolo TCP no corpo e no status.
+func writeHTTPError(w http.ResponseWriter, err error) {
+	code := errorCode(err)
+	writeHTTP(w, httpStatus(code), MQResponse{Error: err.Error(), Code: code})
+}
+
+func httpStatus(code string) int {
+	switch code {
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodePermissionDenied:
+		return http.StatusForbidden
+	case CodeInvalidRequest:
+		return http.StatusBadRequest
+	case CodeTimeout:
+		return http.StatusGatewayTimeout
+	case CodeNoResponders:
+		return http.StatusServiceUnavailable
+	case CodeLimitExceeded:
+		return http.StatusTooManyRequests
+	case CodeConflict:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// readBody lê o corpo até mq.limits.max_payload.
+func (mq *MQ) readBody(w http.ResponseWriter, r *http.Request) (string, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(mq.limits().MaxPayload)))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeHTTP(w, http.StatusRequestEntityTooLarge, MQResponse{Error: ErrPayloadTooLarge.Error(), Code: CodeLimitExceeded})
+		return "", false
+	}
+	if err != nil {
+		writeHTTP(w, http.StatusBadRequest, MQResponse{Error: err.Error(), Code: CodeInvalidRequest})
+		return "", false
+	}
+	return string(body), true
+}
+
+func (mq *MQ) httpPub(w http.ResponseWriter, r *http.Request, user utils.User) {
+	topic := r.PathValue("topic")
+	if !validPublishTopic(topic) {
+		writeHTTP(w, http.StatusBadRequest, MQResponse{Error: "invalid publish topic", Code: CodeInvalidRequest})
+		return
+	}
+	if !userCanPublish(user, topic) {
+		writeHTTPError(w, ErrPermissionDenied)
+		return
+	}
+	payload, ok := mq.readBody(w, r)
+	if !ok {
+		return
+	}
+	mq.handlePub(MQData{Cmd: "PUB", Topic: topic, Payload: payload})
+	writeHTTP(w, http.StatusOK, MQResponse{Payload: "ok"})
+}
+
+// httpReq faz um request a um serviço; ?timeout= aceita "2s", "500ms" etc.
+func (mq *MQ) httpReq(w http.ResponseWriter, r *http.Request, user utils.User) {
+	topic := r.PathValue("topic")
+	if !userCanPublish(user, topic) {
+		writeHTTPError(w, ErrPermissionDenied)
+		return
+	}
+	timeout := 5 * time.Second
+	if str := r.URL.Query().Get("timeout"); str != "" {
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			writeHTTP(w, http.StatusBadRequest, MQResponse{Error: "invalid timeout: " + err.Error(), Code: CodeInvalidRequest})
+			return
+		}
+		timeout = d
+	}
+	payload, ok := mq.readBody(w, r)
+	if !ok {
+		return
+	}
+	res, err := mq.request(topic, payload, timeout)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	// erro do próprio serviço é 502; os do broker (no_responders...) seguem o code
+	if res.Error != "" {
+		status := http.StatusBadGateway
+		if res.Code != "" && res.Code != CodeInternal {
+			status = httpStatus(res.Code)
+		}
+		writeHTTP(w, status, res)
+		return
+	}
+	writeHTTP(w, http.StatusOK, res)
+}
+
+func (mq *MQ) httpKVGet(w http.ResponseWriter, r *http.Request, user utils.User) {
+	str, err := mq.kvGet(r.PathValue("bucket"), r.PathValue("key"))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeHTTP(w, http.StatusOK, MQResponse{Payload: str})
+}
+
+func (mq *MQ) httpKVSet(w http.ResponseWriter, r *http.Request, user utils.User) {
+	value, ok := mq.readBody(w, r)
+	if !ok {
+		return
+	}
+	err := mq.kvSet(r.PathValue("bucket"), r.PathValue("key"), value)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeHTTP(w, http.StatusOK, MQResponse{Payload: "ok"})
+}
+
+func (mq *MQ) httpKVDel(w http.ResponseWriter, r *http.Request, user utils.User) {
+	err := mq.kvDel(r.PathValue("bucket"), r.PathValue("key"))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeHTTP(w, http.StatusOK, MQResponse{Payload: "ok"})
+}
+
+func (mq *MQ) httpDBList(w http.ResponseWriter, r *http.Request, user utils.User) {
+	results, err := mq.DB.FindAll(r.PathValue("collection"))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+func (mq *MQ) httpDBInsert(w http.ResponseWriter, r *http.Request, user utils.User) {
+	doc := db.Document{}
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		writeHTTP(w, http.StatusBadRequest, MQResponse{Error: err.Error(), Code: CodeInvalidRequest})
+		return
+	}
+	id, err := mq.DB.Insert(r.PathValue("collection"), doc)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeHTTP(w, http.StatusCreated, MQResponse{Payload: id})
+}
+
+func (mq *MQ) httpDBQuery(w http.ResponseWriter, r *http.Request, user utils.User) {
+	query := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		writeHTTP(w, http.StatusBadRequest, MQResponse{Error: err.Error(), Code: CodeInvalidRequest})
+		return
+	}
+	results, err := mq.DB.FindWithQuery(r.PathValue("collection"), query)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+func (mq *MQ) httpDBGet(w http.ResponseWriter, r *http.Request, user utils.User) {
+	doc, err := mq.DB.FindOne(r.PathValue("collection"), r.PathValue("id"))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, doc)
+}
+
+func (mq *MQ) httpDBUpdate(w http.ResponseWriter, r *http.Request, user utils.User) {
+	doc := db.Document{}
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		writeHTTP(w, http.StatusBadRequest, MQResponse{Error: err.Error(), Code: CodeInvalidRequest})
+		return
+	}
+	id := r.PathValue("id")
+	err := mq.DB.Update(r.PathValue("collection"), id, doc)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeHTTP(w, http.StatusOK, MQResponse{Payload: id})
+}
+
+func (mq *MQ) httpDBDelete(w http.ResponseWriter, r *http.Request, user utils.User) {
+	id := r.PathValue("id")
+	err := mq.DB.Delete(r.PathValue("collection"), id)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeHTTP(w, http.StatusOK, MQResponse{Payload: id})
+}
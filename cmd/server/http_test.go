@@ -0,0 +1,35 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"mq/cmd/db"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPErrorUsesCode(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{db.ErrBucketNotFound, http.StatusNotFound, CodeNotFound},
+		{db.ErrDocumentNotFound, http.StatusNotFound, CodeNotFound},
+		{db.ErrStoreBucket, http.StatusBadRequest, CodeInvalidRequest},
+		{ErrPermissionDenied, http.StatusForbidden, CodePermissionDenied},
+		{ErrThrottled, http.StatusTooManyRequests, CodeLimitExceeded},
+		{withCode(CodeTimeout, errors.New("slow")), http.StatusGatewayTimeout, CodeTimeout},
+		{errors.New("boom"), http.StatusInternalServerError, CodeInternal},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		writeHTTPError(w, c.err)
+		res := MQResponse{}
+		json.NewDecoder(w.Body).Decode(&res)
+		if w.Code != c.status || res.Code != c.code || res.Error != c.err.Error() {
+			t.Errorf("%v: status %d code %q error %q", c.err, w.Code, res.Code, res.Error)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	client "mq/client/go"
+	"mq/utils"
+	"sync"
+	"time"
+)
+
+// leafThrottleWait é a primeira espera depois de um THROTTLE do central;
+// dobra a cada recusa até ReconnectWait.
+const leafThrottleWait = 100 * time.Millisecond
+
+// leaf é a ligação com o broker central, feita com o client/go.
+type leaf struct {
+	config   utils.LeafConfig
+	queue    chan MQData // exportações esperando o uplink
+	mu       sync.Mutex
+	upstream *client.MQ
+	done     chan struct{} // fechado por stop
+}
+
+// StartLeaf conecta ao broker central e fica exportando as publicações
+// locais. O tráfego local não depende do uplink: enquanto ele está fora as
+// exportações ficam no buffer (até Buffer mensagens) e são reenviadas na
+// ordem, pelo menos uma vez, quando ele volta.
+func (mq *MQ) StartLeaf(config utils.LeafConfig) error {
+	mq.record(func(running *utils.ServerConfig) { running.Leaf = config })
+	if _, err := client.ParseMQURL(config.URL); err != nil {
+		return err
+	}
+	if config.Buffer <= 0 {
+		config.Buffer = 1000
+	}
+	if config.ReconnectWait <= 0 {
+		config.ReconnectWait = 2 * time.Second
+	}
+	if config.RequestTimeout <= 0 {
+		config.RequestTimeout = 5 * time.Second
+	}
+	if config.Name == "" {
+		config.Name = "leaf"
+	}
+	l := &leaf{config: config, queue: make(chan MQData, config.Buffer), done: make(chan struct{})}
+	mq.mu.Lock()
+	mq.leaf = l
+	mq.mu.Unlock()
+
+	for _, topic := range config.Services {
+		mq.Service(topic, l.request(topic))
+	}
+	upstream := l.dial()
+	if upstream == nil {
+		return nil
+	}
+	for _, topic := range config.Import {
+		upstream.Subscribe(topic, func(msg client.MQData) {
+			mq.publish(MQData{
+				Cmd:     "PUB",
+				Topic:   msg.Topic,
+				Payload: msg.Payload,
+			}, originLeaf)
+		})
+	}
+	slog.Info("Leaf conectado ao broker central", "name", config.Name)
+	l.forward()
+	return nil
+}
+
+// dial tenta até conectar (ou até o leaf parar); depois disso o próprio
+// cliente reconecta.
+func (l *leaf) dial() *client.MQ {
+	for {
+		upstream, err := client.Dial(l.config.URL,
+			client.WithName(l.config.Name),
+			client.WithReconnect(l.config.ReconnectWait, 0))
+		if err == nil {
+			l.mu.Lock()
+			l.upstream = upstream
+			l.mu.Unlock()
+			return upstream
+		}
+		slog.Warn("Leaf: erro ao conectar", "err", err)
+		if !l.wait() {
+			return nil
+		}
+	}
+}
+
+// wait espera ReconnectWait; devolve false se o leaf parou.
+func (l *leaf) wait() bool {
+	return l.sleep(l.config.ReconnectWait)
+}
+
+func (l *leaf) sleep(d time.Duration) bool {
+	select {
+	case <-l.done:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// stop encerra o uplink; o que ainda estiver no buffer é perdido.
+func (l *leaf) stop() {
+	close(l.done)
+	l.mu.Lock()
+	upstream := l.upstream
+	l.mu.Unlock()
+	if upstream != nil {
+		upstream.Stop()
+	}
+}
+
+func (mq *MQ) leafExport(l *leaf, data MQData) {
+	if len(l.config.Export) == 0 || sysTopic(data.Topic) || !allowed(l.config.Export, data.Topic) {
+		return
+	}
+	select {
+	case l.queue <- data:
+	default:
+		mq.metrics.leafDrop()
+	}
+}
+
+// forward publica as exportações com confirmação; sem ela a mensagem é
+// tentada de novo. Recusas do central (permissão, tópico, tamanho) são
+// descartadas; o THROTTLE só adia, com espera crescente.
+func (l *leaf) forward() {
+	for {
+		var data MQData
+		select {
+		case data = <-l.queue:
+		case <-l.done:
+			return
+		}
+		backoff := min(leafThrottleWait, l.config.ReconnectWait)
+		for {
+			err := l.upstream.PublishSync(data.Topic, data.Payload, l.config.RequestTimeout)
+			var rejected *client.RejectedError
+			switch {
+			case err == nil:
+			case errors.Is(err, client.ErrPayloadTooLarge), errors.Is(err, client.ErrLineTooLong):
+				// não cabe no central: tentar de novo não muda nada
+				slog.Warn("Leaf: mensagem acima do limite do central", "topic", data.Topic, "size", len(data.Payload), "err", err)
+			case errors.Is(err, client.ErrLimitExceeded):
+				if !l.sleep(backoff) {
+					return
+				}
+				backoff = min(backoff*2, l.config.ReconnectWait)
+				continue
+			case errors.As(err, &rejected):
+				slog.Warn("Leaf: central recusou a publicação", "topic", data.Topic, "reason", rejected.Reason)
+			default:
+				if !l.wait() {
+					return
+				}
+				continue
+			}
+			break
+		}
+	}
+}
+
+// request atende localmente um serviço do central.
+func (l *leaf) request(topic string) func(data MQData, replay func(err string, payload string)) {
+	return func(data MQData, replay func(err string, payload string)) {
+		l.mu.Lock()
+		upstream := l.upstream
+		l.mu.Unlock()
+		if upstream == nil {
+			replay("uplink down", "")
+			return
+		}
+		str, err := upstream.Request(topic, data.Payload, l.config.RequestTimeout)
+		if err != nil {
+			replay(err.Error(), "")
+			return
+		}
+		replay("", str)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"mq/utils"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLeafForwardLimits(t *testing.T) {
+	central := startBroker(t, utils.MQConfig{
+		Username:  "root",
+		Password:  "pw",
+		Limits:    utils.Limits{MaxPayload: 16},
+		RateLimit: utils.RateLimitConfig{Conn: utils.RateLimit{Messages: 2}},
+	})
+	got := make(chan string, 10)
+	central.Subscribe("x.*", func(data MQData) { got <- data.Payload })
+
+	local := startBroker(t, utils.MQConfig{})
+	go local.StartLeaf(utils.LeafConfig{
+		URL:           "mq://root:pw@127.0.0.1:" + strconv.Itoa(central.config.Port),
+		Export:        []string{"x.*"},
+		ReconnectWait: 500 * time.Millisecond,
+	})
+	eventually(t, "leaf uplink", func() bool {
+		local.mu.RLock()
+		l := local.leaf
+		local.mu.RUnlock()
+		if l == nil {
+			return false
+		}
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.upstream != nil
+	})
+
+	// a grande é descartada de vez; as outras passam do THROTTLE, na ordem
+	local.Publish("x.a", strings.Repeat("b", 32))
+	for i := 0; i < 5; i++ {
+		local.Publish("x.a", strconv.Itoa(i))
+	}
+	for i := 0; i < 5; i++ {
+		select {
+		case payload := <-got:
+			if payload != strconv.Itoa(i) {
+				t.Fatalf("got %q, want %d", payload, i)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("message %d not forwarded", i)
+		}
+	}
+	if central.metrics.throttled["APUB"] == 0 {
+		t.Fatal("central never throttled the leaf")
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"mq/utils"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultMaxPayload = 1 << 20
+	defaultMaxLine    = 4 << 20
+	defaultMaxBatch   = 1000
+)
+
+// Erros dos limites fixos; a mensagem vai no campo error da resposta.
+var (
+	ErrPayloadTooLarge  = errors.New("payload too large")
+	ErrLineTooLong      = errors.New("frame too long")
+	ErrMaxConnections   = errors.New("maximum connections exceeded")
+	ErrMaxSubscriptions = errors.New("maximum subscriptions exceeded")
+	ErrMaxServices      = errors.New("maximum services exceeded")
+	ErrMaxBatchItems    = errors.New("maximum batch items exceeded")
+)
+
+// limits devolve mq.limits com os padrões aplicados.
+func (mq *MQ) limits() utils.Limits {
+	limits := mq.Config().Limits
+	if limits.MaxPayload == 0 {
+		limits.MaxPayload = defaultMaxPayload
+	}
+	if limits.MaxLine == 0 {
+		limits.MaxLine = defaultMaxLine
+		if limits.MaxLine < limits.MaxPayload {
+			limits.MaxLine = limits.MaxPayload * 2
+		}
+	}
+	if limits.MaxBatchItems == 0 {
+		limits.MaxBatchItems = defaultMaxBatch
+	}
+	return limits
+}
+
+// limitHeaders são os limites anunciados no CNN.
+func limitHeaders(limits utils.Limits, headers map[string]string) {
+	headers["max-payload"] = strconv.Itoa(limits.MaxPayload)
+	headers["max-line"] = strconv.Itoa(limits.MaxLine)
+	headers["max-batch-items"] = strconv.Itoa(limits.MaxBatchItems)
+	if limits.MaxSubscriptions > 0 {
+		headers["max-subscriptions"] = strconv.Itoa(limits.MaxSubscriptions)
+	}
+	if limits.MaxServices > 0 {
+		headers["max-services"] = strconv.Itoa(limits.MaxServices)
+	}
+}
+
+// readLine lê um frame até o '\n' sem guardar mais que max bytes.
+func readLine(reader *bufio.Reader, max int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > max {
+			return "", ErrLineTooLong
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+	}
+}
+
+// full diz se já há max_connections clientes (as rotas não contam).
+func (mq *MQ) full() bool {
+	max := mq.Config().Limits.MaxConnections
+	if max <= 0 {
+		return false
+	}
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+	n := 0
+	for id := range mq.clients {
+		if !strings.HasPrefix(id, routePrefix) {
+			n++
+		}
+	}
+	return n >= max
+}
+
+// subscriptions conta as inscrições do id. Chamado com o lock.
+func (mq *MQ) subscriptions(id string) int {
+	n := 0
+	for _, ids := range mq.subs {
+		for _, sub := range ids {
+			if sub == id {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// servicesOf conta os serviços do id. Chamado com o lock.
+func (mq *MQ) servicesOf(id string) int {
+	n := 0
+	for _, owner := range mq.services {
+		if owner == id {
+			n++
+		}
+	}
+	return n
+}
+
+// reject responde a um frame recusado antes do handler; quem espera a
+// resposta pelo requestId recebe o erro.
+func (mq *MQ) reject(id string, data MQData, err error) {
+	res := MQData{
+		Cmd:       "ERR",
+		ReplayId:  id,
+		RequestId: data.RequestId,
+		Topic:     data.Topic,
+		Error:     err.Error(),
+		Code:      errorCode(err),
+	}
+	if data.Cmd != "" {
+		res.Headers = map[string]string{"cmd": data.Cmd}
+	}
+	mq.Send(id, res)
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"bytes"
+	"mq/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPBodyOverMaxPayload(t *testing.T) {
+	mq := &MQ{config: utils.MQConfig{Limits: utils.Limits{MaxPayload: 4}}}
+	reached := false
+	handler := mq.httpLimit("PUB", func(w http.ResponseWriter, r *http.Request, user utils.User) {
+		reached = true
+	})
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/pub/a", strings.NewReader("12345")), utils.User{})
+	if reached || w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, reached %v", w.Code, reached)
+	}
+	if !strings.Contains(w.Body.String(), `"code":"limit_exceeded"`) {
+		t.Fatalf("body %s", w.Body.String())
+	}
+}
+
+func TestWSMessageOverMaxLine(t *testing.T) {
+	ws, client := wsPipe(t)
+	ws.max = 8
+	writeAsync(client,
+		clientFrame(false, wsOpText, []byte("12345")),
+		clientFrame(true, wsOpCont, []byte("6789")),
+	)
+	if _, err := ws.readMessage(); err != ErrLineTooLong {
+		t.Fatalf("err = %v, want ErrLineTooLong", err)
+	}
+
+	ws, client = wsPipe(t)
+	ws.max = 8
+	writeAsync(client, clientFrame(true, wsOpText, bytes.Repeat([]byte("x"), 9)))
+	if _, err := ws.readMessage(); err != ErrLineTooLong {
+		t.Fatalf("err = %v, want ErrLineTooLong", err)
+	}
+}
+
+func TestMQTTMaxSubscriptions(t *testing.T) {
+	mq := &MQ{
+		config: utils.MQConfig{Limits: utils.Limits{MaxSubscriptions: 2}},
+		subs:   map[string][]string{},
+	}
+	mc, _ := mqttPipe(t)
+	for _, filter := range []string{"a/b", "a/c"} {
+		if !mq.addMQTTSub("m1", mc, mqttToTopic(filter), filter, 0) {
+			t.Fatalf("%s refused below the limit", filter)
+		}
+	}
+	if mq.addMQTTSub("m1", mc, "a.d", "a/d", 0) {
+		t.Fatal("subscription over max_subscriptions accepted")
+	}
+	// renovar um filtro já assinado não conta como nova assinatura
+	if !mq.addMQTTSub("m1", mc, "a.b", "a/b", 1) {
+		t.Fatal("resubscribe refused at the limit")
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"mq/utils"
+	"slices"
+)
+
+// connLogger guarda só os atributos de uma conexão; cada linha vai para o
+// slog.Default() do momento, então o reload dos logs (nível, formato,
+// arquivo) vale também para as conexões já abertas.
+type connLogger struct {
+	attrs []any
+}
+
+// connLog é o logger de uma conexão: toda linha leva o id e o usuário.
+func (mq *MQ) connLog(id string) connLogger {
+	user := ""
+	mq.mu.RLock()
+	if info := mq.info[id]; info != nil {
+		user = info.User
+	}
+	mq.mu.RUnlock()
+	return connLogger{attrs: []any{"conn", id, "user", user}}
+}
+
+func (l connLogger) With(args ...any) connLogger {
+	return connLogger{attrs: append(slices.Clip(l.attrs), args...)}
+}
+
+func (l connLogger) Enabled(level slog.Level) bool {
+	return slog.Default().Enabled(context.Background(), level)
+}
+
+func (l connLogger) log(level slog.Level, msg string, args []any) {
+	logger := slog.Default()
+	if !logger.Enabled(context.Background(), level) {
+		return
+	}
+	logger.Log(context.Background(), level, msg, append(slices.Clip(l.attrs), args...)...)
+}
+
+func (l connLogger) Debug(msg string, args ...any) { l.log(slog.LevelDebug, msg, args) }
+func (l connLogger) Info(msg string, args ...any)  { l.log(slog.LevelInfo, msg, args) }
+func (l connLogger) Warn(msg string, args ...any)  { l.log(slog.LevelWarn, msg, args) }
+func (l connLogger) Error(msg string, args ...any) { l.log(slog.LevelError, msg, args) }
+
+// frameLog acrescenta o comando e o request id do frame.
+func frameLog(log connLogger, data MQData) connLogger {
+	return log.With("cmd", data.Cmd, "requestId", data.RequestId)
+}
+
+// logFrame registra em debug um frame recebido; o payload sai oculto,
+// a menos que logs.show_payloads esteja ligado.
+func logFrame(log connLogger, data MQData) {
+	if !log.Enabled(slog.LevelDebug) {
+		return
+	}
+	frameLog(log, data).Debug("frame", "topic", data.Topic, utils.Payload(data.Payload))
+}
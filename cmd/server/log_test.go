@@ -0,0 +1,34 @@
+package server
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestConnLogFollowsReload(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var before, after bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&before, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	mq := &MQ{info: map[string]*connInfo{"c1": {User: "root"}}}
+	log := frameLog(mq.connLog("c1"), MQData{Cmd: "PUB", RequestId: "r1"})
+	log.Debug("hidden")
+	if before.Len() != 0 {
+		t.Fatalf("debug written at info level: %s", before.String())
+	}
+
+	// o reload troca o default: a conexão aberta passa a usar o novo
+	slog.SetDefault(slog.New(slog.NewTextHandler(&after, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	log.Debug("shown", "topic", "a.b")
+	line := after.String()
+	for _, want := range []string{"msg=shown", "conn=c1", "user=root", "cmd=PUB", "requestId=r1", "topic=a.b"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("line %q lacks %s", line, want)
+		}
+	}
+	if before.Len() != 0 {
+		t.Fatalf("old handler still written: %s", before.String())
+	}
+}
@@ -0,0 +1,374 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	latencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	fanoutBuckets  = []float64{0, 1, 2, 5, 10, 25, 50, 100, 250, 1000}
+)
+
+// pendingTTL é quanto espera resposta, antes de contar como timeout nas
+// métricas, um request que não mandou o header timeout.
+const pendingTTL = time.Minute
+
+// commands são os cmd que viram label; o resto conta como "unknown" para o
+// cliente não criar séries à vontade.
+var commands = map[string]bool{}
+
+func init() {
+	for _, cmd := range strings.Fields(`AUTH CNN OK ERR ER_AUH THROTTLE GOAWAY STOP
+		PING PONG WHOAMI PUB APUB MSG SUB SER REQ RES SEND BATCH
+		SET GET DEL BDEL BADD BFK BFV
+		DB_CC DB_CD DB_CI DB_CG DB_CR DB_CF DB_CU DB_CL
+		S_ADD S_DEL S_ENV S_JS S_RUN S_STOP
+		A_CONNS A_SUBS A_KICK A_RELOAD
+		R_CONNECT R_INFO R_SUB R_UNSUB R_SER R_UNSER`) {
+		commands[cmd] = true
+	}
+}
+
+func cmdLabel(cmd string) string {
+	if commands[cmd] {
+		return cmd
+	}
+	return "unknown"
+}
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, le := range h.buckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+type pendingReq struct {
+	from  string // conexão (ou "self", ou rota/id) que espera a resposta
+	to    string // conexão (ou "self", ou rota) que recebeu o REQ
+	topic string
+	start time.Time
+	timer *time.Timer // conta o timeout quando o prazo do request passa
+}
+
+// metrics guarda os contadores expostos em /metrics.
+type metrics struct {
+	mu           sync.Mutex
+	msgsIn       map[string]uint64 // por cmd
+	bytesIn      map[string]uint64
+	msgsOut      map[string]uint64
+	bytesOut     map[string]uint64
+	fanout       *histogram
+	latency      map[string]*histogram // por tópico de serviço
+	pending      map[string]pendingReq // id de quem pediu + requestId
+	noResponders uint64
+	timeouts     uint64
+	slowDrops    uint64
+	slowConns    uint64            // vezes que a fila de saída de um cliente encheu
+	throttled    map[string]uint64 // frames recusados pelo rate limit, por cmd
+	panics       map[string]uint64 // handlers que entraram em panic, por cmd
+	leafDrops    uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		msgsIn:    make(map[string]uint64),
+		bytesIn:   make(map[string]uint64),
+		msgsOut:   make(map[string]uint64),
+		bytesOut:  make(map[string]uint64),
+		fanout:    newHistogram(fanoutBuckets),
+		latency:   make(map[string]*histogram),
+		pending:   make(map[string]pendingReq),
+		throttled: make(map[string]uint64),
+		panics:    make(map[string]uint64),
+	}
+}
+
+func (m *metrics) in(cmd string, n int) {
+	cmd = cmdLabel(cmd)
+	m.mu.Lock()
+	m.msgsIn[cmd]++
+	m.bytesIn[cmd] += uint64(n)
+	m.mu.Unlock()
+}
+
+func (m *metrics) out(cmd string, n int) {
+	cmd = cmdLabel(cmd)
+	m.mu.Lock()
+	m.msgsOut[cmd]++
+	m.bytesOut[cmd] += uint64(n)
+	m.mu.Unlock()
+}
+
+func (m *metrics) published(deliveries int) {
+	m.mu.Lock()
+	m.fanout.observe(float64(deliveries))
+	m.mu.Unlock()
+}
+
+func (m *metrics) noResponder() {
+	m.mu.Lock()
+	m.noResponders++
+	m.mu.Unlock()
+}
+
+func (m *metrics) slowDrop() {
+	m.mu.Lock()
+	m.slowDrops++
+	m.mu.Unlock()
+}
+
+func (m *metrics) throttle(cmd string) {
+	cmd = cmdLabel(cmd)
+	m.mu.Lock()
+	m.throttled[cmd]++
+	m.mu.Unlock()
+}
+
+func (m *metrics) panic(cmd string) {
+	cmd = cmdLabel(cmd)
+	m.mu.Lock()
+	m.panics[cmd]++
+	m.mu.Unlock()
+}
+
+func (m *metrics) slowConsumer() {
+	m.mu.Lock()
+	m.slowConns++
+	m.mu.Unlock()
+}
+
+func (m *metrics) leafDrop() {
+	m.mu.Lock()
+	m.leafDrops++
+	m.mu.Unlock()
+}
+
+// requestStarted registra o request até a resposta ou até timeout (o
+// prazo que o cliente mandou, senão pendingTTL). Só to pode responder.
+func (m *metrics) requestStarted(from, reqId, to, topic string, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = pendingTTL
+	}
+	key := from + "/" + reqId
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old, ok := m.pending[key]; ok {
+		old.timer.Stop()
+	}
+	req := pendingReq{from: from, to: to, topic: topic, start: time.Now()}
+	req.timer = time.AfterFunc(timeout, func() { m.requestTimeout(from, reqId) })
+	m.pending[key] = req
+}
+
+// requestDone fecha o request quando a resposta vem de quem recebeu o REQ.
+// Devolve false para uma resposta que não foi pedida a to, ou que chegou
+// depois do timeout: ela não deve ser entregue.
+func (m *metrics) requestDone(from, reqId, to string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := from + "/" + reqId
+	req, ok := m.pending[key]
+	if !ok || req.to != to {
+		return false
+	}
+	delete(m.pending, key)
+	req.timer.Stop()
+	h := m.latency[req.topic]
+	if h == nil {
+		h = newHistogram(latencyBuckets)
+		m.latency[req.topic] = h
+	}
+	h.observe(time.Since(req.start).Seconds())
+	return true
+}
+
+// requestTimeout conta o timeout uma vez, venha do timer ou de quem pediu.
+func (m *metrics) requestTimeout(from, reqId string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := from + "/" + reqId
+	req, ok := m.pending[key]
+	if !ok {
+		return
+	}
+	delete(m.pending, key)
+	req.timer.Stop()
+	m.timeouts++
+}
+
+// waiting devolve quem espera cada request em andamento.
+func (m *metrics) waiting() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	froms := make([]string, 0, len(m.pending))
+	for _, req := range m.pending {
+		froms = append(froms, req.from)
+	}
+	return froms
+}
+
+// requestTimeoutOf lê o header timeout do REQ (duração Go, ex. "5s").
+func requestTimeoutOf(data MQData) time.Duration {
+	d, err := time.ParseDuration(data.Headers["timeout"])
+	if err != nil || d <= 0 {
+		return 0
+	}
+	return d
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeMetric(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeByLabel(w io.Writer, name, label string, values map[string]uint64) {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, label, labelEscaper.Replace(k), values[k])
+	}
+}
+
+func writeHistogram(w io.Writer, name, labels string, h *histogram) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, le := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%g\"} %d\n", name, labels, sep, le, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n%s_count%s %d\n", name, labels, h.sum, name, labels, h.count)
+}
+
+// httpMetrics escreve as métricas no formato texto do Prometheus.
+func (mq *MQ) httpMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	// as rotas entram com kind="route", fora da conta dos clientes
+	conns := map[string]uint64{"client": 0, "mqtt": 0, "sse": 0, "route": 0}
+	mq.mu.RLock()
+	for _, info := range mq.info {
+		conns[info.Kind]++
+	}
+	detached := len(mq.sessions)
+	mq.mu.RUnlock()
+	writeMetric(w, "mq_connections", "Conexões ativas por tipo.", "gauge")
+	writeByLabel(w, "mq_connections", "kind", conns)
+	writeMetric(w, "mq_sessions_detached", "Sessões desconectadas esperando retomada.", "gauge")
+	fmt.Fprintf(w, "mq_sessions_detached %d\n", detached)
+
+	m := mq.metrics
+	m.mu.Lock()
+	writeMetric(w, "mq_messages_in_total", "Frames recebidos por comando.", "counter")
+	writeByLabel(w, "mq_messages_in_total", "cmd", m.msgsIn)
+	writeMetric(w, "mq_bytes_in_total", "Bytes recebidos por comando.", "counter")
+	writeByLabel(w, "mq_bytes_in_total", "cmd", m.bytesIn)
+	writeMetric(w, "mq_messages_out_total", "Frames enviados por comando.", "counter")
+	writeByLabel(w, "mq_messages_out_total", "cmd", m.msgsOut)
+	writeMetric(w, "mq_bytes_out_total", "Bytes enviados por comando.", "counter")
+	writeByLabel(w, "mq_bytes_out_total", "cmd", m.bytesOut)
+
+	writeMetric(w, "mq_publish_fanout", "Entregas por publicação.", "histogram")
+	writeHistogram(w, "mq_publish_fanout", "", m.fanout)
+
+	writeMetric(w, "mq_request_duration_seconds", "Latência dos requests por tópico de serviço.", "histogram")
+	topics := make([]string, 0, len(m.latency))
+	for topic := range m.latency {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		writeHistogram(w, "mq_request_duration_seconds", `topic="`+labelEscaper.Replace(topic)+`"`, m.latency[topic])
+	}
+
+	writeMetric(w, "mq_requests_pending", "Requests esperando resposta.", "gauge")
+	fmt.Fprintf(w, "mq_requests_pending %d\n", len(m.pending))
+	writeMetric(w, "mq_request_no_responders_total", "Requests sem serviço registrado.", "counter")
+	fmt.Fprintf(w, "mq_request_no_responders_total %d\n", m.noResponders)
+	writeMetric(w, "mq_request_timeouts_total", "Requests sem resposta a tempo.", "counter")
+	fmt.Fprintf(w, "mq_request_timeouts_total %d\n", m.timeouts)
+	writeMetric(w, "mq_slow_consumer_drops_total", "Mensagens descartadas por consumidor lento.", "counter")
+	fmt.Fprintf(w, "mq_slow_consumer_drops_total %d\n", m.slowDrops)
+	writeMetric(w, "mq_slow_consumers_total", "Vezes que a fila de saída de um cliente encheu.", "counter")
+	fmt.Fprintf(w, "mq_slow_consumers_total %d\n", m.slowConns)
+	writeMetric(w, "mq_throttled_total", "Frames recusados pelo rate limit por comando.", "counter")
+	writeByLabel(w, "mq_throttled_total", "cmd", m.throttled)
+	writeMetric(w, "mq_handler_panics_total", "Handlers que entraram em panic por comando.", "counter")
+	writeByLabel(w, "mq_handler_panics_total", "cmd", m.panics)
+	writeMetric(w, "mq_leaf_drops_total", "Mensagens não exportadas pelo leaf por buffer cheio.", "counter")
+	fmt.Fprintf(w, "mq_leaf_drops_total %d\n", m.leafDrops)
+	m.mu.Unlock()
+
+	if mq.DB != nil {
+		s := mq.DB.Stats()
+		writeMetric(w, "mq_db_read_tx_total", "Transações de leitura do bbolt.", "counter")
+		fmt.Fprintf(w, "mq_db_read_tx_total %d\n", s.TxN)
+		writeMetric(w, "mq_db_open_read_tx", "Transações de leitura abertas.", "gauge")
+		fmt.Fprintf(w, "mq_db_open_read_tx %d\n", s.OpenTxN)
+		writeMetric(w, "mq_db_writes_total", "Escritas de página do bbolt.", "counter")
+		fmt.Fprintf(w, "mq_db_writes_total %d\n", s.WriteN)
+		writeMetric(w, "mq_db_pages_total", "Páginas alocadas pelo bbolt.", "counter")
+		fmt.Fprintf(w, "mq_db_pages_total %d\n", s.PageCount)
+		writeMetric(w, "mq_db_page_alloc_bytes_total", "Bytes alocados em páginas.", "counter")
+		fmt.Fprintf(w, "mq_db_page_alloc_bytes_total %d\n", s.PageAlloc)
+		writeMetric(w, "mq_db_free_pages", "Páginas livres.", "gauge")
+		fmt.Fprintf(w, "mq_db_free_pages %d\n", s.FreePageN)
+		writeMetric(w, "mq_db_pending_pages", "Páginas a liberar.", "gauge")
+		fmt.Fprintf(w, "mq_db_pending_pages %d\n", s.PendingPageN)
+		writeMetric(w, "mq_db_free_alloc_bytes", "Bytes em páginas livres.", "gauge")
+		fmt.Fprintf(w, "mq_db_free_alloc_bytes %d\n", s.FreeAlloc)
+		writeMetric(w, "mq_db_freelist_inuse_bytes", "Bytes usados pela freelist.", "gauge")
+		fmt.Fprintf(w, "mq_db_freelist_inuse_bytes %d\n", s.FreelistInuse)
+		writeMetric(w, "mq_db_size_bytes", "Tamanho do arquivo do banco.", "gauge")
+		fmt.Fprintf(w, "mq_db_size_bytes %d\n", s.Size)
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	writeMetric(w, "go_info", "Versão do Go.", "gauge")
+	fmt.Fprintf(w, "go_info{version=\"%s\"} 1\n", runtime.Version())
+	writeMetric(w, "go_goroutines", "Goroutines.", "gauge")
+	fmt.Fprintf(w, "go_goroutines %d\n", runtime.NumGoroutine())
+	writeMetric(w, "go_memstats_alloc_bytes", "Bytes alocados no heap.", "gauge")
+	fmt.Fprintf(w, "go_memstats_alloc_bytes %d\n", mem.HeapAlloc)
+	writeMetric(w, "go_memstats_heap_inuse_bytes", "Bytes em spans do heap em uso.", "gauge")
+	fmt.Fprintf(w, "go_memstats_heap_inuse_bytes %d\n", mem.HeapInuse)
+	writeMetric(w, "go_memstats_sys_bytes", "Bytes obtidos do sistema.", "gauge")
+	fmt.Fprintf(w, "go_memstats_sys_bytes %d\n", mem.Sys)
+	writeMetric(w, "go_memstats_mallocs_total", "Alocações.", "counter")
+	fmt.Fprintf(w, "go_memstats_mallocs_total %d\n", mem.Mallocs)
+	writeMetric(w, "go_gc_cycles_total", "Ciclos de GC.", "counter")
+	fmt.Fprintf(w, "go_gc_cycles_total %d\n", mem.NumGC)
+	writeMetric(w, "go_gc_pause_seconds_total", "Tempo total de pausa do GC.", "counter")
+	fmt.Fprintf(w, "go_gc_pause_seconds_total %g\n", float64(mem.PauseTotalNs)/1e9)
+}
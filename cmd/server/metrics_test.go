@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsUnknownCmd(t *testing.T) {
+	m := newMetrics()
+	for i := 0; i < 100; i++ {
+		m.in("RANDOM"+string(rune('A'+i%26)), 1)
+	}
+	m.in("PUB", 1)
+	if len(m.msgsIn) != 2 || m.msgsIn["unknown"] != 100 || m.msgsIn["PUB"] != 1 {
+		t.Fatalf("msgsIn = %v", m.msgsIn)
+	}
+}
+
+func TestMetricsRequestExpiresAtOwnTimeout(t *testing.T) {
+	m := newMetrics()
+	m.requestStarted("c1", "r1", "s1", "svc", 20*time.Millisecond)
+	m.requestStarted("c1", "r2", "s1", "svc", time.Hour)
+	m.requestDone("c1", "r2", "s1")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		m.mu.Lock()
+		pending, timeouts := len(m.pending), m.timeouts
+		m.mu.Unlock()
+		if pending == 0 && timeouts == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pending = %d, timeouts = %d", pending, timeouts)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// quem pediu também desiste: o timeout não conta duas vezes
+	m.requestTimeout("c1", "r1")
+	if m.timeouts != 1 {
+		t.Fatalf("timeouts = %d after second report", m.timeouts)
+	}
+}
+
+func TestRequestTimeoutHeader(t *testing.T) {
+	cases := map[string]time.Duration{"": 0, "5s": 5 * time.Second, "bogus": 0, "-1s": 0}
+	for header, want := range cases {
+		data := MQData{Headers: map[string]string{"timeout": header}}
+		if got := requestTimeoutOf(data); got != want {
+			t.Errorf("timeout %q = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestMetricsConnectionsByKind(t *testing.T) {
+	mq := &MQ{
+		info: map[string]*connInfo{
+			"c1":       {Kind: "client"},
+			"c2":       {Kind: "client"},
+			"m1":       {Kind: "mqtt"},
+			"route:n2": {Kind: "route"},
+			"route:n3": {Kind: "route"},
+			"route:n4": {Kind: "route"},
+			"sse-1":    {Kind: "sse"},
+		},
+		sessions: map[string]*session{},
+		metrics:  newMetrics(),
+	}
+	w := httptest.NewRecorder()
+	mq.httpMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		`mq_connections{kind="client"} 2`,
+		`mq_connections{kind="mqtt"} 1`,
+		`mq_connections{kind="route"} 3`,
+		`mq_connections{kind="sse"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("missing %q in\n%s", line, body)
+		}
+	}
+}
@@ -1,23 +1,32 @@
 package server
 
 import (
+	"bufio"
 	"encoding/json"
-	"fmt"
+	"io"
+	"log/slog"
 	"mq/cmd/db"
+	"mq/cmd/raft"
 	"mq/utils"
 	"net"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type MQData struct {
-	Cmd       string `json:"cmd"`
-	Topic     string `json:"topic"`
-	Payload   string `json:"payload"`
-	RequestId string `json:"requestId"`
-	ReplayId  string `json:"replayId"`
-	Regtopic  string `json:"regtopic"`
-	Error     string `json:"error"`
-	FromId    string `json:"fromId"`
+	Cmd       string            `json:"cmd"`
+	Topic     string            `json:"topic"`
+	Payload   string            `json:"payload"`
+	RequestId string            `json:"requestId"`
+	ReplayId  string            `json:"replayId"`
+	Regtopic  string            `json:"regtopic"`
+	Error     string            `json:"error"`
+	Code      string            `json:"code,omitempty"` // um dos Code*, junto com Error
+	FromId    string            `json:"fromId"`
+	ToId      string            `json:"toId,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
 }
 
 func jsonToStruct(data string) (*MQData, error) {
@@ -39,68 +48,153 @@ func structToJSON(mq MQData) (string, error) {
 type MQResponse struct {
 	Payload string `json:"payload"`
 	Error   string `json:"error"`
+	Code    string `json:"code,omitempty"`
+}
+
+// connInfo guarda o que o broker sabe de uma conexão autenticada.
+type connInfo struct {
+	User        string
+	Name        string
+	Kind        string // client (TCP e WebSocket), mqtt, sse ou route
+	WillTopic   string
+	WillPayload string
+	Token       string // token para retomar a sessão
+	Clean       bool   // true quando o cliente encerrou com STOP
+	Connected   time.Time
+	bytesIn     atomic.Uint64
+	bytesOut    atomic.Uint64
+	rate        limiter
+	pingsOut    atomic.Int32 // PINGs do broker sem resposta
+	lastActive  atomic.Int64 // unix nano do último frame além de PING/PONG
+	strikes     []time.Time  // recusas recentes do rate limit
 }
 
 type MQ struct {
-	clients     map[string]net.Conn
-	ips         map[string]string
-	services    map[string]string
-	auth        map[string]string
-	config      utils.MQConfig
-	subs        map[string][]string
-	DB          *db.NoSQL
-	subself     map[string][]func(data MQData)
-	chs         map[string]chan string     // cria um canal de string
-	chrequest   map[string]chan MQResponse // cria um canal de string
-	serviceself map[string]func(data MQData, replay func(err string, payload string))
+	mu           sync.RWMutex // protege os mapas abaixo
+	clients      map[string]net.Conn
+	ips          map[string]string
+	info         map[string]*connInfo
+	sessions     map[string]*session
+	mqttInflight map[string]*mqttSession // QoS 1 sem PUBACK de clientes MQTT que caíram
+	services     map[string]string
+	auth         map[string]string
+	users        map[string]utils.User
+	config       utils.MQConfig
+	subs         map[string][]string
+	DB           *db.NoSQL
+	subself      map[string][]func(data MQData)
+	chs          map[string]chan string     // cria um canal de string
+	chrequest    map[string]chan MQResponse // cria um canal de string
+	serviceself  map[string]func(data MQData, replay func(err string, payload string))
+	metrics      *metrics
+	cluster      utils.ClusterConfig
+	routes       map[string]*route
+	known        map[string]bool // endereços de rota já discados ou conectados
+	leaf         *leaf
+	raft         *raft.Raft             // nil sem o KV replicado
+	done         chan struct{}          // fechado pelo Shutdown
+	closers      []io.Closer            // listeners e servidores HTTP
+	running      utils.ServerConfig     // configuração em uso, comparada no reload
+	certs        map[string]*certLoader // por listener HTTPS
+	userRates    map[string]*limiter    // rate limit somado por usuário
 }
 
 func (mq *MQ) Start() error {
 
-	listener, err := net.Listen("tcp", mq.config.Broker+":"+strconv.Itoa(mq.config.Port))
+	listener, err := mq.listen(mq.config.Broker + ":" + strconv.Itoa(mq.config.Port))
 	if err != nil {
 		return err
 	}
-	defer listener.Close()
 
-	fmt.Println("Servidor TCP iniciado e ouvindo na " + mq.config.Broker + ":" + strconv.Itoa(mq.config.Port))
+	slog.Info("Servidor TCP iniciado", "addr", listener.Addr().String())
+	go mq.publishStats()
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			fmt.Printf("Erro ao aceitar conexão: %s", err.Error())
+			if mq.closing() {
+				return nil
+			}
+			slog.Error("Erro ao aceitar conexão", "err", err)
 			continue
 		}
-		reqId, err := mq.handleAuth(conn)
-		if err != nil {
+		go mq.serve(conn)
+	}
+}
+
+// serve autentica a conexão e, se der certo, atende ela até cair. Usado
+// tanto pelo listener TCP quanto pelo WebSocket.
+func (mq *MQ) serve(conn net.Conn) {
+	timeout := mq.Config().AuthTimeout
+	if timeout <= 0 {
+		timeout = defaultAuthTimeout
+	}
+	// sem AUTH no prazo o cliente recebe o ER_AUH e a conexão fecha, o que
+	// faz a leitura do handleAuth falhar
+	expired := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() {
+		defer close(expired)
+		mq.send(conn, MQData{Cmd: "ER_AUH", Payload: ErrAuthTimeout.Error(), Code: errorCode(ErrAuthTimeout)})
+		conn.Close()
+	})
+	// o mesmo reader segue para o handleProcess: frames mandados logo
+	// depois do AUTH não se perdem
+	reader := bufio.NewReader(conn)
+	auth, err := mq.handleAuth(reader)
+	if !timer.Stop() {
+		<-expired
+		err = ErrAuthTimeout
+	}
+	if err == nil && mq.full() {
+		err = ErrMaxConnections
+	}
+	if err != nil {
+		slog.Warn("Autenticação recusada", "remote", conn.RemoteAddr().String(), "user", auth.Topic, "err", err)
+		if err != ErrAuthTimeout {
 			mq.send(conn, MQData{
 				Cmd:       "ER_AUH",
-				RequestId: reqId,
+				RequestId: auth.RequestId,
 				Payload:   err.Error(),
+				Code:      errorCode(err),
 			})
-			conn.Close()
-		} else {
-			go mq.handleConnection(conn, reqId)
 		}
-
+		conn.Close()
+	} else {
+		mq.handleConnection(conn, reader, auth)
 	}
 }
 
-func NewMQ(config utils.MQConfig) *MQ {
-	dbNoSQL, _ := db.New(config.FileKV)
+// NewMQ abre o banco e prepara o broker; nada é ouvido até o Start.
+func NewMQ(config utils.MQConfig) (*MQ, error) {
+	dbNoSQL, err := db.New(config.FileKV)
+	if err != nil {
+		return nil, err
+	}
+	auth, users := buildUsers(config)
 	mq := MQ{
-		clients:     map[string]net.Conn{},
-		config:      config,
-		auth:        map[string]string{config.Username: config.Password},
-		subself:     make(map[string][]func(data MQData)),
-		chs:         make(map[string]chan string),
-		DB:          dbNoSQL,
-		ips:         make(map[string]string),
-		services:    make(map[string]string),
-		subs:        make(map[string][]string),
-		chrequest:   make(map[string]chan MQResponse),
-		serviceself: make(map[string]func(data MQData, replay func(err string, payload string))),
+		clients:      map[string]net.Conn{},
+		config:       config,
+		auth:         auth,
+		users:        users,
+		subself:      make(map[string][]func(data MQData)),
+		chs:          make(map[string]chan string),
+		DB:           dbNoSQL,
+		ips:          make(map[string]string),
+		info:         make(map[string]*connInfo),
+		sessions:     make(map[string]*session),
+		mqttInflight: make(map[string]*mqttSession),
+		services:     make(map[string]string),
+		subs:         make(map[string][]string),
+		chrequest:    make(map[string]chan MQResponse),
+		serviceself:  make(map[string]func(data MQData, replay func(err string, payload string))),
+		metrics:      newMetrics(),
+		routes:       make(map[string]*route),
+		known:        make(map[string]bool),
+		done:         make(chan struct{}),
+		running:      utils.ServerConfig{MQ: config},
+		certs:        make(map[string]*certLoader),
+		userRates:    make(map[string]*limiter),
 	}
 
-	return &mq
+	return &mq, nil
 }
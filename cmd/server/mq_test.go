@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"mq/utils"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// freePort reserva uma porta livre em localhost para um broker de teste.
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// waitListen espera o endereço aceitar conexões.
+func waitListen(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s not listening: %v", addr, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// eventually espera cond ficar verdadeira.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startBroker sobe um broker em localhost com o banco num diretório
+// temporário; o Shutdown fica para o fim do teste.
+func startBroker(t *testing.T, config utils.MQConfig) *MQ {
+	t.Helper()
+	config.Broker = "127.0.0.1"
+	if config.Port == 0 {
+		config.Port = freePort(t)
+	}
+	config.FileKV = filepath.Join(t.TempDir(), "kv.db")
+	mq, err := NewMQ(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go mq.Start()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		mq.Shutdown(ctx)
+	})
+	waitListen(t, "127.0.0.1:"+strconv.Itoa(config.Port))
+	return mq
+}
+
+// rawClient é uma conexão TCP crua com o broker, para testar o protocolo
+// sem passar pelo client Go.
+type rawClient struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// dialRaw conecta sem autenticar.
+func dialRaw(t *testing.T, mq *MQ) *rawClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(mq.Config().Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &rawClient{Conn: conn, r: bufio.NewReader(conn)}
+}
+
+// login conecta, manda o AUTH e devolve a conexão e o CNN.
+func login(t *testing.T, mq *MQ, user, pass string, headers map[string]string) (*rawClient, *MQData) {
+	t.Helper()
+	c := dialRaw(t, mq)
+	c.send(t, MQData{Cmd: "AUTH", Topic: user, Payload: pass, RequestId: "auth", Headers: headers})
+	cnn := c.next(t)
+	if cnn.Cmd != "CNN" {
+		t.Fatalf("auth reply = %+v", cnn)
+	}
+	return c, cnn
+}
+
+func (c *rawClient) send(t *testing.T, data MQData) {
+	t.Helper()
+	str, err := structToJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte(str + "\n")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// next lê o próximo frame, com prazo de 5s.
+func (c *rawClient) next(t *testing.T) *MQData {
+	t.Helper()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := jsonToStruct(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// expect pula frames até chegar um cmd no tópico.
+func (c *rawClient) expect(t *testing.T, cmd, topic string) *MQData {
+	t.Helper()
+	for {
+		if data := c.next(t); data.Cmd == cmd && data.Topic == topic {
+			return data
+		}
+	}
+}
+
+// silent confere que nenhum cmd no tópico chega em d.
+func (c *rawClient) silent(t *testing.T, d time.Duration, cmd, topic string) {
+	t.Helper()
+	c.SetReadDeadline(time.Now().Add(d))
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if data, _ := jsonToStruct(line); data != nil && data.Cmd == cmd && data.Topic == topic {
+			t.Fatalf("unexpected %s on %s: %+v", cmd, topic, data)
+		}
+	}
+}
+
+// subscribe inscreve e espera o OK.
+func (c *rawClient) subscribe(t *testing.T, topic string) {
+	t.Helper()
+	c.send(t, MQData{Cmd: "SUB", Topic: topic})
+	if ok := c.expect(t, "OK", topic); ok.Error != "" {
+		t.Fatalf("SUB %s: %s", topic, ok.Error)
+	}
+}
@@ -0,0 +1,701 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mq/utils"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	mqttConnect     = 1
+	mqttConnack     = 2
+	mqttPublish     = 3
+	mqttPuback      = 4
+	mqttSubscribe   = 8
+	mqttSuback      = 9
+	mqttUnsubscribe = 10
+	mqttUnsuback    = 11
+	mqttPingreq     = 12
+	mqttPingresp    = 13
+	mqttDisconnect  = 14
+
+	mqttDup         = 0x08
+	mqttMaxInflight = 1000 // acima disso o QoS 1 mais antigo deixa de ser reenviado
+
+	defaultMQTTRetry = 20 * time.Second
+)
+
+// Códigos de retorno do CONNACK (MQTT 3.1.1, 3.2.2.3) e do SUBACK.
+const (
+	mqttAccepted          = 0
+	mqttBadProtocol       = 1
+	mqttBadClientId       = 2
+	mqttUnavailable       = 3
+	mqttBadUserOrPassword = 4
+	mqttNotAuthorized     = 5
+	mqttSubscribeFailure  = 0x80
+)
+
+// StartMQTT sobe o listener MQTT 3.1.1. Tópicos MQTT "a/b/c" viram "a.b.c"
+// e os curingas "+" e "#" viram "*", então clientes MQTT e nativos veem as
+// mensagens uns dos outros. Suporta QoS 0 e 1; o PUBLISH QoS 1 sem PUBACK é
+// reenviado com DUP a cada retry_interval.
+//
+// A sessão persistente (clean session 0) é parcial: só os PUBLISH QoS 1 sem
+// PUBACK ficam guardados, por usuário e client id, durante mq.session_grace,
+// e são reenviados quando o mesmo cliente volta sem clean session. As
+// inscrições não sobrevivem à conexão, por isso o CONNACK sempre manda
+// session present 0 e o cliente se inscreve de novo.
+func (mq *MQ) StartMQTT(config utils.MQTTConfig) error {
+	mq.record(func(running *utils.ServerConfig) { running.MQTT = config })
+	listener, err := mq.listen(config.Broker + ":" + strconv.Itoa(config.Port))
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Servidor MQTT iniciado", "addr", listener.Addr().String())
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if mq.closing() {
+				return nil
+			}
+			slog.Error("Erro ao aceitar conexão MQTT", "err", err)
+			continue
+		}
+		go mq.handleMQTT(conn, config)
+	}
+}
+
+// mqttToTopic converte um tópico ou filtro MQTT para o formato nativo.
+func mqttToTopic(topic string) string {
+	topic = strings.ReplaceAll(topic, "/", ".")
+	topic = strings.ReplaceAll(topic, "+", "*")
+	return strings.ReplaceAll(topic, "#", "*")
+}
+
+func topicToMQTT(topic string) string {
+	return strings.ReplaceAll(topic, ".", "/")
+}
+
+// mqttMatch aplica a regra exata de filtros MQTT, já que o curinga nativo
+// casa mais níveis que o "+".
+func mqttMatch(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+func (mq *MQ) handleMQTT(conn net.Conn, config utils.MQTTConfig) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	// o pacote inteiro segue max_line e o payload do PUBLISH max_payload,
+	// como uma linha e um frame do protocolo nativo
+	limits := mq.limits()
+	packetType, _, body, err := readMQTTPacket(reader, limits.MaxLine)
+	if err != nil || packetType != mqttConnect {
+		return
+	}
+	connect, err := parseMQTTConnect(body)
+	if err != nil {
+		writeMQTTPacket(conn, mqttConnack, 0, []byte{0, mqttBadProtocol})
+		return
+	}
+	// sem client id não há como achar a sessão de volta (3.1.3.1)
+	if !connect.cleanSession && connect.clientId == "" {
+		writeMQTTPacket(conn, mqttConnack, 0, []byte{0, mqttBadClientId})
+		return
+	}
+	user, ok := mq.checkPassword(connect.username, connect.password)
+	if !ok {
+		writeMQTTPacket(conn, mqttConnack, 0, []byte{0, mqttBadUserOrPassword})
+		return
+	}
+	if mq.full() {
+		writeMQTTPacket(conn, mqttConnack, 0, []byte{0, mqttUnavailable})
+		return
+	}
+	willTopic := mqttToTopic(connect.willTopic)
+	if connect.willTopic != "" && (!validPublishTopic(willTopic) || !userCanPublish(user, willTopic)) {
+		writeMQTTPacket(conn, mqttConnack, 0, []byte{0, mqttNotAuthorized})
+		return
+	}
+	// as inscrições não sobrevivem à conexão, então session present fica 0
+	// mesmo quando há QoS 1 para reenviar (ver StartMQTT)
+	writeMQTTPacket(conn, mqttConnack, 0, []byte{0, mqttAccepted})
+
+	keepAlive := time.Duration(connect.keepAlive) * time.Second
+	if keepAlive == 0 {
+		keepAlive = config.KeepAlive
+	}
+	maxQoS := byte(0)
+	if config.QoS > 0 {
+		maxQoS = 1
+	}
+
+	id := uuid.New().String()
+	mc := &mqttConn{
+		Conn:     conn,
+		filters:  map[string]map[string]byte{},
+		inflight: map[uint16]*mqttInflight{},
+		done:     make(chan struct{}),
+	}
+	// as entregas passam pela fila de saída como as do TCP; CONNACK, SUBACK,
+	// PUBACK e PINGRESP vão direto em mc.writePacket
+	out := mq.outbound(mc)
+	sessionKey := connect.username + "/" + connect.clientId
+	keep := !connect.cleanSession
+	if s := mq.takeMQTTSession(sessionKey); s != nil && keep {
+		mc.inflight, mc.packetId, mc.seq = s.inflight, s.packetId, s.seq
+	}
+	info := &connInfo{
+		User:        connect.username,
+		Name:        connect.clientId,
+		Kind:        "mqtt",
+		WillTopic:   willTopic,
+		WillPayload: connect.willMessage,
+		Connected:   time.Now(),
+	}
+	mq.mu.Lock()
+	mq.clients[id] = out
+	mq.ips[id] = conn.RemoteAddr().String()
+	mq.info[id] = info
+	mq.mu.Unlock()
+	mq.watch(out, id, info)
+	defer func() {
+		close(mc.done)
+		out.Close()
+		if keep {
+			mq.keepMQTTSession(sessionKey, mc)
+		}
+		if mq.detach(id, out, info) {
+			mq.handleDisconnect(id, out, info)
+		}
+	}()
+	mq.publishConnEvent("$SYS.conn.connect", id, out, info)
+
+	retry := config.RetryInterval
+	if retry <= 0 {
+		retry = defaultMQTTRetry
+	}
+	mc.resend(time.Now())
+	go mc.retry(retry)
+
+	for {
+		// o cliente tem 1,5x o keep-alive para mandar algo (3.1.2.10)
+		if keepAlive > 0 {
+			conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+		limits = mq.limits()
+		packetType, flags, body, err := readMQTTPacket(reader, limits.MaxLine)
+		if err != nil {
+			return
+		}
+		info.bytesIn.Add(uint64(len(body)))
+		switch packetType {
+		case mqttPublish:
+			if !mq.handleMQTTPublish(id, mc, info, flags, body, limits.MaxPayload) {
+				return
+			}
+		case mqttPuback:
+			mc.ack(body)
+		case mqttSubscribe:
+			if !mq.handleMQTTSubscribe(id, mc, info, user, maxQoS, body) {
+				return
+			}
+		case mqttUnsubscribe:
+			if !mq.handleMQTTUnsubscribe(id, mc, body) {
+				return
+			}
+		case mqttPingreq:
+			mc.writePacket(mqttPingresp, 0, nil)
+		case mqttDisconnect:
+			info.Clean = true
+			return
+		default:
+			return
+		}
+	}
+}
+
+// handleMQTTPublish devolve false para derrubar a conexão; o MQTT 3.1.1 não
+// tem como recusar um PUBLISH, então payload acima do limite derruba.
+func (mq *MQ) handleMQTTPublish(id string, mc *mqttConn, info *connInfo, flags byte, body []byte, maxPayload int) bool {
+	qos := (flags >> 1) & 0x03
+	if qos > 1 {
+		return false
+	}
+	topic, rest, err := readMQTTString(body)
+	if err != nil || strings.ContainsAny(topic, "+#") {
+		return false
+	}
+	var packetId []byte
+	if qos == 1 {
+		if len(rest) < 2 {
+			return false
+		}
+		packetId, rest = rest[:2], rest[2:]
+	}
+
+	if len(rest) > maxPayload {
+		mq.connLog(id).Warn("Payload acima de max_payload", "cmd", "PUBLISH", "size", len(rest), "max", maxPayload)
+		return false
+	}
+	native := mqttToTopic(topic)
+	data := MQData{Cmd: "PUB", Topic: native, Payload: string(rest)}
+	if mq.throttled(info, "PUB", len(body)) {
+		// sem PUBACK: o cliente reenvia o QoS 1 mais tarde
+		return !mq.throttle(id, info, data)
+	}
+	if validPublishTopic(native) && mq.canPublish(id, native) {
+		mq.handlePub(data)
+	}
+	if qos == 1 {
+		mc.writePacket(mqttPuback, 0, packetId)
+	}
+	return true
+}
+
+func (mq *MQ) handleMQTTSubscribe(id string, mc *mqttConn, info *connInfo, user utils.User, maxQoS byte, body []byte) bool {
+	if len(body) < 2 {
+		return false
+	}
+	packetId, rest := body[:2], body[2:]
+	// recusado pelo rate limit: todos os filtros voltam com falha no SUBACK
+	throttled := mq.throttled(info, "SUB", len(body))
+	codes := []byte{}
+	for len(rest) > 0 {
+		filter, next, err := readMQTTString(rest)
+		if err != nil || len(next) < 1 {
+			return false
+		}
+		qos := next[0] & 0x03
+		rest = next[1:]
+
+		native := mqttToTopic(filter)
+		if throttled || !userCanSubscribe(user, native) {
+			codes = append(codes, mqttSubscribeFailure)
+			continue
+		}
+		if qos > maxQoS {
+			qos = maxQoS
+		}
+		if !mq.addMQTTSub(id, mc, native, filter, qos) {
+			codes = append(codes, mqttSubscribeFailure)
+			continue
+		}
+		codes = append(codes, qos)
+	}
+	mc.writePacket(mqttSuback, 0, append(packetId, codes...))
+	if throttled {
+		return !mq.throttle(id, info, MQData{Cmd: "SUB"})
+	}
+	return true
+}
+
+// addMQTTSub registra o filtro; devolve false se um tópico novo passaria de
+// max_subscriptions.
+func (mq *MQ) addMQTTSub(id string, mc *mqttConn, native, filter string, qos byte) bool {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	if !mc.subscribed(native) {
+		if max := mq.config.Limits.MaxSubscriptions; max > 0 && mq.subscriptions(id) >= max {
+			return false
+		}
+	}
+	if mc.addFilter(native, filter, qos) {
+		mq.subs[native] = append(mq.subs[native], id)
+		mq.interestChanged()
+	}
+	return true
+}
+
+func (mq *MQ) handleMQTTUnsubscribe(id string, mc *mqttConn, body []byte) bool {
+	if len(body) < 2 {
+		return false
+	}
+	packetId, rest := body[:2], body[2:]
+	for len(rest) > 0 {
+		filter, next, err := readMQTTString(rest)
+		if err != nil {
+			return false
+		}
+		rest = next
+		native := mqttToTopic(filter)
+		if mc.removeFilter(native, filter) {
+			mq.removeSub(id, native)
+		}
+	}
+	mc.writePacket(mqttUnsuback, 0, packetId)
+	return true
+}
+
+// mqttConn é o net.Conn de um cliente MQTT, atrás da fila de saída em
+// mq.clients: Write recebe os frames JSON do broker e envia os PUB como
+// PUBLISH.
+type mqttConn struct {
+	net.Conn
+	writeMu  sync.Mutex
+	mu       sync.Mutex
+	filters  map[string]map[string]byte // tópico nativo -> filtro MQTT -> QoS
+	inflight map[uint16]*mqttInflight   // QoS 1 esperando PUBACK
+	packetId uint16
+	seq      uint64
+	done     chan struct{}
+}
+
+// mqttInflight é um PUBLISH QoS 1 enviado e ainda sem PUBACK.
+type mqttInflight struct {
+	body []byte
+	seq  uint64 // ordem de envio, mantida no reenvio (4.6)
+	sent time.Time
+}
+
+// mqttSession guarda o QoS 1 sem PUBACK de um cliente que caiu sem clean
+// session, por mq.session_grace.
+type mqttSession struct {
+	inflight map[uint16]*mqttInflight
+	packetId uint16
+	seq      uint64
+	timer    *time.Timer
+}
+
+func (mq *MQ) takeMQTTSession(key string) *mqttSession {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	s := mq.mqttInflight[key]
+	if s != nil {
+		s.timer.Stop()
+		delete(mq.mqttInflight, key)
+	}
+	return s
+}
+
+func (mq *MQ) keepMQTTSession(key string, mc *mqttConn) {
+	mc.mu.Lock()
+	// cópia: a goroutine de retry da conexão antiga pode ainda estar no resend
+	s := &mqttSession{inflight: map[uint16]*mqttInflight{}, packetId: mc.packetId, seq: mc.seq}
+	for id, f := range mc.inflight {
+		copied := *f
+		s.inflight[id] = &copied
+	}
+	mc.mu.Unlock()
+	grace := mq.Config().SessionGrace
+	if len(s.inflight) == 0 || grace <= 0 {
+		return
+	}
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	s.timer = time.AfterFunc(grace, func() {
+		mq.mu.Lock()
+		defer mq.mu.Unlock()
+		if mq.mqttInflight[key] == s {
+			delete(mq.mqttInflight, key)
+		}
+	})
+	mq.mqttInflight[key] = s
+}
+
+// nextPacketId pula os ids ainda em voo. Chamado com o lock.
+func (c *mqttConn) nextPacketId() uint16 {
+	for {
+		c.packetId++
+		if c.packetId == 0 {
+			c.packetId = 1
+		}
+		if _, used := c.inflight[c.packetId]; !used {
+			return c.packetId
+		}
+	}
+}
+
+// dropOldest esquece o QoS 1 mais antigo. Chamado com o lock.
+func (c *mqttConn) dropOldest() {
+	var oldest uint16
+	var seq uint64
+	for id, f := range c.inflight {
+		if seq == 0 || f.seq < seq {
+			oldest, seq = id, f.seq
+		}
+	}
+	delete(c.inflight, oldest)
+}
+
+// ack tira da fila de reenvio o PUBLISH confirmado pelo PUBACK.
+func (c *mqttConn) ack(body []byte) {
+	if len(body) < 2 {
+		return
+	}
+	c.mu.Lock()
+	delete(c.inflight, binary.BigEndian.Uint16(body))
+	c.mu.Unlock()
+}
+
+// resend reenvia com DUP, na ordem original, o que foi enviado até before.
+func (c *mqttConn) resend(before time.Time) error {
+	c.mu.Lock()
+	due := []*mqttInflight{}
+	for _, f := range c.inflight {
+		if !f.sent.After(before) {
+			due = append(due, f)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].seq < due[j].seq })
+	now := time.Now()
+	bodies := make([][]byte, len(due))
+	for i, f := range due {
+		f.sent = now
+		bodies[i] = f.body
+	}
+	c.mu.Unlock()
+	for _, body := range bodies {
+		if err := c.writePacket(mqttPublish, mqttDup|1<<1, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retry reenvia o QoS 1 que passou de interval sem PUBACK, até a conexão cair.
+func (c *mqttConn) retry(interval time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if c.resend(time.Now().Add(-interval)) != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *mqttConn) subscribed(native string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, exists := c.filters[native]
+	return exists
+}
+
+// addFilter devolve true se o tópico nativo ainda não estava inscrito.
+func (c *mqttConn) addFilter(native, filter string, qos byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, exists := c.filters[native]
+	if !exists {
+		c.filters[native] = map[string]byte{}
+	}
+	c.filters[native][filter] = qos
+	return !exists
+}
+
+// removeFilter devolve true se o tópico nativo ficou sem filtros.
+func (c *mqttConn) removeFilter(native, filter string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	filters, exists := c.filters[native]
+	if !exists {
+		return false
+	}
+	delete(filters, filter)
+	if len(filters) > 0 {
+		return false
+	}
+	delete(c.filters, native)
+	return true
+}
+
+func (c *mqttConn) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(string(p), "\n") {
+		if line == "" {
+			continue
+		}
+		data := MQData{}
+		if json.Unmarshal([]byte(line), &data) != nil || data.Cmd != "PUB" {
+			continue
+		}
+		if err := c.publish(data); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (c *mqttConn) publish(data MQData) error {
+	topic := topicToMQTT(data.Topic)
+	c.mu.Lock()
+	matched := false
+	qos := byte(0)
+	for filter, filterQoS := range c.filters[data.Regtopic] {
+		if mqttMatch(filter, topic) {
+			matched = true
+			if filterQoS > qos {
+				qos = filterQoS
+			}
+		}
+	}
+	if !matched {
+		c.mu.Unlock()
+		return nil
+	}
+	body := appendMQTTString(nil, topic)
+	if qos == 1 {
+		id := c.nextPacketId()
+		body = binary.BigEndian.AppendUint16(body, id)
+		body = append(body, data.Payload...)
+		if len(c.inflight) >= mqttMaxInflight {
+			c.dropOldest()
+		}
+		c.seq++
+		c.inflight[id] = &mqttInflight{body: body, seq: c.seq, sent: time.Now()}
+	} else {
+		body = append(body, data.Payload...)
+	}
+	c.mu.Unlock()
+	return c.writePacket(mqttPublish, qos<<1, body)
+}
+
+func (c *mqttConn) writePacket(packetType, flags byte, body []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return writeMQTTPacket(c.Conn, packetType, flags, body)
+}
+
+type mqttConnectPacket struct {
+	clientId     string
+	cleanSession bool
+	username     string
+	password     string
+	willTopic    string
+	willMessage  string
+	keepAlive    uint16
+}
+
+func parseMQTTConnect(body []byte) (mqttConnectPacket, error) {
+	connect := mqttConnectPacket{}
+	protocol, rest, err := readMQTTString(body)
+	if err != nil || protocol != "MQTT" || len(rest) < 4 || rest[0] != 4 {
+		return connect, errors.New("unsupported mqtt protocol")
+	}
+	flags := rest[1]
+	connect.cleanSession = flags&0x02 != 0
+	connect.keepAlive = binary.BigEndian.Uint16(rest[2:4])
+	rest = rest[4:]
+
+	if connect.clientId, rest, err = readMQTTString(rest); err != nil {
+		return connect, err
+	}
+	if flags&0x04 != 0 {
+		if connect.willTopic, rest, err = readMQTTString(rest); err != nil {
+			return connect, err
+		}
+		if connect.willMessage, rest, err = readMQTTString(rest); err != nil {
+			return connect, err
+		}
+	}
+	if flags&0x80 != 0 {
+		if connect.username, rest, err = readMQTTString(rest); err != nil {
+			return connect, err
+		}
+	}
+	if flags&0x40 != 0 {
+		if connect.password, _, err = readMQTTString(rest); err != nil {
+			return connect, err
+		}
+	}
+	return connect, nil
+}
+
+func readMQTTPacket(reader *bufio.Reader, max int) (byte, byte, []byte, error) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	length := 0
+	for shift := 0; ; shift += 7 {
+		if shift > 21 {
+			return 0, 0, nil, errors.New("malformed mqtt remaining length")
+		}
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		length |= int(b&0x7F) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if length > max {
+		return 0, 0, nil, ErrLineTooLong
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return 0, 0, nil, err
+	}
+	return header >> 4, header & 0x0F, body, nil
+}
+
+func writeMQTTPacket(w io.Writer, packetType, flags byte, body []byte) error {
+	packet := []byte{packetType<<4 | flags}
+	length := len(body)
+	for {
+		b := byte(length & 0x7F)
+		length >>= 7
+		if length > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if length == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+	_, err := w.Write(packet)
+	return err
+}
+
+func readMQTTString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errors.New("malformed mqtt string")
+	}
+	length := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+length {
+		return "", nil, errors.New("malformed mqtt string")
+	}
+	return string(b[2 : 2+length]), b[2+length:], nil
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
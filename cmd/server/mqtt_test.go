@@ -0,0 +1,278 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"mq/utils"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMQTTRemainingLength(t *testing.T) {
+	cases := []struct {
+		length  int
+		encoded []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7F}},
+		{128, []byte{0x80, 0x01}},
+		{321, []byte{0xC1, 0x02}},
+		{16383, []byte{0xFF, 0x7F}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+		{2097151, []byte{0xFF, 0xFF, 0x7F}},
+		{2097152, []byte{0x80, 0x80, 0x80, 0x01}},
+	}
+	for _, c := range cases {
+		body := bytes.Repeat([]byte{'x'}, c.length)
+		var buf bytes.Buffer
+		if err := writeMQTTPacket(&buf, mqttPublish, 0x02, body); err != nil {
+			t.Fatal(err)
+		}
+		header := buf.Bytes()[:1+len(c.encoded)]
+		if header[0] != mqttPublish<<4|0x02 || !bytes.Equal(header[1:], c.encoded) {
+			t.Fatalf("length %d: header % x, want % x", c.length, header[1:], c.encoded)
+		}
+		packetType, flags, got, err := readMQTTPacket(bufio.NewReader(&buf), defaultMaxLine)
+		if err != nil {
+			t.Fatalf("length %d: %v", c.length, err)
+		}
+		if packetType != mqttPublish || flags != 0x02 || len(got) != c.length {
+			t.Fatalf("length %d: type %d flags %d body %d", c.length, packetType, flags, len(got))
+		}
+	}
+}
+
+func TestMQTTRemainingLengthMalformed(t *testing.T) {
+	// cinco bytes de continuação passam do limite de quatro (2.2.3)
+	packet := []byte{mqttPublish << 4, 0xFF, 0xFF, 0xFF, 0xFF, 0x01}
+	if _, _, _, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(packet)), defaultMaxLine); err == nil {
+		t.Fatal("expected error for 5-byte remaining length")
+	}
+	// acima de max_line
+	packet = []byte{mqttPublish << 4, 0x80, 0x01}
+	if _, _, _, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(packet)), 127); err != ErrLineTooLong {
+		t.Fatalf("err = %v, want ErrLineTooLong", err)
+	}
+	// corpo menor que o anunciado
+	packet = []byte{mqttPublish << 4, 0x05, 'a'}
+	if _, _, _, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(packet)), defaultMaxLine); err == nil {
+		t.Fatal("expected error for truncated body")
+	}
+}
+
+func connectBody(level, flags byte, keepAlive uint16, fields ...string) []byte {
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, level, flags)
+	body = binary.BigEndian.AppendUint16(body, keepAlive)
+	for _, f := range fields {
+		body = appendMQTTString(body, f)
+	}
+	return body
+}
+
+func TestMQTTParseConnect(t *testing.T) {
+	cases := []struct {
+		name  string
+		body  []byte
+		want  mqttConnectPacket
+		error bool
+	}{
+		{
+			name: "clean, no credentials",
+			body: connectBody(4, 0x02, 30, "c1"),
+			want: mqttConnectPacket{clientId: "c1", cleanSession: true, keepAlive: 30},
+		},
+		{
+			name: "user and password",
+			body: connectBody(4, 0xC0, 60, "c2", "root", "pw"),
+			want: mqttConnectPacket{clientId: "c2", username: "root", password: "pw", keepAlive: 60},
+		},
+		{
+			name: "will, user and password",
+			body: connectBody(4, 0xC6, 0, "c3", "dev/will", "bye", "dev", "dpw"),
+			want: mqttConnectPacket{
+				clientId: "c3", cleanSession: true, willTopic: "dev/will",
+				willMessage: "bye", username: "dev", password: "dpw",
+			},
+		},
+		{name: "protocol level 3", body: connectBody(3, 0x02, 0, "c4"), error: true},
+		{name: "missing password", body: connectBody(4, 0xC0, 0, "c5", "root"), error: true},
+	}
+	for _, c := range cases {
+		got, err := parseMQTTConnect(c.body)
+		if c.error {
+			if err == nil {
+				t.Errorf("%s: expected error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestMQTTTopicMapping(t *testing.T) {
+	for mqtt, native := range map[string]string{
+		"a/b/c": "a.b.c",
+		"a/+/c": "a.*.c",
+		"a/#":   "a.*",
+		"a":     "a",
+	} {
+		if got := mqttToTopic(mqtt); got != native {
+			t.Errorf("mqttToTopic(%q) = %q, want %q", mqtt, got, native)
+		}
+	}
+	if got := topicToMQTT("a.b.c"); got != "a/b/c" {
+		t.Errorf("topicToMQTT = %q", got)
+	}
+
+	cases := []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/#", "a/b/c", true},
+		{"a/#", "a", true}, // "#" também casa o nível pai (4.7.1.2)
+		{"#", "a/b", true},
+		{"a/+/c", "a/x/c", true},
+		{"a/b", "a/b/c", false},
+		{"a/b/c", "a/b", false},
+	}
+	for _, c := range cases {
+		if got := mqttMatch(c.filter, c.topic); got != c.match {
+			t.Errorf("mqttMatch(%q, %q) = %v", c.filter, c.topic, got)
+		}
+	}
+}
+
+func mqttPipe(t *testing.T) (*mqttConn, *bufio.Reader) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	server.SetDeadline(deadline)
+	client.SetDeadline(deadline)
+	mc := &mqttConn{
+		Conn:     server,
+		filters:  map[string]map[string]byte{},
+		inflight: map[uint16]*mqttInflight{},
+		done:     make(chan struct{}),
+	}
+	return mc, bufio.NewReader(client)
+}
+
+func TestMQTTQoS1Resend(t *testing.T) {
+	mc, client := mqttPipe(t)
+	mc.addFilter("a.b", "a/b", 1)
+
+	go mc.publish(MQData{Cmd: "PUB", Topic: "a.b", Regtopic: "a.b", Payload: "hi"})
+	_, flags, body, err := readMQTTPacket(client, defaultMaxLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags != 1<<1 {
+		t.Fatalf("flags = %#x, want QoS 1 without DUP", flags)
+	}
+	topic, rest, _ := readMQTTString(body)
+	packetId := binary.BigEndian.Uint16(rest)
+	if topic != "a/b" || string(rest[2:]) != "hi" {
+		t.Fatalf("publish %q %q", topic, rest[2:])
+	}
+
+	// sem PUBACK o mesmo PUBLISH volta com DUP
+	go mc.resend(time.Now())
+	_, flags, dup, err := readMQTTPacket(client, defaultMaxLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags != mqttDup|1<<1 || !bytes.Equal(dup, body) {
+		t.Fatalf("resend flags %#x body % x", flags, dup)
+	}
+
+	mc.ack(binary.BigEndian.AppendUint16(nil, packetId))
+	if err := mc.resend(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if len(mc.inflight) != 0 {
+		t.Fatalf("inflight after PUBACK: %d", len(mc.inflight))
+	}
+}
+
+func TestMQTTQoS1KeptAcrossReconnect(t *testing.T) {
+	mq := &MQ{
+		config:       utils.MQConfig{SessionGrace: time.Minute},
+		mqttInflight: map[string]*mqttSession{},
+	}
+	old, client := mqttPipe(t)
+	old.addFilter("a.b", "a/b", 1)
+	go old.publish(MQData{Cmd: "PUB", Topic: "a.b", Regtopic: "a.b", Payload: "1"})
+	if _, _, _, err := readMQTTPacket(client, defaultMaxLine); err != nil {
+		t.Fatal(err)
+	}
+	mq.keepMQTTSession("u/c", old)
+
+	s := mq.takeMQTTSession("u/c")
+	if s == nil || len(s.inflight) != 1 {
+		t.Fatalf("session = %+v", s)
+	}
+	if mq.takeMQTTSession("u/c") != nil {
+		t.Fatal("session taken twice")
+	}
+
+	// ids novos não colidem com os que ainda estão em voo
+	mc, _ := mqttPipe(t)
+	mc.inflight, mc.packetId, mc.seq = s.inflight, s.packetId, s.seq
+	mc.packetId = 0xFFFF
+	for id := range s.inflight {
+		if next := mc.nextPacketId(); next == id {
+			t.Fatalf("packet id %d reused while in flight", id)
+		}
+	}
+}
+
+func TestMQTTInflightBounded(t *testing.T) {
+	mc, client := mqttPipe(t)
+	go io.Copy(io.Discard, client)
+	mc.addFilter("a.b", "a/b", 1)
+	for i := 0; i < mqttMaxInflight+10; i++ {
+		if err := mc.publish(MQData{Cmd: "PUB", Topic: "a.b", Regtopic: "a.b"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(mc.inflight) != mqttMaxInflight {
+		t.Fatalf("inflight = %d", len(mc.inflight))
+	}
+	if _, ok := mc.inflight[1]; ok {
+		t.Fatal("oldest packet was not dropped")
+	}
+}
+
+func TestMQTTPersistentSessionNeedsClientId(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	mq := &MQ{}
+	go mq.handleMQTT(server, utils.MQTTConfig{})
+
+	go writeMQTTPacket(client, mqttConnect, 0, connectBody(4, 0x00, 30, ""))
+	packetType, _, body, err := readMQTTPacket(bufio.NewReader(client), defaultMaxLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packetType != mqttConnack || !bytes.Equal(body, []byte{0, mqttBadClientId}) {
+		t.Fatalf("CONNACK = %d %v", packetType, body)
+	}
+}
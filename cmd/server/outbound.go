@@ -0,0 +1,205 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mq/utils"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxPending      = 4096
+	defaultMaxPendingBytes = 16 << 20
+)
+
+var errSlowConsumer = errors.New("slow consumer")
+
+// SlowConsumerEvent é o payload de $SYS.conn.slow, publicado quando a fila
+// de saída de um cliente enche (uma vez até ela esvaziar de novo).
+type SlowConsumerEvent struct {
+	ID         string `json:"id"`
+	User       string `json:"user"`
+	Name       string `json:"name"`
+	RemoteAddr string `json:"remoteAddr"`
+	Policy     string `json:"policy"`
+	Pending    int    `json:"pending"`
+}
+
+// outConn é a conexão de um cliente com fila de saída: Write só enfileira
+// e uma goroutine escreve, então um cliente lento não segura a publicação
+// para os outros.
+type outConn struct {
+	net.Conn
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queue    [][]byte
+	bytes    int
+	closed   bool
+	draining bool // fecha quando a fila esvaziar
+	slow     bool // a fila encheu e ainda não esvaziou
+	config   utils.SlowConsumerConfig
+	onSlow   func(pending int)
+	onDrop   func()
+	inFlight bool
+}
+
+// outbound embrulha conn com a fila de mq.slow_consumer.
+func (mq *MQ) outbound(conn net.Conn) *outConn {
+	config := mq.Config().SlowConsumer
+	if config.MaxPending <= 0 {
+		config.MaxPending = defaultMaxPending
+	}
+	if config.MaxPendingBytes <= 0 {
+		config.MaxPendingBytes = defaultMaxPendingBytes
+	}
+	if config.Policy == "" {
+		config.Policy = "drop_newest"
+	}
+	c := &outConn{Conn: conn, config: config, onDrop: mq.metrics.slowDrop}
+	c.cond = sync.NewCond(&c.mu)
+	go c.flush()
+	return c
+}
+
+// watch liga os avisos de cliente lento, depois que o id é conhecido.
+func (mq *MQ) watch(c *outConn, id string, info *connInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onSlow = func(pending int) {
+		mq.metrics.slowConsumer()
+		slog.Warn("Cliente lento", "conn", id, "user", info.User, "policy", c.config.Policy, "pending", pending)
+		str, _ := json.Marshal(SlowConsumerEvent{
+			ID:         id,
+			User:       info.User,
+			Name:       info.Name,
+			RemoteAddr: c.RemoteAddr().String(),
+			Policy:     c.config.Policy,
+			Pending:    pending,
+		})
+		// fora do Write: a publicação pode voltar para este mesmo cliente
+		go mq.handlePub(MQData{Cmd: "PUB", Topic: "$SYS.conn.slow", Payload: string(str)})
+	}
+}
+
+func (c *outConn) Write(p []byte) (int, error) {
+	frame := append([]byte(nil), p...)
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	full := len(c.queue) >= c.config.MaxPending || c.bytes+len(frame) > c.config.MaxPendingBytes
+	if !full {
+		c.push(frame)
+		c.mu.Unlock()
+		return len(p), nil
+	}
+
+	report := !c.slow
+	c.slow = true
+	onSlow, pending := c.onSlow, len(c.queue)
+	var err error
+	switch c.config.Policy {
+	case "disconnect":
+		err = errSlowConsumer
+	case "drop_oldest":
+		for len(c.queue) > 0 && (len(c.queue) >= c.config.MaxPending || c.bytes+len(frame) > c.config.MaxPendingBytes) {
+			c.bytes -= len(c.queue[0])
+			c.queue = c.queue[1:]
+			c.onDrop()
+		}
+		c.push(frame)
+	default:
+		c.onDrop()
+	}
+	c.mu.Unlock()
+
+	if report && onSlow != nil {
+		onSlow(pending)
+	}
+	if err != nil {
+		c.Close()
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// push enfileira o frame. Chamado com o lock.
+func (c *outConn) push(frame []byte) {
+	c.queue = append(c.queue, frame)
+	c.bytes += len(frame)
+	c.cond.Signal()
+}
+
+func (c *outConn) flush() {
+	for {
+		c.mu.Lock()
+		c.inFlight = false
+		for len(c.queue) == 0 && !c.closed && !c.draining {
+			c.slow = false
+			c.cond.Wait()
+		}
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+		if len(c.queue) == 0 {
+			c.mu.Unlock()
+			c.Close()
+			return
+		}
+		frame := c.queue[0]
+		c.queue = c.queue[1:]
+		c.bytes -= len(frame)
+		c.inFlight = true
+		c.mu.Unlock()
+
+		if _, err := c.Conn.Write(frame); err != nil {
+			c.Close()
+			return
+		}
+	}
+}
+
+// Buffered conta os frames ainda não escritos; o Shutdown espera zerar.
+func (c *outConn) Buffered() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.queue)
+	if c.inFlight {
+		n++
+	}
+	return n
+}
+
+// closeAfterFlush fecha depois de escrever a fila (a última resposta, o
+// THROTTLE que derruba), com um prazo para um cliente que parou de ler.
+func (c *outConn) closeAfterFlush(timeout time.Duration) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.draining = true
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	c.Conn.SetWriteDeadline(time.Now().Add(timeout))
+}
+
+// Close descarta a fila e fecha a conexão; a escrita em andamento falha.
+func (c *outConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return net.ErrClosed
+	}
+	c.closed = true
+	c.queue = nil
+	c.bytes = 0
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"mq/utils"
+	"net"
+	"testing"
+	"time"
+)
+
+// Um cliente que não lê enche a fila de saída, qualquer que seja o
+// transporte atrás dela.
+func TestSlowConsumerOnEveryTransport(t *testing.T) {
+	mq := &MQ{
+		config:  utils.MQConfig{SlowConsumer: utils.SlowConsumerConfig{MaxPending: 2, Policy: "disconnect"}},
+		metrics: newMetrics(),
+	}
+	mc, _ := mqttPipe(t)
+	mc.addFilter("a.b", "a/b", 0)
+	sse := newSSEConn("sse")
+	for name, conn := range map[string]net.Conn{"mqtt": mc, "sse": sse} {
+		out := mq.outbound(conn)
+		frame := []byte(`{"cmd":"PUB","topic":"a.b","regtopic":"a.b","payload":"x"}` + "\n")
+		var err error
+		// um frame em escrita e dois na fila; o quarto passa do limite
+		for i := 0; i < 4 && err == nil; i++ {
+			_, err = out.Write(frame)
+		}
+		if err != errSlowConsumer {
+			t.Fatalf("%s: err = %v, want errSlowConsumer", name, err)
+		}
+	}
+	select {
+	case <-sse.done:
+	case <-time.After(time.Second):
+		t.Fatal("sse connection left open")
+	}
+}
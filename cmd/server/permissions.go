@@ -0,0 +1,97 @@
+package server
+
+import (
+	"mq/utils"
+	"strings"
+)
+
+// allowed diz se o tópico casa com algum dos padrões; lista vazia libera tudo.
+func allowed(patterns []string, topic string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if pattern == topic {
+			return true
+		}
+		re, err := RegexpString(pattern)
+		if err != nil {
+			continue
+		}
+		if re.MatchString(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (mq *MQ) userOf(id string) (utils.User, bool) {
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+	info := mq.info[id]
+	if info == nil {
+		return utils.User{}, false
+	}
+	user, ok := mq.users[info.User]
+	return user, ok
+}
+
+// checkPassword confere usuário e senha; os mapas mudam no reload. Senha
+// vazia nunca confere, nem para um usuário configurado sem senha.
+func (mq *MQ) checkPassword(username, password string) (utils.User, bool) {
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+	expected, ok := mq.auth[username]
+	if !ok || expected == "" || expected != password {
+		return utils.User{}, false
+	}
+	return mq.users[username], true
+}
+
+func (mq *MQ) userByToken(token string) (utils.User, bool) {
+	if token == "" {
+		return utils.User{}, false
+	}
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+	for _, user := range mq.users {
+		if user.Token != "" && user.Token == token {
+			return user, true
+		}
+	}
+	return utils.User{}, false
+}
+
+// sysTopic diz se o tópico é do broker ($SYS.*): só ele publica ali.
+func sysTopic(topic string) bool {
+	return strings.HasPrefix(topic, "$SYS.")
+}
+
+func userCanPublish(user utils.User, topic string) bool {
+	if sysTopic(topic) {
+		return false
+	}
+	return user.IsAdmin || allowed(user.Publish, topic)
+}
+
+func userCanSubscribe(user utils.User, topic string) bool {
+	return user.IsAdmin || allowed(user.Subscribe, topic)
+}
+
+// canPublish vale para PUB, REQ e SEND. O próprio broker ("self") pode tudo.
+func (mq *MQ) canPublish(id, topic string) bool {
+	if id == "self" {
+		return true
+	}
+	user, ok := mq.userOf(id)
+	return ok && userCanPublish(user, topic)
+}
+
+// canSubscribe vale para SUB e SER.
+func (mq *MQ) canSubscribe(id, topic string) bool {
+	if id == "self" {
+		return true
+	}
+	user, ok := mq.userOf(id)
+	return ok && userCanSubscribe(user, topic)
+}
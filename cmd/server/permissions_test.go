@@ -0,0 +1,38 @@
+package server
+
+import (
+	"mq/utils"
+	"testing"
+)
+
+func TestTokenOnlyUserNeedsToken(t *testing.T) {
+	config := utils.MQConfig{
+		Username: "root",
+		Password: "pw",
+		Users: []utils.User{
+			{Username: "dash", Token: "dashtok"},
+			{Username: "dev", Password: "dpw"},
+		},
+	}
+	auth, users := buildUsers(config)
+	mq := &MQ{auth: auth, users: users}
+
+	if _, ok := mq.checkPassword("dash", ""); ok {
+		t.Fatal("token-only user logged in with an empty password")
+	}
+	if _, ok := mq.checkPassword("dash", "dashtok"); ok {
+		t.Fatal("token accepted as password")
+	}
+	if user, ok := mq.userByToken("dashtok"); !ok || user.Username != "dash" {
+		t.Fatalf("userByToken = %v, %v", user, ok)
+	}
+	if _, ok := mq.checkPassword("dev", "dpw"); !ok {
+		t.Fatal("password user rejected")
+	}
+	if _, ok := mq.checkPassword("dev", ""); ok {
+		t.Fatal("empty password accepted")
+	}
+	if _, ok := mq.checkPassword("root", "pw"); !ok {
+		t.Fatal("main user rejected")
+	}
+}
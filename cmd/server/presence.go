@@ -0,0 +1,47 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+)
+
+// ConnEvent é o payload de $SYS.conn.connect e $SYS.conn.disconnect.
+type ConnEvent struct {
+	ID         string `json:"id"`
+	User       string `json:"user"`
+	RemoteAddr string `json:"remoteAddr"`
+	Name       string `json:"name"`
+	Clean      bool   `json:"clean,omitempty"`
+}
+
+func (mq *MQ) publishConnEvent(topic, id string, conn net.Conn, info *connInfo) {
+	str, _ := json.Marshal(ConnEvent{
+		ID:         id,
+		User:       info.User,
+		RemoteAddr: conn.RemoteAddr().String(),
+		Name:       info.Name,
+		Clean:      info.Clean,
+	})
+	mq.handlePub(MQData{
+		Cmd:     "PUB",
+		Topic:   topic,
+		Payload: string(str),
+	})
+}
+
+// handleDisconnect publica o last-will (se a conexão não terminou com STOP)
+// e o evento de desconexão.
+func (mq *MQ) handleDisconnect(id string, conn net.Conn, info *connInfo) {
+	mq.mu.RLock()
+	clean := info.Clean
+	mq.mu.RUnlock()
+	if !clean && info.WillTopic != "" {
+		mq.handlePub(MQData{
+			Cmd:     "PUB",
+			Topic:   info.WillTopic,
+			Payload: info.WillPayload,
+			FromId:  id,
+		})
+	}
+	mq.publishConnEvent("$SYS.conn.disconnect", id, conn, info)
+}
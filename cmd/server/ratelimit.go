@@ -0,0 +1,264 @@
+package server
+
+import (
+	"math"
+	"mq/utils"
+	"sync"
+	"time"
+)
+
+// Classes de comando com orçamento próprio no rate limit.
+type rateClass int
+
+const (
+	rateNone rateClass = iota
+	ratePubSub
+	rateRequest
+	rateStorage
+)
+
+func rateClassOf(cmd string) rateClass {
+	switch cmd {
+	case "PUB", "APUB", "SEND", "SUB", "SER":
+		return ratePubSub
+	case "REQ":
+		return rateRequest
+	case "SET", "GET", "DEL", "BDEL", "BADD", "BFV", "BFK",
+		"DB_CC", "DB_CD", "DB_CI", "DB_CG", "DB_CR", "DB_CF", "DB_CU", "DB_CL":
+		return rateStorage
+	}
+	// BATCH não conta como frame: cada item é cobrado na própria classe
+	return rateNone
+}
+
+// charge é o custo de um frame: um token da classe e size bytes.
+type charge struct {
+	class rateClass
+	size  int
+}
+
+// bucket é um token bucket que enche rate tokens por segundo, até rate.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) refill(rate float64, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = rate
+	} else {
+		b.tokens = math.Min(rate, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+}
+
+// has aceita um frame maior que o balde quando ele está cheio (o saldo fica
+// negativo), senão esse frame nunca passaria.
+func (b *bucket) has(rate, n float64) bool {
+	return rate <= 0 || b.tokens >= math.Min(n, rate)
+}
+
+func (b *bucket) take(rate, n float64) {
+	if rate > 0 {
+		b.tokens -= n
+	}
+}
+
+// limiter guarda os baldes de uma conexão ou de um usuário.
+type limiter struct {
+	mu           sync.Mutex
+	messages     bucket
+	bytes        bucket
+	requests     bucket
+	storage      bucket
+	storageBytes bucket
+}
+
+// reset esvazia o histórico: os baldes voltam cheios no próximo frame.
+func (l *limiter) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages, l.bytes, l.requests = bucket{}, bucket{}, bucket{}
+	l.storage, l.storageBytes = bucket{}, bucket{}
+}
+
+// buckets devolve o balde de frames e o de bytes da classe, com as taxas.
+func (l *limiter) buckets(limit utils.RateLimit, class rateClass) (*bucket, float64, *bucket, float64) {
+	switch class {
+	case rateRequest:
+		return &l.requests, limit.Requests, &l.bytes, limit.Bytes
+	case rateStorage:
+		return &l.storage, limit.Storage, &l.storageBytes, limit.StorageBytes
+	}
+	return &l.messages, limit.Messages, &l.bytes, limit.Bytes
+}
+
+type rateCheck struct {
+	l     *limiter
+	limit utils.RateLimit
+}
+
+// allow só consome os tokens se todos os limitadores aceitarem todas as
+// cobranças; os itens de um BATCH passam ou são recusados juntos.
+func allow(charges []charge, now time.Time, checks ...rateCheck) bool {
+	for _, c := range checks {
+		c.l.mu.Lock()
+		defer c.l.mu.Unlock()
+	}
+	type need struct {
+		rate, n float64
+		count   bool
+	}
+	needs := make([]map[*bucket]*need, len(checks))
+	for i, c := range checks {
+		needs[i] = map[*bucket]*need{}
+		add := func(b *bucket, rate, n float64, count bool) {
+			if needs[i][b] == nil {
+				needs[i][b] = &need{rate: rate, count: count}
+			}
+			needs[i][b].n += n
+		}
+		for _, ch := range charges {
+			count, countRate, bytes, bytesRate := c.l.buckets(c.limit, ch.class)
+			add(count, countRate, 1, true)
+			add(bytes, bytesRate, float64(ch.size), false)
+		}
+		for b, nd := range needs[i] {
+			b.refill(nd.rate, now)
+			ok := b.has(nd.rate, nd.n)
+			if nd.count && nd.n > 1 {
+				// vários itens não passam com o balde cheio: cada um custa
+				// um token de verdade
+				ok = nd.rate <= 0 || b.tokens >= nd.n
+			}
+			if !ok {
+				return false
+			}
+		}
+	}
+	for i := range checks {
+		for b, nd := range needs[i] {
+			b.take(nd.rate, nd.n)
+		}
+	}
+	return true
+}
+
+// overrideRate troca em base os limites que o usuário definiu.
+func overrideRate(base, user utils.RateLimit) utils.RateLimit {
+	if user.Messages > 0 {
+		base.Messages = user.Messages
+	}
+	if user.Bytes > 0 {
+		base.Bytes = user.Bytes
+	}
+	if user.Requests > 0 {
+		base.Requests = user.Requests
+	}
+	if user.Storage > 0 {
+		base.Storage = user.Storage
+	}
+	if user.StorageBytes > 0 {
+		base.StorageBytes = user.StorageBytes
+	}
+	return base
+}
+
+// throttled diz se o frame passa do limite da conexão ou do usuário.
+func (mq *MQ) throttled(info *connInfo, cmd string, size int) bool {
+	if info == nil {
+		return false
+	}
+	return mq.userThrottled(info.User, &info.rate, cmd, size)
+}
+
+// userThrottled confere o limite do usuário e, se conn não for nil, o da
+// conexão. O gateway HTTP não tem conexão: só conta o do usuário.
+func (mq *MQ) userThrottled(user string, conn *limiter, cmd string, size int) bool {
+	class := rateClassOf(cmd)
+	if class == rateNone {
+		return false
+	}
+	return mq.overBudget(user, conn, []charge{{class: class, size: size}})
+}
+
+// batchThrottled cobra cada item do BATCH na classe do próprio comando.
+func (mq *MQ) batchThrottled(info *connInfo, items []MQData) bool {
+	if info == nil {
+		return false
+	}
+	charges := []charge{}
+	for _, item := range items {
+		if class := rateClassOf(item.Cmd); class != rateNone {
+			charges = append(charges, charge{class: class, size: len(item.Topic) + len(item.Payload)})
+		}
+	}
+	if len(charges) == 0 {
+		return false
+	}
+	return mq.overBudget(info.User, &info.rate, charges)
+}
+
+func (mq *MQ) overBudget(user string, conn *limiter, charges []charge) bool {
+	mq.mu.RLock()
+	config := mq.config.RateLimit
+	userLimit := overrideRate(config.User, mq.users[user].RateLimit)
+	shared := mq.userRates[user]
+	mq.mu.RUnlock()
+	if shared == nil {
+		mq.mu.Lock()
+		if shared = mq.userRates[user]; shared == nil {
+			shared = &limiter{}
+			mq.userRates[user] = shared
+		}
+		mq.mu.Unlock()
+	}
+	checks := []rateCheck{{l: shared, limit: userLimit}}
+	if conn != nil {
+		checks = append(checks, rateCheck{l: conn, limit: config.Conn})
+	}
+	return !allow(charges, time.Now(), checks...)
+}
+
+// throttle responde THROTTLE ao frame recusado e conta a recusa; devolve
+// true quando a conexão passou de mq.rate_limit.strikes e deve cair.
+func (mq *MQ) throttle(id string, info *connInfo, data MQData) bool {
+	mq.metrics.throttle(data.Cmd)
+	config := mq.Config().RateLimit
+	drop := false
+	if config.Strikes > 0 {
+		window := config.StrikeWindow
+		if window <= 0 {
+			window = 10 * time.Second
+		}
+		now := time.Now()
+		// só a goroutine que lê a conexão mexe em strikes
+		recent := info.strikes[:0]
+		for _, t := range info.strikes {
+			if now.Sub(t) < window {
+				recent = append(recent, t)
+			}
+		}
+		info.strikes = append(recent, now)
+		drop = len(info.strikes) >= config.Strikes
+	}
+
+	res := MQData{
+		Cmd:       "THROTTLE",
+		ReplayId:  id,
+		RequestId: data.RequestId,
+		Topic:     data.Topic,
+		Error:     ErrThrottled.Error(),
+		Code:      CodeLimitExceeded,
+		Headers:   map[string]string{"cmd": data.Cmd},
+	}
+	if drop {
+		// sem sessão para retomar: quem abusa volta do zero
+		mq.mu.Lock()
+		info.Clean = true
+		mq.mu.Unlock()
+		res.Payload = "disconnected"
+	}
+	mq.Send(id, res)
+	return drop
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"mq/utils"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUserBudgetSharedAcrossTransports(t *testing.T) {
+	mq := &MQ{
+		config:    utils.MQConfig{RateLimit: utils.RateLimitConfig{User: utils.RateLimit{Messages: 2}}},
+		users:     map[string]utils.User{"dev": {Username: "dev"}},
+		userRates: map[string]*limiter{},
+		metrics:   newMetrics(),
+	}
+	reached := 0
+	handler := mq.httpLimit("PUB", func(w http.ResponseWriter, r *http.Request, user utils.User) {
+		reached++
+		w.WriteHeader(http.StatusOK)
+	})
+	codes := []int{}
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", "/pub/dev.a", strings.NewReader("x")), utils.User{Username: "dev"})
+		codes = append(codes, w.Code)
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || reached != 2 {
+		t.Fatalf("codes = %v, reached = %d", codes, reached)
+	}
+
+	// o balde do usuário já esvaziou pelo HTTP: a conexão TCP também é recusada
+	info := &connInfo{User: "dev"}
+	if !mq.throttled(info, "PUB", 1) {
+		t.Fatal("connection bypassed the user budget")
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/pub/dev.a", strings.NewReader("x")), utils.User{Username: "dev"})
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), `"code":"limit_exceeded"`) {
+		t.Fatalf("status %d body %s", w.Code, w.Body.String())
+	}
+	if mq.metrics.throttled["PUB"] != 1 {
+		t.Fatalf("throttled = %v", mq.metrics.throttled)
+	}
+}
+
+func TestBatchChargesEachItem(t *testing.T) {
+	mq := &MQ{
+		config: utils.MQConfig{
+			RateLimit: utils.RateLimitConfig{Conn: utils.RateLimit{Messages: 1, Storage: 2}},
+			Limits:    utils.Limits{MaxBatchItems: 4},
+		},
+		users:     map[string]utils.User{"dev": {Username: "dev"}},
+		userRates: map[string]*limiter{},
+		metrics:   newMetrics(),
+	}
+	info := &connInfo{User: "dev"}
+	set := MQData{Cmd: "SET", Topic: "b.k", Payload: "v"}
+	pub := MQData{Cmd: "PUB", Topic: "dev.a", Payload: "x"}
+
+	// três SET passam de storage = 2 mesmo com o balde cheio
+	if !mq.batchThrottled(info, []MQData{set, set, set}) {
+		t.Fatal("batch over the storage budget was accepted")
+	}
+	// o SET cabe, mas os dois PUB passam de messages = 1
+	if !mq.batchThrottled(info, []MQData{set, pub, pub}) {
+		t.Fatal("batch over the messages budget was accepted")
+	}
+	if info.rate.storage.tokens != 2 || info.rate.messages.tokens != 1 {
+		t.Fatalf("refused batches took tokens: storage %v messages %v", info.rate.storage.tokens, info.rate.messages.tokens)
+	}
+	if mq.batchThrottled(info, []MQData{set, set, pub}) {
+		t.Fatal("batch within budget was refused")
+	}
+	if info.rate.storage.tokens != 0 || info.rate.messages.tokens != 0 {
+		t.Fatalf("storage %v messages %v", info.rate.storage.tokens, info.rate.messages.tokens)
+	}
+}
+
+func TestBatchReplies(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	info := &connInfo{User: "dev"}
+	mq := &MQ{
+		config: utils.MQConfig{
+			RateLimit: utils.RateLimitConfig{Conn: utils.RateLimit{Storage: 1}},
+			Limits:    utils.Limits{MaxBatchItems: 2},
+		},
+		clients:   map[string]net.Conn{"c1": server},
+		info:      map[string]*connInfo{"c1": info},
+		users:     map[string]utils.User{"dev": {Username: "dev"}},
+		userRates: map[string]*limiter{},
+		metrics:   newMetrics(),
+	}
+	r := bufio.NewReader(client)
+	batch := func(items ...MQData) *MQData {
+		t.Helper()
+		payload, _ := json.Marshal(BatchRequest{Items: items})
+		go mq.dispatch("c1", info, connLogger{}, MQData{Cmd: "BATCH", RequestId: "r1", Payload: string(payload)})
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := jsonToStruct(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	set := MQData{Cmd: "SET", Topic: "b.k", Payload: "v"}
+
+	data := batch(set, set, set)
+	if data.Cmd != "ERR" || data.Code != CodeLimitExceeded || data.Error != ErrMaxBatchItems.Error() {
+		t.Fatalf("reply = %+v", data)
+	}
+	data = batch(set, set)
+	if data.Cmd != "THROTTLE" || data.RequestId != "r1" || data.Headers["cmd"] != "BATCH" {
+		t.Fatalf("reply = %+v", data)
+	}
+	if mq.metrics.throttled["BATCH"] != 1 {
+		t.Fatalf("throttled = %v", mq.metrics.throttled)
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// ErrInternal é a resposta a um frame cujo handler entrou em panic.
+var ErrInternal = errors.New("internal error")
+
+// recoverFrame segura o panic do handler de um frame: só esse frame recebe
+// erro internal, a conexão e o broker seguem. Usar com defer.
+func (mq *MQ) recoverFrame(id string, data MQData) {
+	if r := recover(); r != nil {
+		mq.panicked(id, data, r)
+		mq.reject(id, data, withCode(CodeInternal, ErrInternal))
+	}
+}
+
+// recoverRoute é o recoverFrame das rotas e das inscrições do próprio
+// broker: não há a quem entregar o erro, então fica só o log.
+func (mq *MQ) recoverRoute(id string, data MQData) {
+	if r := recover(); r != nil {
+		mq.panicked(id, data, r)
+	}
+}
+
+// recoverHandler segura o panic de um serviço do próprio broker: quem fez o
+// request recebe res com erro internal em vez de esperar o timeout. Usar
+// com defer.
+func (mq *MQ) recoverHandler(id string, data MQData, from string, res MQData) {
+	if r := recover(); r != nil {
+		mq.panicked(id, data, r)
+		res.Error, res.Code = ErrInternal.Error(), CodeInternal
+		mq.handleRes(from, res)
+	}
+}
+
+func (mq *MQ) panicked(id string, data MQData, r any) {
+	mq.metrics.panic(data.Cmd)
+	frameLog(mq.connLog(id), data).Error("Panic no handler",
+		"topic", data.Topic, "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServicePanicAnswersRequest(t *testing.T) {
+	mq := &MQ{
+		services:  map[string]string{"svc": "self"},
+		chrequest: map[string]chan MQResponse{},
+		serviceself: map[string]func(MQData, func(string, string)){
+			"svc": func(data MQData, reply func(string, string)) { panic("boom") },
+		},
+		metrics: newMetrics(),
+	}
+	start := time.Now()
+	res, err := mq.request("svc", "x", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != CodeInternal || res.Error != ErrInternal.Error() {
+		t.Fatalf("res = %+v", res)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("request waited for the timeout")
+	}
+	if mq.metrics.panics["REQ"] != 1 {
+		t.Fatalf("panics = %v", mq.metrics.panics)
+	}
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"mq/utils"
+	"net"
+	"reflect"
+	"strings"
+)
+
+// ReloadReport diz o que o reload aplicou e o que mudou no arquivo mas só
+// vale depois de reiniciar o broker.
+type ReloadReport struct {
+	Applied []string `json:"applied"`
+	Restart []string `json:"restart"`
+}
+
+// record guarda a configuração com que uma parte do broker subiu, para o
+// reload saber o que mudou.
+func (mq *MQ) record(set func(running *utils.ServerConfig)) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	set(&mq.running)
+}
+
+// Config devolve a configuração do broker em uso (muda com o reload).
+func (mq *MQ) Config() utils.MQConfig {
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+	return mq.config
+}
+
+// buildUsers monta as senhas e os usuários; o usuário principal é admin.
+// Quem só tem token fica fora de auth: não entra por senha.
+func buildUsers(config utils.MQConfig) (map[string]string, map[string]utils.User) {
+	auth := map[string]string{config.Username: config.Password}
+	users := map[string]utils.User{
+		config.Username: {Username: config.Username, Password: config.Password, IsAdmin: true},
+	}
+	for _, user := range config.Users {
+		if user.Password != "" {
+			auth[user.Username] = user.Password
+		}
+		users[user.Username] = user
+	}
+	return auth, users
+}
+
+// ReloadConfig relê o arquivo de configuração e aplica o que dá para
+// aplicar sem derrubar conexões: usuários e permissões, limites, rate
+// limit, os intervalos (stats, ping, idle, session grace), certificados TLS
+// e logs. Só caem as conexões de usuários removidos. Se o arquivo for
+// inválido ou um certificado não carregar nada é aplicado.
+func (mq *MQ) ReloadConfig() (ReloadReport, error) {
+	config, err := utils.ReadConfig()
+	if err != nil {
+		return ReloadReport{}, err
+	}
+	return mq.Reload(config)
+}
+
+// Reload aplica config; veja ReloadConfig.
+func (mq *MQ) Reload(config *utils.ServerConfig) (ReloadReport, error) {
+	report := ReloadReport{Applied: []string{}, Restart: []string{}}
+
+	// os certificados são carregados antes de mexer em qualquer coisa
+	mq.mu.RLock()
+	loaders := map[string]*certLoader{}
+	for name, loader := range mq.certs {
+		loaders[name] = loader
+	}
+	mq.mu.RUnlock()
+	certs := map[string]*tls.Certificate{}
+	for name := range loaders {
+		certFile, keyFile := config.HTTP.CertFile, config.HTTP.KeyFile
+		if name == "websocket" {
+			certFile, keyFile = config.WebSocket.CertFile, config.WebSocket.KeyFile
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return ReloadReport{}, fmt.Errorf("%s: %v", name, err)
+		}
+		certs[name] = &cert
+	}
+
+	mq.mu.Lock()
+	old := mq.running
+	next := config.MQ
+	usersChanged := old.MQ.Username != next.Username || old.MQ.Password != next.Password || !reflect.DeepEqual(old.MQ.Users, next.Users)
+	var dropped []net.Conn
+	if usersChanged {
+		mq.auth, mq.users = buildUsers(next)
+		dropped = mq.revoke()
+		report.Applied = append(report.Applied, "users")
+	}
+	if old.MQ.SessionQueue != next.SessionQueue || old.MQ.ShutdownTimeout != next.ShutdownTimeout ||
+		old.MQ.AuthTimeout != next.AuthTimeout || old.MQ.Limits != next.Limits ||
+		old.MQ.SlowConsumer != next.SlowConsumer {
+		report.Applied = append(report.Applied, "limits")
+	}
+	// os baldes recomeçam cheios com as taxas novas
+	rateChanged := !reflect.DeepEqual(old.MQ.RateLimit, next.RateLimit)
+	if rateChanged || usersChanged {
+		mq.userRates = map[string]*limiter{}
+		for _, info := range mq.info {
+			info.rate.reset()
+		}
+	}
+	if rateChanged {
+		report.Applied = append(report.Applied, "rate_limit")
+	}
+	// o heartbeat e o $SYS.stats leem o intervalo a cada volta; a nova
+	// session_grace vale para as sessões que caírem daqui em diante
+	if old.MQ.StatsInterval != next.StatsInterval || old.MQ.PingInterval != next.PingInterval ||
+		old.MQ.MaxPingsOut != next.MaxPingsOut || old.MQ.IdleTimeout != next.IdleTimeout ||
+		old.MQ.SessionGrace != next.SessionGrace {
+		report.Applied = append(report.Applied, "schedules")
+	}
+	// endereços e arquivos continuam os de quando o broker subiu
+	next.Broker, next.Port, next.FileKV = old.MQ.Broker, old.MQ.Port, old.MQ.FileKV
+	mq.config = next
+	mq.running.MQ = next
+	mq.running.Logs = config.Logs
+	mq.running.HTTP.CertFile, mq.running.HTTP.KeyFile = config.HTTP.CertFile, config.HTTP.KeyFile
+	mq.running.WebSocket.CertFile, mq.running.WebSocket.KeyFile = config.WebSocket.CertFile, config.WebSocket.KeyFile
+	mq.mu.Unlock()
+	for _, conn := range dropped {
+		if conn != nil {
+			conn.Close()
+		}
+	}
+
+	for name, cert := range certs {
+		loaders[name].set(cert)
+		report.Applied = append(report.Applied, "tls."+name)
+	}
+	// os logs sempre são reabertos, o que também serve para o logrotate
+	utils.SetupLogger(config.Logs)
+	report.Applied = append(report.Applied, "logs")
+
+	report.Restart = restartOnly(old, *config)
+	slog.Info("Configuração recarregada", "applied", report.Applied, "restart", report.Restart)
+	return report, nil
+}
+
+// revoke aplica os usuários novos a quem já está conectado: as conexões e
+// sessões de usuários removidos caem, e as inscrições e serviços que o
+// usuário não pode mais ter são retirados. Devolve as conexões a fechar
+// depois de soltar o lock. Chamado com o lock.
+func (mq *MQ) revoke() []net.Conn {
+	owners := map[string]string{}
+	for id, info := range mq.info {
+		if info.Kind != "route" {
+			owners[id] = info.User
+		}
+	}
+	for id, s := range mq.sessions {
+		owners[id] = s.user
+	}
+
+	dropped := []net.Conn{}
+	for id, username := range owners {
+		if _, ok := mq.users[username]; ok {
+			continue
+		}
+		slog.Info("Usuário removido no reload", "conn", id, "user", username)
+		if s := mq.sessions[id]; s != nil {
+			s.timer.Stop()
+			delete(mq.sessions, id)
+		}
+		if info := mq.info[id]; info != nil {
+			// sem sessão nem last-will, como no A_KICK
+			info.Clean = true
+			dropped = append(dropped, mq.clients[id])
+		}
+		mq.removeInterest(id)
+		delete(owners, id)
+	}
+	for key := range mq.mqttInflight {
+		if _, ok := mq.users[strings.SplitN(key, "/", 2)[0]]; !ok {
+			mq.mqttInflight[key].timer.Stop()
+			delete(mq.mqttInflight, key)
+		}
+	}
+
+	for topic, ids := range mq.subs {
+		kept := ids[:0:0]
+		for _, id := range ids {
+			username, ok := owners[id]
+			if ok && !userCanSubscribe(mq.users[username], topic) {
+				slog.Info("Inscrição revogada no reload", "conn", id, "user", username, "topic", topic)
+				continue
+			}
+			kept = append(kept, id)
+		}
+		if len(kept) == 0 {
+			delete(mq.subs, topic)
+		} else {
+			mq.subs[topic] = kept
+		}
+	}
+	for topic, id := range mq.services {
+		username, ok := owners[id]
+		if ok && !userCanSubscribe(mq.users[username], topic) {
+			slog.Info("Serviço revogado no reload", "conn", id, "user", username, "topic", topic)
+			delete(mq.services, topic)
+			if other := mq.remoteService(topic); other != "" {
+				mq.services[topic] = other
+			}
+		}
+	}
+	mq.interestChanged()
+	return dropped
+}
+
+// restartOnly lista o que mudou entre a configuração em uso e a nova mas
+// só é lido quando o broker sobe: endereços, arquivos e as seções de
+// listeners, cluster, leaf e Raft.
+func restartOnly(old, next utils.ServerConfig) []string {
+	changed := []string{}
+	check := func(name string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			changed = append(changed, name)
+		}
+	}
+	check("mq.broker", old.MQ.Broker, next.MQ.Broker)
+	check("mq.port", old.MQ.Port, next.MQ.Port)
+	check("mq.kvfile", old.MQ.FileKV, next.MQ.FileKV)
+
+	// os certificados recarregam; o resto da seção não
+	old.HTTP.CertFile, old.HTTP.KeyFile = next.HTTP.CertFile, next.HTTP.KeyFile
+	old.WebSocket.CertFile, old.WebSocket.KeyFile = next.WebSocket.CertFile, next.WebSocket.KeyFile
+	sections := []struct {
+		name     string
+		enabled  bool
+		old, new interface{}
+	}{
+		{"http", old.HTTP.Enabled || next.HTTP.Enabled, old.HTTP, next.HTTP},
+		{"websocket", old.WebSocket.Enabled || next.WebSocket.Enabled, old.WebSocket, next.WebSocket},
+		{"mqtt", old.MQTT.Enabled || next.MQTT.Enabled, old.MQTT, next.MQTT},
+		{"cluster", old.Cluster.Enabled || next.Cluster.Enabled, old.Cluster, next.Cluster},
+		{"leaf", old.Leaf.Enabled || next.Leaf.Enabled, old.Leaf, next.Leaf},
+		{"raft", old.Raft.Enabled || next.Raft.Enabled, old.Raft, next.Raft},
+	}
+	for _, s := range sections {
+		if s.enabled {
+			check(s.name, s.old, s.new)
+		}
+	}
+	return changed
+}
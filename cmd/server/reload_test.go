@@ -0,0 +1,115 @@
+package server
+
+import (
+	"io"
+	"mq/utils"
+	"slices"
+	"testing"
+	"time"
+)
+
+// reloaded aplica change numa cópia da configuração em uso.
+func reloaded(t *testing.T, mq *MQ, change func(config *utils.ServerConfig)) ReloadReport {
+	t.Helper()
+	mq.mu.RLock()
+	next := mq.running
+	mq.mu.RUnlock()
+	next.MQ.Users = slices.Clone(next.MQ.Users)
+	change(&next)
+	report, err := mq.Reload(&next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestReloadRevokesUsers(t *testing.T) {
+	mq := startBroker(t, utils.MQConfig{Username: "root", Password: "pw", Users: []utils.User{
+		{Username: "alice", Password: "a"},
+		{Username: "bob", Password: "b"},
+	}})
+	alice, _ := login(t, mq, "alice", "a", nil)
+	alice.subscribe(t, "a.b")
+	alice.subscribe(t, "x.y")
+	bob, _ := login(t, mq, "bob", "b", nil)
+	bob.subscribe(t, "a.b")
+	watcher, _ := login(t, mq, "root", "pw", nil)
+	watcher.subscribe(t, "$SYS.conn.disconnect")
+
+	// bob sai da lista e alice só pode mais a.b
+	report := reloaded(t, mq, func(config *utils.ServerConfig) {
+		config.MQ.Users = []utils.User{{Username: "alice", Password: "a", Subscribe: []string{"a.b"}}}
+	})
+	if !slices.Contains(report.Applied, "users") {
+		t.Fatalf("applied = %v", report.Applied)
+	}
+
+	// a conexão de bob cai como num A_KICK
+	bob.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(bob.r); err != nil {
+		t.Fatalf("bob's connection: %v", err)
+	}
+	if ev := watcher.expect(t, "PUB", "$SYS.conn.disconnect"); ev.Payload == "" {
+		t.Fatalf("disconnect event = %+v", ev)
+	}
+	c := dialRaw(t, mq)
+	c.send(t, MQData{Cmd: "AUTH", Topic: "bob", Payload: "b", RequestId: "auth"})
+	if res := c.next(t); res.Cmd != "ER_AUH" {
+		t.Fatalf("removed user login = %+v", res)
+	}
+
+	// alice continua conectada, mas perde a inscrição em x.y
+	watcher.send(t, MQData{Cmd: "PUB", Topic: "x.y", Payload: "gone"})
+	watcher.send(t, MQData{Cmd: "PUB", Topic: "a.b", Payload: "kept"})
+	if msg := alice.expect(t, "PUB", "a.b"); msg.Payload != "kept" {
+		t.Fatalf("a.b = %+v", msg)
+	}
+	alice.silent(t, 200*time.Millisecond, "PUB", "x.y")
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+	if len(mq.subs["x.y"]) != 0 || len(mq.subs["a.b"]) != 1 {
+		t.Fatalf("subs = %v", mq.subs)
+	}
+}
+
+func TestReloadRateLimit(t *testing.T) {
+	mq := startBroker(t, utils.MQConfig{Username: "root", Password: "pw",
+		RateLimit: utils.RateLimitConfig{Conn: utils.RateLimit{Messages: 0.1}}})
+	c, _ := login(t, mq, "root", "pw", nil)
+	c.send(t, MQData{Cmd: "PUB", Topic: "a.b", RequestId: "1"})
+	c.send(t, MQData{Cmd: "PUB", Topic: "a.b", RequestId: "2"})
+	if res := c.expect(t, "THROTTLE", "a.b"); res.RequestId != "2" || res.Code != "limit_exceeded" {
+		t.Fatalf("throttled = %+v", res)
+	}
+
+	report := reloaded(t, mq, func(config *utils.ServerConfig) {
+		config.MQ.RateLimit.Conn.Messages = 2
+	})
+	if !slices.Contains(report.Applied, "rate_limit") {
+		t.Fatalf("applied = %v", report.Applied)
+	}
+	// o balde da conexão recomeça cheio com a taxa nova
+	c.send(t, MQData{Cmd: "PUB", Topic: "a.b", RequestId: "3"})
+	c.send(t, MQData{Cmd: "PUB", Topic: "a.b", RequestId: "4"})
+	c.silent(t, 200*time.Millisecond, "THROTTLE", "a.b")
+}
+
+func TestReloadSchedules(t *testing.T) {
+	mq := startBroker(t, utils.MQConfig{Username: "root", Password: "pw"})
+	c, _ := login(t, mq, "root", "pw", nil)
+	c.silent(t, 1500*time.Millisecond, "PING", "")
+
+	// o ping ligado no reload vale para a conexão que já estava aberta
+	port := mq.Config().Port
+	report := reloaded(t, mq, func(config *utils.ServerConfig) {
+		config.MQ.PingInterval = 100 * time.Millisecond
+		config.MQ.Port = port + 1
+	})
+	if !slices.Contains(report.Applied, "schedules") || !slices.Equal(report.Restart, []string{"mq.port"}) {
+		t.Fatalf("report = %+v", report)
+	}
+	if mq.Config().Port != port {
+		t.Fatalf("port changed to %d without a restart", mq.Config().Port)
+	}
+	c.expect(t, "PING", "")
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mq/cmd/db"
+	"mq/cmd/raft"
+	"mq/utils"
+	"strconv"
+	"time"
+)
+
+const raftTimeout = 5 * time.Second
+
+// kvMachine aplica o log do Raft no bbolt do broker.
+type kvMachine struct {
+	db *db.NoSQL
+}
+
+func (m kvMachine) Apply(index, term uint64, data []byte) (string, error) {
+	return m.db.ApplyKV(index, term, data)
+}
+
+func (m kvMachine) Applied() (uint64, uint64) {
+	return m.db.Applied()
+}
+
+func (m kvMachine) Snapshot() (uint64, uint64, []byte, error) {
+	return m.db.SnapshotKV()
+}
+
+func (m kvMachine) Restore(index, term uint64, data []byte) error {
+	return m.db.RestoreKV(index, term, data)
+}
+
+// StartRaft liga o modo replicado: escritas no KV passam pelo log do Raft
+// (um seguidor encaminha ao líder) e leituras esperam o ReadIndex. Precisa
+// ser chamado antes de aceitar clientes.
+func (mq *MQ) StartRaft(config utils.RaftConfig) error {
+	mq.record(func(running *utils.ServerConfig) { running.Raft = config })
+	if _, ok := config.Peers[config.ID]; !ok {
+		return fmt.Errorf("raft: id %q não está em peers", config.ID)
+	}
+	peers := map[string]string{}
+	for id, addr := range config.Peers {
+		if id != config.ID {
+			peers[id] = addr
+		}
+	}
+	if config.LogFile == "" {
+		config.LogFile = mq.config.FileKV + ".raft"
+	}
+	r, err := raft.New(raft.Config{
+		ID:                config.ID,
+		Addr:              config.Host + ":" + strconv.Itoa(config.Port),
+		Peers:             peers,
+		LogFile:           config.LogFile,
+		ElectionTimeout:   config.ElectionTimeout,
+		HeartbeatInterval: config.HeartbeatInterval,
+		SnapshotThreshold: config.SnapshotThreshold,
+		Secret:            config.Secret,
+		Errors:            []error{db.ErrBucketNotFound, db.ErrStoreBucket},
+	}, kvMachine{db: mq.DB})
+	if err != nil {
+		return err
+	}
+	if err := r.Start(); err != nil {
+		r.Stop()
+		return err
+	}
+	mq.raft = r
+	slog.Info("Raft iniciado", "id", config.ID, "addr", config.Host+":"+strconv.Itoa(config.Port))
+	return nil
+}
+
+// replicate grava as operações no log como uma única entrada.
+func (mq *MQ) replicate(ops ...db.KVOp) error {
+	data, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+	_, err = mq.raft.Apply(data, raftTimeout)
+	return err
+}
+
+func (mq *MQ) kvSet(bucket, key, value string) error {
+	if mq.raft == nil {
+		return mq.DB.BSet(bucket, key, value)
+	}
+	return mq.replicate(db.KVOp{Kind: db.KVSet, Bucket: bucket, Key: key, Value: value})
+}
+
+func (mq *MQ) kvDel(bucket, key string) error {
+	if mq.raft == nil {
+		return mq.DB.BDel(bucket, key)
+	}
+	return mq.replicate(db.KVOp{Kind: db.KVDel, Bucket: bucket, Key: key})
+}
+
+func (mq *MQ) kvCreate(bucket string) error {
+	if mq.raft == nil {
+		return mq.DB.BCreate(bucket)
+	}
+	return mq.replicate(db.KVOp{Kind: db.KVCreate, Bucket: bucket})
+}
+
+func (mq *MQ) kvDelete(bucket string) error {
+	if mq.raft == nil {
+		return mq.DB.BDelete(bucket)
+	}
+	return mq.replicate(db.KVOp{Kind: db.KVDelete, Bucket: bucket})
+}
+
+// kvBarrier garante que uma leitura local veja todas as escritas já
+// confirmadas no cluster.
+func (mq *MQ) kvBarrier() error {
+	if mq.raft == nil {
+		return nil
+	}
+	return mq.raft.ReadIndex(raftTimeout)
+}
+
+func (mq *MQ) kvGet(bucket, key string) (string, error) {
+	if err := mq.kvBarrier(); err != nil {
+		return "", err
+	}
+	return mq.DB.BGet(bucket, key)
+}
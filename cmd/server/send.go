@@ -1,8 +1,8 @@
 package server
 
 import (
+	"log/slog"
 	"net"
-	"strings"
 )
 
 func (mq *MQ) send(conn net.Conn, data MQData) error {
@@ -11,17 +11,25 @@ func (mq *MQ) send(conn net.Conn, data MQData) error {
 		return err
 	}
 	conn.Write([]byte(str + "\n"))
+	mq.metrics.out(data.Cmd, len(str)+1)
 	return err
 }
 
 func (mq *MQ) Send(id string, data MQData) error {
 	if id == "self" {
 		topic := data.Topic
-		if strings.Contains(data.Regtopic, ".*.") {
+		// Regtopic é a inscrição que casou, com ou sem curinga
+		if data.Regtopic != "" {
 			topic = data.Regtopic
 		}
-		for _, fn := range mq.subself[topic] {
-			go fn(data)
+		mq.mu.RLock()
+		fns := mq.subself[topic]
+		mq.mu.RUnlock()
+		for _, fn := range fns {
+			go func() {
+				defer mq.recoverRoute("self", data)
+				fn(data)
+			}()
 		}
 		return nil
 	}
@@ -29,9 +37,35 @@ func (mq *MQ) Send(id string, data MQData) error {
 	if err != nil {
 		return err
 	}
-	if mq.clients[id] != nil {
-		_, err = mq.clients[id].Write([]byte(str + "\n"))
+	mq.mu.RLock()
+	conn := mq.clients[id]
+	info := mq.info[id]
+	mq.mu.RUnlock()
+	if data.Error != "" && info != nil {
+		slog.Warn("Resposta com erro", "conn", id, "user", info.User, "cmd", data.Cmd,
+			"requestId", data.RequestId, "topic", data.Topic, "err", data.Error)
 	}
+	if conn != nil {
+		return mq.write(conn, info, data.Cmd, str)
+	}
+	mq.queue(id, data.Cmd, str)
 
 	return err
 }
+
+// write manda um frame já serializado e conta nas métricas do cliente.
+func (mq *MQ) write(conn net.Conn, info *connInfo, cmd, str string) error {
+	mq.metrics.out(cmd, len(str)+1)
+	if info != nil {
+		info.bytesOut.Add(uint64(len(str) + 1))
+	}
+	_, err := conn.Write([]byte(str + "\n"))
+	return err
+}
+
+// conn retorna a conexão do id, ou nil se ela não existe mais.
+func (mq *MQ) conn(id string) net.Conn {
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+	return mq.clients[id]
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"net"
+	"time"
+)
+
+// session guarda uma conexão que caiu sem STOP enquanto ela pode ser
+// retomada: as inscrições e serviços continuam registrados com o mesmo id
+// e as mensagens para ele ficam em pending. conn e info são da conexão que
+// caiu, para o last-will e o $SYS.conn.disconnect se ela expirar.
+type session struct {
+	token    string
+	user     string
+	pending  []pendingFrame
+	timer    *time.Timer
+	resuming bool
+	conn     net.Conn
+	info     *connInfo
+}
+
+// pendingFrame é um frame já serializado, com o cmd para as métricas.
+type pendingFrame struct {
+	cmd string
+	str string
+}
+
+// resume tenta reassociar o id de sessão à nova conexão. Devolve false se a
+// sessão não existe, expirou ou o token/usuário não conferem. O que ficou
+// pendente vai pela fila de saída de conn.
+func (mq *MQ) resume(conn *outConn, auth MQData, info *connInfo) (string, bool) {
+	id := auth.Headers["session"]
+	token := auth.Headers["resume-token"]
+	if id == "" || token == "" || mq.Config().SessionGrace <= 0 {
+		return "", false
+	}
+
+	mq.mu.Lock()
+	// a conexão antiga ainda não caiu (meia-aberta): a nova toma o lugar dela
+	if old := mq.info[id]; old != nil {
+		if old.Token != token || old.User != info.User {
+			mq.mu.Unlock()
+			return "", false
+		}
+		oldConn := mq.clients[id]
+		info.Token = token
+		mq.clients[id] = conn
+		mq.ips[id] = conn.RemoteAddr().String()
+		mq.info[id] = info
+		mq.mu.Unlock()
+		oldConn.Close()
+		mq.send(conn, mq.cnn(id, auth.RequestId, info, true))
+		return id, true
+	}
+
+	s := mq.sessions[id]
+	if s == nil || s.resuming || s.token != token || s.user != info.User {
+		mq.mu.Unlock()
+		return "", false
+	}
+	s.resuming = true
+	s.timer.Stop()
+	mq.mu.Unlock()
+
+	info.Token = token
+	mq.send(conn, mq.cnn(id, auth.RequestId, info, true))
+	// entrega o que ficou pendente; só associa a conexão quando a fila
+	// esvaziar, para manter a ordem das mensagens
+	for {
+		mq.mu.Lock()
+		pending := s.pending
+		s.pending = nil
+		if len(pending) == 0 {
+			delete(mq.sessions, id)
+			mq.clients[id] = conn
+			mq.ips[id] = conn.RemoteAddr().String()
+			mq.info[id] = info
+			mq.mu.Unlock()
+			return id, true
+		}
+		mq.mu.Unlock()
+		for _, frame := range pending {
+			mq.write(conn, info, frame.cmd, frame.str)
+		}
+	}
+}
+
+// detach remove a conexão. Se ela caiu sem STOP, tem token e as sessões
+// estão ligadas, o id fica aguardando retomada por SessionGrace; senão as
+// inscrições e serviços do id são removidos. Devolve true só quando a
+// sessão terminou agora: nem foi substituída por uma retomada nem ficou
+// aguardando uma (aí quem avisa é expireSession).
+func (mq *MQ) detach(id string, conn net.Conn, info *connInfo) bool {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	if mq.clients[id] != conn {
+		return false
+	}
+	delete(mq.ips, id)
+	delete(mq.info, id)
+	delete(mq.clients, id)
+
+	if info.Clean || info.Token == "" || mq.config.SessionGrace <= 0 {
+		mq.removeInterest(id)
+		return true
+	}
+	s := &session{token: info.Token, user: info.User, conn: conn, info: info}
+	s.timer = time.AfterFunc(mq.config.SessionGrace, func() {
+		mq.expireSession(id, s)
+	})
+	mq.sessions[id] = s
+	return false
+}
+
+// expireSession encerra a sessão que não foi retomada a tempo: só agora o
+// cliente conta como desconectado.
+func (mq *MQ) expireSession(id string, s *session) {
+	mq.mu.Lock()
+	if mq.sessions[id] != s || s.resuming {
+		mq.mu.Unlock()
+		return
+	}
+	delete(mq.sessions, id)
+	mq.removeInterest(id)
+	mq.mu.Unlock()
+	mq.handleDisconnect(id, s.conn, s.info)
+}
+
+// queue guarda um frame para uma sessão desconectada. Chamado sem o lock.
+func (mq *MQ) queue(id, cmd, str string) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	s := mq.sessions[id]
+	if s == nil || len(s.pending) >= mq.sessionQueue() {
+		return
+	}
+	s.pending = append(s.pending, pendingFrame{cmd: cmd, str: str})
+}
+
+func (mq *MQ) sessionQueue() int {
+	if mq.config.SessionQueue > 0 {
+		return mq.config.SessionQueue
+	}
+	return 1000
+}
+
+// removeInterest tira o id de todas as inscrições e serviços; um serviço
+// que também existe em outro nó passa para a rota. Chamado com o lock.
+func (mq *MQ) removeInterest(id string) {
+	for topic, ids := range mq.subs {
+		kept := ids[:0:0]
+		for _, sub := range ids {
+			if sub != id {
+				kept = append(kept, sub)
+			}
+		}
+		if len(kept) == 0 {
+			delete(mq.subs, topic)
+		} else {
+			mq.subs[topic] = kept
+		}
+	}
+	for topic, owner := range mq.services {
+		if owner == id {
+			delete(mq.services, topic)
+			if other := mq.remoteService(topic); other != "" {
+				mq.services[topic] = other
+			}
+		}
+	}
+	mq.interestChanged()
+}
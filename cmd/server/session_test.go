@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"mq/utils"
+	"testing"
+	"time"
+)
+
+func TestSessionResumeKeepsInterest(t *testing.T) {
+	mq := startBroker(t, utils.MQConfig{Username: "root", Password: "pw", SessionGrace: 5 * time.Second})
+	watcher, _ := login(t, mq, "root", "pw", nil)
+	watcher.subscribe(t, "will.c1")
+	watcher.subscribe(t, "$SYS.conn.disconnect")
+
+	c1, cnn := login(t, mq, "root", "pw", map[string]string{"will-topic": "will.c1", "will-payload": "gone"})
+	id, token := cnn.Headers["session"], cnn.Headers["resume-token"]
+	if id == "" || token == "" || cnn.Headers["resumed"] != "false" {
+		t.Fatalf("CNN headers = %v", cnn.Headers)
+	}
+	c1.subscribe(t, "a.b")
+
+	// cai sem STOP: a sessão espera a retomada e as mensagens ficam na fila
+	c1.Close()
+	eventually(t, "detached session", func() bool {
+		mq.mu.RLock()
+		defer mq.mu.RUnlock()
+		return mq.sessions[id] != nil
+	})
+	watcher.send(t, MQData{Cmd: "PUB", Topic: "a.b", Payload: "while away"})
+	eventually(t, "queued frame", func() bool {
+		mq.mu.RLock()
+		defer mq.mu.RUnlock()
+		s := mq.sessions[id]
+		return s != nil && len(s.pending) == 1
+	})
+	watcher.silent(t, 200*time.Millisecond, "PUB", "will.c1")
+
+	c2, cnn := login(t, mq, "root", "pw", map[string]string{"session": id, "resume-token": token})
+	if cnn.Payload != id || cnn.Headers["resumed"] != "true" {
+		t.Fatalf("resume CNN = %+v", cnn)
+	}
+	if msg := c2.expect(t, "PUB", "a.b"); msg.Payload != "while away" {
+		t.Fatalf("pending = %+v", msg)
+	}
+	// a inscrição continua valendo para a conexão nova
+	watcher.send(t, MQData{Cmd: "PUB", Topic: "a.b", Payload: "back"})
+	if msg := c2.expect(t, "PUB", "a.b"); msg.Payload != "back" {
+		t.Fatalf("after resume = %+v", msg)
+	}
+	watcher.silent(t, 200*time.Millisecond, "PUB", "$SYS.conn.disconnect")
+
+	// STOP encerra a sessão: sai o disconnect, mas não o last-will
+	c2.send(t, MQData{Cmd: "STOP"})
+	ev := watcher.expect(t, "PUB", "$SYS.conn.disconnect")
+	event := ConnEvent{}
+	if err := json.Unmarshal([]byte(ev.Payload), &event); err != nil || event.ID != id || !event.Clean {
+		t.Fatalf("disconnect event = %s (%v)", ev.Payload, err)
+	}
+	watcher.silent(t, 200*time.Millisecond, "PUB", "will.c1")
+}
+
+func TestSessionExpiryFiresWill(t *testing.T) {
+	mq := startBroker(t, utils.MQConfig{Username: "root", Password: "pw", SessionGrace: 300 * time.Millisecond})
+	watcher, _ := login(t, mq, "root", "pw", nil)
+	watcher.subscribe(t, "will.c1")
+	watcher.subscribe(t, "$SYS.conn.disconnect")
+
+	c1, cnn := login(t, mq, "root", "pw", map[string]string{"will-topic": "will.c1", "will-payload": "gone"})
+	id, token := cnn.Headers["session"], cnn.Headers["resume-token"]
+	c1.subscribe(t, "a.b")
+	start := time.Now()
+	c1.Close()
+
+	if will := watcher.expect(t, "PUB", "will.c1"); will.Payload != "gone" {
+		t.Fatalf("will = %+v", will)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Fatalf("will fired after %v, before the grace period", elapsed)
+	}
+	ev := watcher.expect(t, "PUB", "$SYS.conn.disconnect")
+	event := ConnEvent{}
+	if err := json.Unmarshal([]byte(ev.Payload), &event); err != nil || event.ID != id || event.Clean {
+		t.Fatalf("disconnect event = %s (%v)", ev.Payload, err)
+	}
+	mq.mu.RLock()
+	subs := mq.subs["a.b"]
+	mq.mu.RUnlock()
+	if len(subs) != 0 {
+		t.Fatalf("expired session kept subscriptions: %v", subs)
+	}
+
+	// depois de expirar o token não vale mais
+	c2 := dialRaw(t, mq)
+	c2.send(t, MQData{Cmd: "AUTH", Topic: "root", Payload: "pw", Headers: map[string]string{"session": id, "resume-token": token}})
+	if cnn := c2.next(t); cnn.Cmd != "CNN" || cnn.Payload == id || cnn.Headers["resumed"] != "false" {
+		t.Fatalf("expired resume = %+v", cnn)
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// buffered é implementado pela fila de saída (outConn); Shutdown espera a
+// fila esvaziar antes de fechar.
+type buffered interface {
+	Buffered() int
+}
+
+// closing indica que o Shutdown começou.
+func (mq *MQ) closing() bool {
+	select {
+	case <-mq.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// listen abre um listener TCP que o Shutdown fecha.
+func (mq *MQ) listen(addr string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mq.track(listener)
+	return listener, nil
+}
+
+// track registra algo para o Shutdown fechar: listeners e servidores HTTP.
+func (mq *MQ) track(c io.Closer) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	mq.closers = append(mq.closers, c)
+}
+
+// Shutdown encerra o broker:
+//   - para de aceitar conexões em todos os listeners;
+//   - manda GOAWAY aos clientes, que podem reconectar em outro nó;
+//   - espera os requests em andamento e as filas de saída até ctx acabar;
+//   - fecha as conexões, o cluster, o leaf e o Raft, e por último o banco.
+//
+// Devolve ctx.Err() se o prazo acabou antes de drenar tudo; o encerramento
+// segue do mesmo jeito.
+func (mq *MQ) Shutdown(ctx context.Context) error {
+	mq.mu.Lock()
+	if mq.closing() {
+		mq.mu.Unlock()
+		return errors.New("server already shut down")
+	}
+	close(mq.done)
+	closers := mq.closers
+	mq.closers = nil
+	mq.mu.Unlock()
+
+	// os servidores HTTP param de aceitar mas terminam as respostas em
+	// andamento; os streams SSE só fecham junto com as conexões
+	for _, c := range closers {
+		if server, ok := c.(*http.Server); ok {
+			go server.Shutdown(ctx)
+		} else {
+			c.Close()
+		}
+	}
+
+	mq.mu.RLock()
+	clients := []net.Conn{}
+	for id, conn := range mq.clients {
+		if !strings.HasPrefix(id, routePrefix) {
+			clients = append(clients, conn)
+		}
+	}
+	mq.mu.RUnlock()
+	for _, conn := range clients {
+		mq.send(conn, MQData{Cmd: "GOAWAY", Payload: "server shutting down"})
+	}
+
+	err := mq.drain(ctx)
+
+	mq.mu.Lock()
+	conns := []net.Conn{}
+	for id, conn := range mq.clients {
+		if info := mq.info[id]; info != nil {
+			info.Clean = true
+		}
+		conns = append(conns, conn)
+	}
+	for id, s := range mq.sessions {
+		s.timer.Stop()
+		delete(mq.sessions, id)
+	}
+	l := mq.leaf
+	mq.mu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	for _, c := range closers {
+		if server, ok := c.(*http.Server); ok {
+			server.Close()
+		}
+	}
+
+	if l != nil {
+		l.stop()
+	}
+	if mq.raft != nil {
+		mq.raft.Stop()
+	}
+	if mq.DB != nil {
+		if dbErr := mq.DB.Close(); dbErr != nil && err == nil {
+			err = dbErr
+		}
+	}
+	slog.Info("Servidor encerrado", "drained", err == nil)
+	return err
+}
+
+// drain espera não haver requests em andamento nem frames nas filas de
+// saída (SSE e exportações do leaf).
+func (mq *MQ) drain(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if mq.idle() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// idle diz se não há mais trabalho para esperar. Um request cujo
+// solicitante já caiu está abandonado: a resposta não tem para onde ir.
+func (mq *MQ) idle() bool {
+	froms := mq.metrics.waiting()
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+	for _, from := range froms {
+		if route, _, ok := splitReplay(from); ok {
+			from = route
+		}
+		if from == "self" || mq.clients[from] != nil {
+			return false
+		}
+	}
+	for _, conn := range mq.clients {
+		if b, ok := conn.(buffered); ok && b.Buffered() > 0 {
+			return false
+		}
+	}
+	return mq.leaf == nil || len(mq.leaf.queue) == 0
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIdleIgnoresAbandonedRequests(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	mq := &MQ{clients: map[string]net.Conn{"c1": server}, metrics: newMetrics()}
+
+	mq.metrics.requestStarted("gone", "r1", "s1", "svc", time.Hour)
+	if !mq.idle() {
+		t.Fatal("request from a closed connection blocks shutdown")
+	}
+	mq.metrics.requestStarted("route:n2/gone", "r2", "s1", "svc", time.Hour)
+	if !mq.idle() {
+		t.Fatal("request from a closed route blocks shutdown")
+	}
+	mq.metrics.requestStarted("c1", "r3", "s1", "svc", time.Hour)
+	if mq.idle() {
+		t.Fatal("request from a live connection does not block shutdown")
+	}
+	mq.metrics.requestDone("c1", "r3", "s1")
+	if !mq.idle() {
+		t.Fatal("answered request still blocks shutdown")
+	}
+}
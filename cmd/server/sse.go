@@ -0,0 +1,145 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"mq/utils"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// httpEvents transmite como Server-Sent Events as publicações que casam com
+// ?topic= (pode repetir). A inscrição entra no registro normal com um id
+// próprio e sai quando o cliente HTTP desconecta.
+func (mq *MQ) httpEvents(w http.ResponseWriter, r *http.Request, user utils.User) {
+	topics := r.URL.Query()["topic"]
+	if len(topics) == 0 {
+		writeHTTP(w, http.StatusBadRequest, MQResponse{Error: "topic is required", Code: CodeInvalidRequest})
+		return
+	}
+	for _, topic := range topics {
+		if !userCanSubscribe(user, topic) {
+			writeHTTP(w, http.StatusForbidden, MQResponse{Error: "permission denied", Code: CodePermissionDenied})
+			return
+		}
+	}
+	limits := mq.limits()
+	if limits.MaxSubscriptions > 0 && len(topics) > limits.MaxSubscriptions {
+		writeHTTP(w, http.StatusBadRequest, MQResponse{Error: ErrMaxSubscriptions.Error(), Code: CodeLimitExceeded})
+		return
+	}
+	if mq.full() {
+		writeHTTP(w, http.StatusServiceUnavailable, MQResponse{Error: ErrMaxConnections.Error(), Code: CodeLimitExceeded})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeHTTP(w, http.StatusInternalServerError, MQResponse{Error: "streaming not supported", Code: CodeInternal})
+		return
+	}
+
+	id := uuid.New().String()
+	conn := newSSEConn(r.RemoteAddr)
+	out := mq.outbound(conn)
+	info := &connInfo{User: user.Username, Name: "sse", Kind: "sse", Connected: time.Now()}
+	mq.mu.Lock()
+	mq.clients[id] = out
+	mq.ips[id] = r.RemoteAddr
+	mq.info[id] = info
+	for _, topic := range topics {
+		mq.subs[topic] = append(mq.subs[topic], id)
+	}
+	mq.interestChanged()
+	mq.mu.Unlock()
+	mq.watch(out, id, info)
+	defer func() {
+		out.Close()
+		mq.mu.Lock()
+		delete(mq.clients, id)
+		delete(mq.ips, id)
+		delete(mq.info, id)
+		mq.removeInterest(id)
+		mq.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case frame := <-conn.frames:
+			fmt.Fprintf(w, "data: %s\n\n", frame)
+			flusher.Flush()
+		case <-ticker.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case <-conn.done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// sseConn é o net.Conn de um cliente SSE, atrás da fila de saída em
+// mq.clients: a fila escreve nele e o handler HTTP lê os frames do canal.
+type sseConn struct {
+	frames    chan string
+	remote    sseAddr
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func newSSEConn(remote string) *sseConn {
+	return &sseConn{
+		frames: make(chan string),
+		remote: sseAddr(remote),
+		done:   make(chan struct{}),
+	}
+}
+
+// Write espera o handler HTTP pegar cada frame; quem segura a publicação
+// de um cliente lento é a fila de saída, com os limites de slow_consumer.
+func (c *sseConn) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(string(p), "\n") {
+		if line == "" {
+			continue
+		}
+		select {
+		case c.frames <- line:
+		case <-c.done:
+			return 0, net.ErrClosed
+		}
+	}
+	return len(p), nil
+}
+
+func (c *sseConn) Read(p []byte) (int, error) {
+	return 0, errors.New("sse connection is write-only")
+}
+
+func (c *sseConn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return nil
+}
+
+func (c *sseConn) LocalAddr() net.Addr                { return sseAddr("sse") }
+func (c *sseConn) RemoteAddr() net.Addr               { return c.remote }
+func (c *sseConn) SetDeadline(t time.Time) error      { return nil }
+func (c *sseConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *sseConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type sseAddr string
+
+func (a sseAddr) Network() string { return "sse" }
+func (a sseAddr) String() string  { return string(a) }
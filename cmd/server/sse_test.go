@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"mq/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSSEStreamsUntilDisconnect(t *testing.T) {
+	mq := startBroker(t, utils.MQConfig{Username: "root", Password: "pw", Users: []utils.User{
+		{Username: "viewer", Password: "v", Subscribe: []string{"a.*"}},
+	}})
+	server := httptest.NewServer(mq.httpAuth(mq.httpEvents))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/events?topic=a.*", nil)
+	req.SetBasicAuth("viewer", "v")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, content-type %q", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	// a inscrição entra no registro normal, como a de um cliente TCP
+	var id string
+	eventually(t, "sse subscription", func() bool {
+		mq.mu.RLock()
+		defer mq.mu.RUnlock()
+		if ids := mq.subs["a.*"]; len(ids) == 1 {
+			id = ids[0]
+		}
+		return id != "" && mq.info[id].Kind == "sse"
+	})
+
+	pub, _ := login(t, mq, "root", "pw", nil)
+	pub.send(t, MQData{Cmd: "PUB", Topic: "b.c", Payload: "other"})
+	pub.send(t, MQData{Cmd: "PUB", Topic: "a.b", Payload: "hello"})
+	lines := bufio.NewReader(res.Body)
+	for {
+		line, err := lines.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame, ok := strings.CutPrefix(strings.TrimSpace(line), "data: ")
+		if !ok {
+			continue
+		}
+		data := MQData{}
+		if err := json.Unmarshal([]byte(frame), &data); err != nil {
+			t.Fatalf("%q: %v", frame, err)
+		}
+		if data.Topic != "a.b" || data.Payload != "hello" {
+			t.Fatalf("event = %+v", data)
+		}
+		break
+	}
+
+	// o cliente HTTP some: a inscrição e a conexão saem do registro
+	cancel()
+	eventually(t, "sse cleanup", func() bool {
+		mq.mu.RLock()
+		defer mq.mu.RUnlock()
+		return len(mq.subs["a.*"]) == 0 && mq.clients[id] == nil && mq.info[id] == nil
+	})
+}
+
+func TestSSERefused(t *testing.T) {
+	mq := startBroker(t, utils.MQConfig{Username: "root", Password: "pw", Users: []utils.User{
+		{Username: "viewer", Password: "v", Subscribe: []string{"a.*"}},
+	}})
+	server := httptest.NewServer(mq.httpAuth(mq.httpEvents))
+	defer server.Close()
+
+	cases := []struct {
+		user, pass, query string
+		status            int
+	}{
+		{"viewer", "x", "?topic=a.b", http.StatusUnauthorized},
+		{"viewer", "v", "", http.StatusBadRequest},
+		{"viewer", "v", "?topic=a.b&topic=b.c", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest("GET", server.URL+"/events"+c.query, nil)
+		req.SetBasicAuth(c.user, c.pass)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != c.status {
+			t.Errorf("%s:%s %s: status %d, want %d", c.user, c.pass, c.query, res.StatusCode, c.status)
+		}
+	}
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+	if len(mq.subs) != 0 {
+		t.Fatalf("refused streams left subscriptions: %v", mq.subs)
+	}
+}
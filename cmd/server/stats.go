@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// BrokerStats é o payload publicado periodicamente em $SYS.stats.
+type BrokerStats struct {
+	Time          time.Time      `json:"time"`
+	Connections   int            `json:"connections"`
+	Sessions      int            `json:"sessions"` // desconectadas, esperando retomada
+	Subscriptions map[string]int `json:"subscriptions"`
+	Services      []string       `json:"services"`
+	InFlight      int            `json:"inFlightRequests"`
+	Buckets       map[string]int `json:"buckets"`
+	Collections   map[string]int `json:"collections"`
+}
+
+func (mq *MQ) stats() BrokerStats {
+	stats := BrokerStats{
+		Time:          time.Now(),
+		Subscriptions: map[string]int{},
+		Services:      []string{},
+	}
+	mq.mu.RLock()
+	stats.Connections = len(mq.clients)
+	stats.Sessions = len(mq.sessions)
+	for topic, ids := range mq.subs {
+		stats.Subscriptions[topic] = len(ids)
+	}
+	for topic := range mq.services {
+		stats.Services = append(stats.Services, topic)
+	}
+	mq.mu.RUnlock()
+	sort.Strings(stats.Services)
+
+	mq.metrics.mu.Lock()
+	stats.InFlight = len(mq.metrics.pending)
+	mq.metrics.mu.Unlock()
+
+	if mq.DB != nil {
+		stats.Buckets, stats.Collections, _ = mq.DB.Counts()
+	}
+	return stats
+}
+
+// publishStats publica as estatísticas em $SYS.stats a cada StatsInterval
+// (0 desliga). O intervalo é lido de novo a cada volta por causa do reload.
+func (mq *MQ) publishStats() {
+	for {
+		interval := mq.Config().StatsInterval
+		wait := interval
+		if wait <= 0 {
+			wait = time.Second
+		}
+		select {
+		case <-mq.done:
+			return
+		case <-time.After(wait):
+		}
+		if interval <= 0 {
+			continue
+		}
+		str, err := json.Marshal(mq.stats())
+		if err != nil {
+			continue
+		}
+		mq.Publish("$SYS.stats", string(str))
+	}
+}
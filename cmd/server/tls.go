@@ -0,0 +1,47 @@
+package server
+
+import (
+	"crypto/tls"
+	"sync"
+)
+
+// certLoader guarda o certificado de um listener HTTPS e permite trocá-lo
+// no reload sem derrubar as conexões abertas.
+type certLoader struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func (c *certLoader) load(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	c.set(&cert)
+	return nil
+}
+
+func (c *certLoader) set(cert *tls.Certificate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = cert
+}
+
+func (c *certLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// tlsConfig carrega o certificado e o registra com o nome do listener
+// ("http", "websocket") para o reload.
+func (mq *MQ) tlsConfig(name, certFile, keyFile string) (*tls.Config, error) {
+	loader := &certLoader{}
+	if err := loader.load(certFile, keyFile); err != nil {
+		return nil, err
+	}
+	mq.mu.Lock()
+	mq.certs[name] = loader
+	mq.mu.Unlock()
+	return &tls.Config{GetCertificate: loader.getCertificate}, nil
+}
@@ -0,0 +1,319 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mq/utils"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	wsGUID        = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsOpCont      = 0x0
+	wsOpText      = 0x1
+	wsOpBinary    = 0x2
+	wsOpClose     = 0x8
+	wsOpPing      = 0x9
+	wsOpPong      = 0xA
+	wsFinBit      = 0x80
+	wsMaskBit     = 0x80
+	wsPayloadMask = 0x7F
+)
+
+// StartWebSocket sobe o listener WebSocket. Cada mensagem de texto é um frame
+// MQData; o AUTH e o resto do protocolo são os mesmos do TCP.
+func (mq *MQ) StartWebSocket(config utils.WebSocketConfig) error {
+	path := config.Path
+	if path == "" {
+		path = "/"
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if !checkOrigin(r, config.AllowedOrigins) {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+		conn, err := wsUpgrade(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// uma mensagem é uma linha do protocolo: vale o mesmo max_line
+		conn.max = mq.limits().MaxLine
+		conn.keepalive(config.PingInterval)
+		mq.serve(conn)
+	})
+
+	mq.record(func(running *utils.ServerConfig) { running.WebSocket = config })
+	addr := config.Host + ":" + strconv.Itoa(config.Port)
+	server := &http.Server{Addr: addr, Handler: mux}
+	slog.Info("Servidor WebSocket iniciado", "addr", addr, "path", path)
+	mq.track(server)
+	var err error
+	if config.UseHTTPS {
+		// o certificado vem do certLoader, que o reload pode trocar
+		server.TLSConfig, err = mq.tlsConfig("websocket", config.CertFile, config.KeyFile)
+		if err != nil {
+			return err
+		}
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func checkOrigin(r *http.Request, allowedOrigins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(allowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func headerHas(r *http.Request, name, token string) bool {
+	for _, value := range strings.Split(r.Header.Get(name), ",") {
+		if strings.EqualFold(strings.TrimSpace(value), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func wsUpgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if r.Method != http.MethodGet || !headerHas(r, "Upgrade", "websocket") || !headerHas(r, "Connection", "upgrade") {
+		return nil, errors.New("websocket upgrade required")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.New("missing Sec-WebSocket-Key")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("websocket not supported")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{Conn: conn, reader: rw.Reader, done: make(chan struct{})}, nil
+}
+
+// wsConn adapta uma conexão WebSocket para net.Conn: Read devolve uma
+// mensagem por vez terminada em '\n' e Write manda cada linha como uma
+// mensagem de texto, então handleAuth/handleProcess funcionam sem mudança.
+type wsConn struct {
+	net.Conn
+	reader    *bufio.Reader
+	pending   []byte
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+	done      chan struct{}
+	timeout   time.Duration
+	max       int // tamanho máximo de uma mensagem (mq.limits.max_line)
+}
+
+func (c *wsConn) maxMessage() int {
+	if c.max <= 0 {
+		return defaultMaxLine
+	}
+	return c.max
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		msg, err := c.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		c.pending = append(msg, '\n')
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(p, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if err := c.writeFrame(wsOpText, line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.writeFrame(wsOpClose, nil)
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+// keepalive manda um ping a cada intervalo; se nada chegar em dois
+// intervalos a leitura expira e a conexão cai.
+func (c *wsConn) keepalive(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	c.timeout = 2 * interval
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if c.writeFrame(wsOpPing, nil) != nil {
+					return
+				}
+			case <-c.done:
+				return
+			}
+		}
+	}()
+}
+
+// readMessage lê frames até completar uma mensagem de dados, respondendo
+// ping e close no caminho.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		}
+		switch opcode {
+		case wsOpPing:
+			c.writeFrame(wsOpPong, payload)
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			c.Close()
+			return nil, io.EOF
+		case wsOpText, wsOpBinary, wsOpCont:
+			msg = append(msg, payload...)
+			if len(msg) > c.maxMessage() {
+				c.Close()
+				return nil, ErrLineTooLong
+			}
+			if fin {
+				return msg, nil
+			}
+		default:
+			c.Close()
+			return nil, fmt.Errorf("unknown websocket opcode %d", opcode)
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (bool, byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&wsFinBit != 0
+	opcode := header[0] & 0x0F
+	masked := header[1]&wsMaskBit != 0
+	length := uint64(header[1] & wsPayloadMask)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(c.reader, ext); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(c.reader, ext); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if length > uint64(c.maxMessage()) {
+		return false, 0, nil, ErrLineTooLong
+	}
+	// clientes sempre mascaram os frames (RFC 6455 5.1)
+	if !masked {
+		return false, 0, nil, errors.New("unmasked websocket frame")
+	}
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(c.reader, mask); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{wsFinBit | opcode}
+	length := len(payload)
+	switch {
+	case length < 126:
+		frame = append(frame, byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, 126, byte(length>>8), byte(length))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+	frame = append(frame, payload...)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.Conn.Write(frame)
+	return err
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsPipe liga um wsConn (lado do broker) a uma ponta crua (lado do cliente).
+func wsPipe(t *testing.T) (*wsConn, net.Conn) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	server.SetDeadline(deadline)
+	client.SetDeadline(deadline)
+	return &wsConn{Conn: server, reader: bufio.NewReader(server), done: make(chan struct{})}, client
+}
+
+// clientFrame monta um frame mascarado como um cliente manda.
+func clientFrame(fin bool, opcode byte, payload []byte) []byte {
+	b0 := opcode
+	if fin {
+		b0 |= wsFinBit
+	}
+	frame := []byte{b0}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, wsMaskBit|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, wsMaskBit|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, wsMaskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+// readServerFrame lê um frame do broker, que não mascara.
+func readServerFrame(r io.Reader) (bool, byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return false, 0, nil, err
+	}
+	if header[1]&wsMaskBit != 0 {
+		return false, 0, nil, errors.New("server frame is masked")
+	}
+	length := uint64(header[1] & wsPayloadMask)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		io.ReadFull(r, ext)
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		io.ReadFull(r, ext)
+		length = binary.BigEndian.Uint64(ext)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	return header[0]&wsFinBit != 0, header[0] & 0x0F, payload, nil
+}
+
+func writeAsync(conn net.Conn, frames ...[]byte) chan error {
+	errc := make(chan error, 1)
+	go func() {
+		var err error
+		for _, f := range frames {
+			if _, err = conn.Write(f); err != nil {
+				break
+			}
+		}
+		errc <- err
+	}()
+	return errc
+}
+
+func TestWSReadLengths(t *testing.T) {
+	for _, size := range []int{0, 125, 126, 0xFFFF, 0x10000 + 7} {
+		ws, client := wsPipe(t)
+		payload := bytes.Repeat([]byte("a"), size)
+		errc := writeAsync(client, clientFrame(true, wsOpText, payload))
+		msg, err := ws.readMessage()
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(msg, payload) {
+			t.Fatalf("size %d: got %d bytes", size, len(msg))
+		}
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWSReadAddsNewline(t *testing.T) {
+	ws, client := wsPipe(t)
+	writeAsync(client, clientFrame(true, wsOpText, []byte(`{"cmd":"PING"}`)))
+	line, err := bufio.NewReader(ws).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "{\"cmd\":\"PING\"}\n" {
+		t.Fatalf("line = %q", line)
+	}
+}
+
+func TestWSFragmentedWithPing(t *testing.T) {
+	ws, client := wsPipe(t)
+	writeAsync(client,
+		clientFrame(false, wsOpText, []byte("hel")),
+		clientFrame(true, wsOpPing, []byte("p1")),
+		clientFrame(false, wsOpCont, []byte("lo ")),
+		clientFrame(true, wsOpCont, []byte("world")),
+	)
+	type frame struct {
+		op      byte
+		payload []byte
+	}
+	pong := make(chan frame, 1)
+	go func() {
+		_, op, payload, _ := readServerFrame(client)
+		pong <- frame{op, payload}
+	}()
+	msg, err := ws.readMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "hello world" {
+		t.Fatalf("msg = %q", msg)
+	}
+	f := <-pong
+	if f.op != wsOpPong || string(f.payload) != "p1" {
+		t.Fatalf("pong = %d %q", f.op, f.payload)
+	}
+}
+
+func TestWSRejectsUnmasked(t *testing.T) {
+	ws, client := wsPipe(t)
+	writeAsync(client, []byte{wsFinBit | wsOpText, 2, 'h', 'i'})
+	if _, err := ws.readMessage(); err == nil || !strings.Contains(err.Error(), "unmasked") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestWSRejectsUnknownOpcode(t *testing.T) {
+	ws, client := wsPipe(t)
+	writeAsync(client, clientFrame(true, 0x3, nil))
+	go io.Copy(io.Discard, client)
+	if _, err := ws.readMessage(); err == nil || !strings.Contains(err.Error(), "opcode") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestWSCloseHandshake(t *testing.T) {
+	ws, client := wsPipe(t)
+	writeAsync(client, clientFrame(true, wsOpClose, []byte{0x03, 0xE8}))
+	reply := make(chan byte, 1)
+	go func() {
+		_, op, _, _ := readServerFrame(client)
+		reply <- op
+	}()
+	if _, err := ws.readMessage(); err != io.EOF {
+		t.Fatalf("err = %v, want EOF", err)
+	}
+	if op := <-reply; op != wsOpClose {
+		t.Fatalf("reply opcode = %d, want close", op)
+	}
+	if err := ws.Close(); err != net.ErrClosed {
+		t.Fatalf("second Close = %v", err)
+	}
+}
+
+func TestWSWriteOneMessagePerLine(t *testing.T) {
+	ws, client := wsPipe(t)
+	big := strings.Repeat("x", 300)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := ws.Write([]byte("{\"a\":1}\n" + big + "\n"))
+		errc <- err
+	}()
+	for _, want := range []string{`{"a":1}`, big} {
+		fin, op, payload, err := readServerFrame(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fin || op != wsOpText || string(payload) != want {
+			t.Fatalf("frame fin=%v op=%d payload=%q", fin, op, payload)
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
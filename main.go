@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"mq/cmd/server"
 	"mq/utils"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -14,18 +18,38 @@ import (
 func main() {
 	// Ouve na porta 4051 em todas as interfaces de rede
 
-	config, err := utils.GetConfig()
+	flags, err := utils.ParseFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+	if flags.PrintEnv {
+		fmt.Println(strings.Join(utils.EnvNames(), "\n"))
+		return
+	}
+	config, err := utils.ReadConfig()
 	if err != nil {
 		fmt.Println(err)
+		os.Exit(1)
+	}
+	if flags.CheckConfig {
+		fmt.Println("Configuração válida")
 		return
 	}
-	mq := server.NewMQ(config.MQ)
+	utils.SetupLogger(config.Logs)
+	mq, err := server.NewMQ(config.MQ)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	go mq.Subscribe("test.*.test", func(data server.MQData) {
-		fmt.Println(data.Topic, data.Payload)
+		slog.Debug("test.*.test", "topic", data.Topic, utils.Payload(data.Payload))
 	})
 
 	mq.Service("test", func(data server.MQData, replay func(err string, data string)) {
-		fmt.Println(data.Topic, data.Payload)
+		slog.Debug("test", "topic", data.Topic, utils.Payload(data.Payload))
 		replay("", "okfffffff")
 	})
 
@@ -39,19 +63,78 @@ func main() {
 				mq.Publish("test.t555.test", "dddddddddddddddddd")
 				str, err := mq.Request("testdd", "dddd666ddd", 4*time.Second)
 				if err != nil {
-					fmt.Println(err)
+					slog.Debug("testdd", "err", err)
 				}
-				fmt.Println(str)
+				slog.Debug("testdd", utils.Payload(str))
 				// Coloque aqui a tarefa que você deseja executar
 			}
 		}
 	}()
 
-	go mq.Start()
+	if config.Raft.Enabled {
+		if err := mq.StartRaft(config.Raft); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	// os listeners rodam em goroutines; o erro de um (porta ocupada,
+	// certificado ruim, segredo faltando) derruba o broker
+	failed := make(chan error, 6)
+	start := func(name string, fn func() error) {
+		go func() {
+			if err := fn(); err != nil {
+				failed <- fmt.Errorf("%s: %w", name, err)
+			}
+		}()
+	}
+	start("mq", mq.Start)
+	if config.HTTP.Enabled {
+		start("http", func() error { return mq.StartHTTP(config.HTTP) })
+	}
+	if config.WebSocket.Enabled {
+		start("websocket", func() error { return mq.StartWebSocket(config.WebSocket) })
+	}
+	if config.MQTT.Enabled {
+		start("mqtt", func() error { return mq.StartMQTT(config.MQTT) })
+	}
+	if config.Cluster.Enabled {
+		start("cluster", func() error { return mq.StartCluster(config.Cluster) })
+	}
+	if config.Leaf.Enabled {
+		start("leaf", func() error { return mq.StartLeaf(config.Leaf) })
+	}
 	exit := make(chan os.Signal, 1)
 	signal.Notify(exit, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
-	<-exit
-	log.Println("Sinal de encerramento recebido, fechando o servidor...")
+	// SIGHUP relê a configuração; os outros encerram
+	for {
+		select {
+		case err := <-failed:
+			slog.Error("Falha ao iniciar", "err", err)
+			shutdown(mq)
+			os.Exit(1)
+		case sig := <-exit:
+			if sig == syscall.SIGHUP {
+				if _, err := mq.ReloadConfig(); err != nil {
+					slog.Error("Reload recusado", "err", err)
+				}
+				continue
+			}
+			slog.Info("Sinal de encerramento recebido, fechando o servidor...")
+			shutdown(mq)
+			return
+		}
+	}
+}
 
+func shutdown(mq *server.MQ) {
+	timeout := mq.Config().ShutdownTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := mq.Shutdown(ctx); err != nil {
+		slog.Warn("Encerramento incompleto", "err", err)
+	}
 }
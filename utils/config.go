@@ -1,17 +1,26 @@
 package utils
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
 )
 
 type ServerConfig struct {
-	//HTTP HTTPConfig `toml:"http"`
-	MQ MQConfig `toml:"mq"`
-	//MQTT MQTTConfig `toml:"mqtt"`
-	Logs LogsConfig `toml:"logs"`
+	HTTP      HTTPConfig      `toml:"http"`
+	WebSocket WebSocketConfig `toml:"websocket"`
+	MQ        MQConfig        `toml:"mq"`
+	MQTT      MQTTConfig      `toml:"mqtt"`
+	Cluster   ClusterConfig   `toml:"cluster"`
+	Leaf      LeafConfig      `toml:"leaf"`
+	Raft      RaftConfig      `toml:"raft"`
+	Logs      LogsConfig      `toml:"logs"`
 	//Proc ProcConfig `toml:"proc"`
 }
 
@@ -22,15 +31,80 @@ type HTTPConfig struct {
 	UseHTTPS bool   `toml:"use_https"`
 	CertFile string `toml:"cert_file,omitempty"`
 	KeyFile  string `toml:"key_file,omitempty"`
+
+	Metrics MetricsConfig `toml:"metrics"`
+}
+
+// MetricsConfig expõe as métricas do Prometheus no listener HTTP.
+type MetricsConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Path    string `toml:"path"` // padrão "/metrics"
+	Auth    bool   `toml:"auth"` // exige os mesmos usuários do gateway
+}
+
+// WebSocketConfig é o listener para clientes de navegador: mesmos frames
+// JSON do TCP, um por mensagem de texto.
+type WebSocketConfig struct {
+	Enabled        bool          `toml:"enabled"`
+	Host           string        `toml:"host"`
+	Port           int           `toml:"port"`
+	Path           string        `toml:"path"`
+	AllowedOrigins []string      `toml:"allowed_origins"` // vazio: só a mesma origem; "*" libera todas
+	PingInterval   time.Duration `toml:"ping_interval"`   // 0 desliga o keepalive
+	UseHTTPS       bool          `toml:"use_https"`
+	CertFile       string        `toml:"cert_file,omitempty"`
+	KeyFile        string        `toml:"key_file,omitempty"`
 }
+
+// ClusterConfig liga o nó a outros brokers por conexões de rota (malha
+// completa). Basta listar um nó já existente em Routes: os demais são
+// descobertos por ele.
+type ClusterConfig struct {
+	Enabled   bool     `toml:"enabled"`
+	Name      string   `toml:"name"` // nome único do nó; padrão: um uuid
+	Host      string   `toml:"host"`
+	Port      int      `toml:"port"`
+	Advertise string   `toml:"advertise"` // endereço das rotas para os outros nós; padrão host:port
+	Routes    []string `toml:"routes"`
+	Secret    string   `toml:"secret"` // obrigatório e igual em todos os nós
+}
+
+// LeafConfig liga este broker a um broker central como um cliente comum.
+type LeafConfig struct {
+	Enabled        bool          `toml:"enabled"`
+	URL            string        `toml:"url"`      // mq://usuario:senha@host:porta
+	Name           string        `toml:"name"`     // nome da conexão no central
+	Export         []string      `toml:"export"`   // tópicos locais enviados ao central
+	Import         []string      `toml:"import"`   // tópicos do central publicados aqui
+	Services       []string      `toml:"services"` // serviços do central oferecidos aqui
+	Buffer         int           `toml:"buffer"`   // mensagens guardadas com o uplink fora; padrão 1000
+	ReconnectWait  time.Duration `toml:"reconnect_wait"`
+	RequestTimeout time.Duration `toml:"request_timeout"`
+}
+
+// RaftConfig replica os buckets do KV entre os nós listados em Peers (três
+// ou mais). Cada nó tem o mesmo Peers, incluindo ele mesmo.
+type RaftConfig struct {
+	Enabled           bool              `toml:"enabled"`
+	ID                string            `toml:"id"` // chave deste nó em Peers
+	Host              string            `toml:"host"`
+	Port              int               `toml:"port"`
+	Peers             map[string]string `toml:"peers"`    // id -> host:porta do raft
+	LogFile           string            `toml:"log_file"` // padrão: kvfile + ".raft"
+	ElectionTimeout   time.Duration     `toml:"election_timeout"`
+	HeartbeatInterval time.Duration     `toml:"heartbeat_interval"`
+	SnapshotThreshold uint64            `toml:"snapshot_threshold"` // entradas mantidas no log
+	Secret            string            `toml:"secret"`             // obrigatório e igual em todos os nós
+}
+
 type MQTTConfig struct {
 	Enabled bool `toml:"enabled"`
 
-	Broker       string        `toml:"broker"`
-	Port         int           `toml:"port"`
-	KeepAlive    time.Duration `toml:"keep_alive"`
-	CleanSession bool          `toml:"clean_session"`
-	QoS          int           `toml:"qos"`
+	Broker        string        `toml:"broker"`
+	Port          int           `toml:"port"`
+	KeepAlive     time.Duration `toml:"keep_alive"`
+	QoS           int           `toml:"qos"`
+	RetryInterval time.Duration `toml:"retry_interval"`
 }
 type MQConfig struct {
 	Enabled  bool   `toml:"enabled"`
@@ -39,12 +113,86 @@ type MQConfig struct {
 	Port     int    `toml:"port"`
 	Username string `toml:"username"`
 	Password string `toml:"password"`
+	Users    []User `toml:"users"`
+
+	// Por quanto tempo uma conexão que caiu pode ser retomada com as mesmas
+	// inscrições e serviços (0 desliga) e quantas mensagens ficam guardadas.
+	SessionGrace time.Duration `toml:"session_grace"`
+	SessionQueue int           `toml:"session_queue"`
+
+	StatsInterval   time.Duration `toml:"stats_interval"`   // $SYS.stats; 0 desliga
+	ShutdownTimeout time.Duration `toml:"shutdown_timeout"` // prazo para drenar no encerramento
+
+	// O broker manda PING a cada PingInterval e derruba quem deixar
+	// MaxPingsOut sem resposta; IdleTimeout derruba quem não manda nada além
+	// de PING/PONG; AuthTimeout é o prazo para o AUTH. Zero desliga, exceto
+	// AuthTimeout (padrão 5s) e MaxPingsOut (padrão 2).
+	PingInterval time.Duration `toml:"ping_interval"`
+	MaxPingsOut  int           `toml:"max_pings_out"`
+	IdleTimeout  time.Duration `toml:"idle_timeout"`
+	AuthTimeout  time.Duration `toml:"auth_timeout"`
+
+	RateLimit RateLimitConfig `toml:"rate_limit"`
+	Limits    Limits          `toml:"limits"`
+
+	SlowConsumer SlowConsumerConfig `toml:"slow_consumer"`
+}
+
+// SlowConsumerConfig limita a fila de saída de cada cliente. Quando ela
+// enche, Policy decide: drop_newest descarta o frame novo, drop_oldest o
+// mais antigo da fila e disconnect derruba o cliente.
+type SlowConsumerConfig struct {
+	MaxPending      int    `toml:"max_pending"`       // frames; padrão 4096
+	MaxPendingBytes int    `toml:"max_pending_bytes"` // padrão 16 MiB
+	Policy          string `toml:"policy"`            // padrão drop_newest
 }
 
+// Limits são os limites fixos do broker, anunciados aos clientes no CNN.
+// Zero em max_payload e max_line usa o padrão; nos demais não limita.
+type Limits struct {
+	MaxPayload       int `toml:"max_payload"`       // bytes; padrão 1 MiB
+	MaxLine          int `toml:"max_line"`          // bytes de um frame JSON; padrão 4 MiB
+	MaxConnections   int `toml:"max_connections"`   // clientes conectados
+	MaxSubscriptions int `toml:"max_subscriptions"` // por conexão
+	MaxServices      int `toml:"max_services"`      // por conexão
+	MaxBatchItems    int `toml:"max_batch_items"`   // itens de um BATCH; padrão 1000
+}
+
+// RateLimitConfig limita o que os clientes mandam por segundo (token
+// bucket). Conn vale para cada conexão e User para a soma das conexões de
+// um usuário; o usuário pode ter o próprio rate_limit em [[mq.users]].
+type RateLimitConfig struct {
+	Conn RateLimit `toml:"conn"`
+	User RateLimit `toml:"user"`
+
+	// Quantas recusas dentro de StrikeWindow derrubam a conexão; 0 nunca
+	// derruba.
+	Strikes      int           `toml:"strikes"`
+	StrikeWindow time.Duration `toml:"strike_window"` // padrão 10s
+}
+
+// RateLimit é por segundo; zero libera. Pub/sub (PUB, APUB, SEND, SUB, SER)
+// e armazenamento (KV e DB) têm orçamentos separados. Cada item de um BATCH
+// é cobrado na classe do próprio comando.
+type RateLimit struct {
+	Messages     float64 `toml:"messages"`      // frames de pub/sub
+	Bytes        float64 `toml:"bytes"`         // bytes de pub/sub
+	Requests     float64 `toml:"requests"`      // REQ
+	Storage      float64 `toml:"storage"`       // comandos de armazenamento
+	StorageBytes float64 `toml:"storage_bytes"` // bytes de armazenamento
+}
+
+// User é um usuário extra do broker. Publish e Subscribe são listas de
+// tópicos (aceitam curinga) permitidos; lista vazia libera tudo.
 type User struct {
-	Username string `toml:"username"`
-	Password string `toml:"password"`
-	IsAdmin  bool   `toml:"is_admin"`
+	Username  string   `toml:"username"`
+	Password  string   `toml:"password"`
+	IsAdmin   bool     `toml:"is_admin"`
+	Token     string   `toml:"token"` // alternativa ao basic auth no HTTP
+	Publish   []string `toml:"publish"`
+	Subscribe []string `toml:"subscribe"`
+
+	RateLimit RateLimit `toml:"rate_limit"` // substitui mq.rate_limit.user no que não for zero
 }
 
 type LogsConfig struct {
@@ -54,16 +202,33 @@ type LogsConfig struct {
 	MaxBackups int    `toml:"maxBackups"` // quantidade de backups
 	MaxAge     int    `toml:"maxAge"`     // em dias
 	Compress   bool   `toml:"compress"`   // compactar backups
+
+	Level        string `toml:"level"`         // debug, info, warn, error; padrão info
+	Format       string `toml:"format"`        // text ou json; padrão text
+	ShowPayloads bool   `toml:"show_payloads"` // sem isso os payloads saem ocultos
 }
 
 type ProcConfig struct {
 	MaxProc int `toml:"maxProc"` // número máximo de processos
 }
 
+// GetConfig lê e valida a configuração e liga os logs.
 func GetConfig() (*ServerConfig, error) {
-	configFile := "config.toml"
-	if len(os.Args) > 1 {
-		configFile = os.Args[1]
+	config, err := ReadConfig()
+	if err != nil {
+		return nil, err
+	}
+	SetupLogger(config.Logs)
+	return config, nil
+}
+
+// ReadConfig lê o arquivo (--config, ou config.toml), aplica as variáveis
+// MQ_* e as flags por cima e valida, sem aplicar nada; o reload usa para só
+// mexer nos logs se tudo der certo.
+func ReadConfig() (*ServerConfig, error) {
+	configFile := cmdline.Config
+	if configFile == "" {
+		configFile = "config.toml"
 	}
 
 	// Lê o arquivo de configuração
@@ -76,9 +241,180 @@ func GetConfig() (*ServerConfig, error) {
 	var config ServerConfig
 	err = toml.Unmarshal(configData, &config)
 	if err != nil {
+		return nil, fmt.Errorf("%s: %v", configFile, err)
+	}
+	if err := EnvOverrides(&config); err != nil {
 		return nil, err
 	}
+	cmdline.apply(&config)
 
-	SetupLogger(config.Logs)
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: invalid configuration:\n%v", configFile, err)
+	}
 	return &config, nil
 }
+
+// Validate confere a configuração antes do broker subir e devolve todos os
+// problemas de uma vez, um por linha.
+func (c *ServerConfig) Validate() error {
+	errs := []error{}
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.MQ.Username == "" {
+		fail("mq.username is required")
+	}
+	if c.MQ.Password == "" {
+		fail("mq.password is required")
+	}
+	if c.MQ.FileKV == "" {
+		fail("mq.kvfile is required")
+	} else if dir := filepath.Dir(c.MQ.FileKV); !isDir(dir) {
+		fail("mq.kvfile: directory %s does not exist", dir)
+	}
+	seen := map[string]bool{c.MQ.Username: true}
+	for i, user := range c.MQ.Users {
+		switch {
+		case user.Username == "":
+			fail("mq.users[%d]: username is required", i)
+		case seen[user.Username]:
+			fail("mq.users[%d]: duplicate username %q", i, user.Username)
+		case user.Password == "" && user.Token == "":
+			fail("mq.users[%d] (%s): password or token is required", i, user.Username)
+		}
+		seen[user.Username] = true
+	}
+	if c.MQ.SessionQueue < 0 {
+		fail("mq.session_queue must not be negative")
+	}
+	rate := func(name string, r RateLimit) {
+		if r.Messages < 0 || r.Bytes < 0 || r.Requests < 0 || r.Storage < 0 || r.StorageBytes < 0 {
+			fail("%s: rates must not be negative", name)
+		}
+	}
+	rate("mq.rate_limit.conn", c.MQ.RateLimit.Conn)
+	rate("mq.rate_limit.user", c.MQ.RateLimit.User)
+	for i, user := range c.MQ.Users {
+		rate(fmt.Sprintf("mq.users[%d].rate_limit", i), user.RateLimit)
+	}
+	limits := c.MQ.Limits
+	if limits.MaxPayload < 0 || limits.MaxLine < 0 || limits.MaxConnections < 0 ||
+		limits.MaxSubscriptions < 0 || limits.MaxServices < 0 || limits.MaxBatchItems < 0 {
+		fail("mq.limits must not be negative")
+	}
+	if limits.MaxPayload > 0 && limits.MaxLine > 0 && limits.MaxLine < limits.MaxPayload {
+		fail("mq.limits.max_line must not be smaller than max_payload")
+	}
+	slow := c.MQ.SlowConsumer
+	if slow.MaxPending < 0 || slow.MaxPendingBytes < 0 {
+		fail("mq.slow_consumer limits must not be negative")
+	}
+	switch slow.Policy {
+	case "", "drop_newest", "drop_oldest", "disconnect":
+	default:
+		fail("mq.slow_consumer.policy must be drop_newest, drop_oldest or disconnect")
+	}
+	if c.MQ.MaxPingsOut < 0 {
+		fail("mq.max_pings_out must not be negative")
+	}
+	if c.MQ.RateLimit.Strikes < 0 {
+		fail("mq.rate_limit.strikes must not be negative")
+	}
+
+	// portas dos listeners ligados: válidas e sem repetir
+	ports := map[int]string{}
+	port := func(name string, enabled bool, p int) {
+		if !enabled {
+			return
+		}
+		if p <= 0 || p > 65535 {
+			fail("%s: invalid port %d", name, p)
+			return
+		}
+		if other, ok := ports[p]; ok {
+			fail("%s: port %d already used by %s", name, p, other)
+			return
+		}
+		ports[p] = name
+	}
+	port("mq.port", true, c.MQ.Port)
+	port("http.port", c.HTTP.Enabled, c.HTTP.Port)
+	port("websocket.port", c.WebSocket.Enabled, c.WebSocket.Port)
+	port("mqtt.port", c.MQTT.Enabled, c.MQTT.Port)
+	port("cluster.port", c.Cluster.Enabled, c.Cluster.Port)
+	port("raft.port", c.Raft.Enabled, c.Raft.Port)
+
+	tls := func(name string, enabled, https bool, certFile, keyFile string) {
+		if enabled && https && (certFile == "" || keyFile == "") {
+			fail("%s: use_https needs cert_file and key_file", name)
+		}
+	}
+	tls("http", c.HTTP.Enabled, c.HTTP.UseHTTPS, c.HTTP.CertFile, c.HTTP.KeyFile)
+	tls("websocket", c.WebSocket.Enabled, c.WebSocket.UseHTTPS, c.WebSocket.CertFile, c.WebSocket.KeyFile)
+
+	if _, err := ParseLevel(c.Logs.Level); err != nil {
+		fail("logs.level: %v", err)
+	}
+	if c.Logs.Format != "" && c.Logs.Format != "text" && c.Logs.Format != "json" {
+		fail("logs.format must be text or json")
+	}
+	if c.MQTT.Enabled && c.MQTT.QoS != 0 && c.MQTT.QoS != 1 {
+		fail("mqtt.qos must be 0 or 1")
+	}
+	// o listener de rotas aceita qualquer um que saiba o segredo; vazio
+	// seria aceitar qualquer um
+	if c.Cluster.Enabled && c.Cluster.Secret == "" {
+		fail("cluster.secret is required when the cluster is enabled")
+	}
+	if c.Leaf.Enabled && !strings.HasPrefix(c.Leaf.URL, "mq://") {
+		fail("leaf.url must be mq://user:password@host:port")
+	}
+	if c.Leaf.Buffer < 0 {
+		fail("leaf.buffer must not be negative")
+	}
+	if c.Raft.Enabled {
+		if _, ok := c.Raft.Peers[c.Raft.ID]; !ok {
+			fail("raft.id %q is not in raft.peers", c.Raft.ID)
+		}
+		if len(c.Raft.Peers) < 3 {
+			fail("raft.peers needs at least 3 nodes, got %d", len(c.Raft.Peers))
+		}
+		if c.Raft.Secret == "" {
+			fail("raft.secret is required when raft is enabled")
+		}
+	}
+
+	durations := map[string]time.Duration{
+		"mq.session_grace":            c.MQ.SessionGrace,
+		"mq.stats_interval":           c.MQ.StatsInterval,
+		"mq.shutdown_timeout":         c.MQ.ShutdownTimeout,
+		"mq.ping_interval":            c.MQ.PingInterval,
+		"mq.idle_timeout":             c.MQ.IdleTimeout,
+		"mq.auth_timeout":             c.MQ.AuthTimeout,
+		"mq.rate_limit.strike_window": c.MQ.RateLimit.StrikeWindow,
+		"websocket.ping_interval":     c.WebSocket.PingInterval,
+		"mqtt.keep_alive":             c.MQTT.KeepAlive,
+		"mqtt.retry_interval":         c.MQTT.RetryInterval,
+		"leaf.reconnect_wait":         c.Leaf.ReconnectWait,
+		"leaf.request_timeout":        c.Leaf.RequestTimeout,
+		"raft.election_timeout":       c.Raft.ElectionTimeout,
+		"raft.heartbeat_interval":     c.Raft.HeartbeatInterval,
+	}
+	for name, d := range durations {
+		if d < 0 {
+			fail("%s must not be negative", name)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateClusterSecret(t *testing.T) {
+	config := ServerConfig{MQ: MQConfig{Username: "root", Password: "pw", Port: 4000}}
+	config.Cluster = ClusterConfig{Enabled: true, Port: 4001, Routes: []string{"10.0.0.2:4001"}}
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "cluster.secret") {
+		t.Fatalf("err = %v", err)
+	}
+	config.Cluster.Secret = "s"
+	if err := config.Validate(); err != nil && strings.Contains(err.Error(), "cluster.secret") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestValidateRaftSecret(t *testing.T) {
+	config := ServerConfig{MQ: MQConfig{Username: "root", Password: "pw", Port: 4000}}
+	config.Raft = RaftConfig{
+		Enabled: true, ID: "n1", Port: 4071,
+		Peers: map[string]string{"n1": "a:4071", "n2": "b:4071", "n3": "c:4071"},
+	}
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "raft.secret") {
+		t.Fatalf("err = %v", err)
+	}
+	config.Raft.Secret = "s"
+	if err := config.Validate(); err != nil && strings.Contains(err.Error(), "raft.secret") {
+		t.Fatalf("err = %v", err)
+	}
+}
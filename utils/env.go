@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// envPrefix é o prefixo das variáveis que sobrescrevem o arquivo. O nome
+// segue as chaves do TOML: MQ_<SEÇÃO>_<CAMPO>, por exemplo MQ_HTTP_PORT ou
+// MQ_HTTP_METRICS_ENABLED; os campos de [mq] não repetem a seção (MQ_PORT,
+// MQ_KVFILE). Listas são separadas por vírgula, mapas são "k=v,k2=v2" e
+// listas de tabelas (MQ_USERS) vão como array inline do TOML:
+// MQ_USERS='[{username = "dev", password = "x", publish = ["dev.*"]}]'.
+const envPrefix = "MQ_"
+
+// EnvOverrides aplica as variáveis MQ_* em config.
+func EnvOverrides(config *ServerConfig) error {
+	return envStruct(reflect.ValueOf(config).Elem(), "")
+}
+
+// EnvNames lista as variáveis aceitas, na ordem dos campos.
+func EnvNames() []string {
+	names := []string{}
+	envWalk(reflect.TypeOf(ServerConfig{}), "", func(name string) {
+		names = append(names, name)
+	})
+	return names
+}
+
+func envName(prefix, tag string) string {
+	key := strings.ToUpper(strings.Split(tag, ",")[0])
+	if prefix == "" {
+		// [mq] é a seção principal: MQ_PORT e não MQ_MQ_PORT
+		if key == "MQ" {
+			return "MQ"
+		}
+		return envPrefix + key
+	}
+	return prefix + "_" + key
+}
+
+func envWalk(t reflect.Type, prefix string, fn func(name string)) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("toml")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := envName(prefix, tag)
+		if field.Type.Kind() == reflect.Struct {
+			envWalk(field.Type, name, fn)
+			continue
+		}
+		fn(name)
+	}
+}
+
+func envStruct(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("toml")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := envName(prefix, tag)
+		if field.Type.Kind() == reflect.Struct {
+			if err := envStruct(v.Field(i), name); err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setField(v.Field(i), value); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(f reflect.Value, value string) error {
+	if f.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	case reflect.Slice:
+		if f.Type().Elem().Kind() == reflect.Struct {
+			// decodifica "v = <valor>" num struct com o campo certo
+			wrapper := reflect.New(reflect.StructOf([]reflect.StructField{{
+				Name: "V",
+				Type: f.Type(),
+				Tag:  `toml:"v"`,
+			}}))
+			if err := toml.Unmarshal([]byte("v = "+value), wrapper.Interface()); err != nil {
+				return err
+			}
+			f.Set(wrapper.Elem().Field(0))
+			return nil
+		}
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		m := map[string]string{}
+		for _, pair := range strings.Split(value, ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			k, v, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("expected key=value, got %q", pair)
+			}
+			m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+		f.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported type %s", f.Type())
+	}
+	return nil
+}
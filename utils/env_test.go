@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnvOverridesRateLimit(t *testing.T) {
+	t.Setenv("MQ_RATE_LIMIT_CONN_MESSAGES", "5")
+	t.Setenv("MQ_RATE_LIMIT_USER_BYTES", "1024.5")
+	config := ServerConfig{}
+	if err := EnvOverrides(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.MQ.RateLimit.Conn.Messages != 5 || config.MQ.RateLimit.User.Bytes != 1024.5 {
+		t.Fatalf("rate_limit = %+v", config.MQ.RateLimit)
+	}
+
+	t.Setenv("MQ_RATE_LIMIT_CONN_MESSAGES", "five")
+	if err := EnvOverrides(&config); err == nil || !strings.Contains(err.Error(), "MQ_RATE_LIMIT_CONN_MESSAGES") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+// Toda variável listada por EnvNames (o --env) precisa de um tipo que
+// setField aceite.
+func TestEnvNamesAllSupported(t *testing.T) {
+	samples := map[reflect.Kind]string{
+		reflect.String:  "x",
+		reflect.Bool:    "true",
+		reflect.Int:     "1",
+		reflect.Int64:   "1s",
+		reflect.Uint64:  "1",
+		reflect.Float64: "1.5",
+		reflect.Slice:   "a,b",
+		reflect.Map:     "k=v",
+	}
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			tag := field.Tag.Get("toml")
+			if tag == "" || tag == "-" {
+				continue
+			}
+			name := envName(prefix, tag)
+			if field.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), name)
+				continue
+			}
+			value := samples[field.Type.Kind()]
+			if field.Type.Kind() == reflect.Int64 && field.Type.Name() != "Duration" {
+				value = "1"
+			}
+			if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+				value = "[]"
+			}
+			if err := setField(v.Field(i), value); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+	walk(reflect.ValueOf(&ServerConfig{}).Elem(), "")
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// Flags são as opções da linha de comando. As preenchidas valem mais que o
+// arquivo e as variáveis MQ_*, inclusive no reload.
+type Flags struct {
+	Config        string
+	CheckConfig   bool
+	PrintEnv      bool
+	Broker        string
+	Port          int
+	KVFile        string
+	HTTPPort      int
+	WebSocketPort int
+	MQTTPort      int
+}
+
+var cmdline Flags
+
+// ParseFlags lê os argumentos (sem o nome do programa). O caminho do
+// arquivo também pode vir como primeiro argumento solto, como antes. Os
+// erros e o uso já saem no stderr; com -h o erro é flag.ErrHelp.
+func ParseFlags(args []string) (Flags, error) {
+	f := Flags{}
+	fs := flag.NewFlagSet("mq", flag.ContinueOnError)
+	fs.StringVar(&f.Config, "config", "", "arquivo de configuração (padrão config.toml)")
+	fs.BoolVar(&f.CheckConfig, "check-config", false, "valida a configuração e sai")
+	fs.BoolVar(&f.PrintEnv, "env", false, "lista as variáveis MQ_* aceitas e sai")
+	fs.StringVar(&f.Broker, "broker", "", "endereço do listener TCP (mq.broker)")
+	fs.IntVar(&f.Port, "port", 0, "porta do listener TCP (mq.port)")
+	fs.StringVar(&f.KVFile, "kvfile", "", "arquivo do banco (mq.kvfile)")
+	fs.IntVar(&f.HTTPPort, "http-port", 0, "porta do gateway HTTP (http.port)")
+	fs.IntVar(&f.WebSocketPort, "ws-port", 0, "porta do WebSocket (websocket.port)")
+	fs.IntVar(&f.MQTTPort, "mqtt-port", 0, "porta do MQTT (mqtt.port)")
+	if err := fs.Parse(args); err != nil {
+		return f, err
+	}
+	var err error
+	switch {
+	case fs.NArg() > 1:
+		err = fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args()[1:], " "))
+	case fs.NArg() == 1 && f.Config != "":
+		err = fmt.Errorf("config file given twice: %s and %s", f.Config, fs.Arg(0))
+	case fs.NArg() == 1:
+		f.Config = fs.Arg(0)
+	}
+	if err != nil {
+		// mesmo formato dos erros do próprio flag
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		return f, err
+	}
+	cmdline = f
+	return f, nil
+}
+
+func (f Flags) apply(config *ServerConfig) {
+	if f.Broker != "" {
+		config.MQ.Broker = f.Broker
+	}
+	if f.Port != 0 {
+		config.MQ.Port = f.Port
+	}
+	if f.KVFile != "" {
+		config.MQ.FileKV = f.KVFile
+	}
+	if f.HTTPPort != 0 {
+		config.HTTP.Port = f.HTTPPort
+	}
+	if f.WebSocketPort != 0 {
+		config.WebSocket.Port = f.WebSocketPort
+	}
+	if f.MQTTPort != 0 {
+		config.MQTT.Port = f.MQTTPort
+	}
+}
@@ -1,23 +1,80 @@
 package utils
 
 import (
-	"log"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-// Configura o logger para salvar em arquivo com rotação
+var (
+	logMu        sync.Mutex
+	logFile      *lumberjack.Logger
+	showPayloads atomic.Bool
+)
+
+// Configura o slog como logger padrão (o pacote log passa por ele também):
+// nível, formato text ou json e, com Enabled, arquivo com rotação; sem ele
+// vai para o stderr. Pode ser chamado de novo no reload: o arquivo anterior
+// é fechado.
 func SetupLogger(config LogsConfig) {
-	if !config.Enabled {
+	logMu.Lock()
+	defer logMu.Unlock()
+	old := logFile
+	defer func() {
+		if old != nil && old != logFile {
+			old.Close()
+		}
+	}()
+
+	var out io.Writer = os.Stderr
+	logFile = nil
+	if config.Enabled {
+		logFile = &lumberjack.Logger{
+			Filename:   config.Filename,
+			MaxSize:    config.MaxSize, // megabytes
+			MaxBackups: config.MaxBackups,
+			MaxAge:     config.MaxAge,   // days
+			Compress:   config.Compress, // gzip
+		}
+		out = logFile
+	}
+	level, _ := ParseLevel(config.Level)
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(out, opts)
+	if config.Format == "json" {
+		handler = slog.NewJSONHandler(out, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+	showPayloads.Store(config.ShowPayloads)
+}
+
+// ParseLevel aceita debug, info, warn e error; vazio é info.
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q", level)
+}
 
-		return
+// Payload é o atributo "payload" de uma linha de log. Payloads podem ter
+// dados sensíveis, então só aparecem com logs.show_payloads; senão fica só
+// o tamanho.
+func Payload(payload string) slog.Attr {
+	if showPayloads.Load() {
+		return slog.String("payload", payload)
 	}
-	log.SetOutput(&lumberjack.Logger{
-		Filename:   config.Filename,
-		MaxSize:    config.MaxSize, // megabytes
-		MaxBackups: config.MaxBackups,
-		MaxAge:     config.MaxAge,   // days
-		Compress:   config.Compress, // gzip
-	})
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	return slog.String("payload", fmt.Sprintf("[redacted %d bytes]", len(payload)))
 }